@@ -0,0 +1,29 @@
+package api
+
+import (
+	"crypto/subtle"
+	"github.com/kxlt/imageresizer/config"
+	"net/http"
+	"strings"
+)
+
+// adminMiddleware refuses the requests to admin endpoints without the
+// admin.token bearer token with a 401. The endpoints are not found when
+// admin.token is unset.
+func (api *Api) adminMiddleware(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.C.AdminToken == "" {
+			respondWithErr(w, http.StatusNotFound)
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if token == auth ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(config.C.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			respondWithErr(w, http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
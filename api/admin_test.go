@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+	"github.com/kxlt/imageresizer/collections"
+	"github.com/kxlt/imageresizer/config"
+	"github.com/kxlt/imageresizer/store"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAdminMiddleware(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("../testdata", "TestAdminMiddleware")
+	if err != nil {
+		t.Fatalf("Error creating temp dir")
+	}
+	defer os.RemoveAll(tmpdir)
+	defer func(token string) { config.C.AdminToken = token }(config.C.AdminToken)
+	api := &Api{
+		Originals:  store.NewTiered(store.NewFileStore(tmpdir + "/originals")),
+		Thumbnails: store.NewTiered(store.NewFileStore(tmpdir + "/thumbnails")),
+		Tiers:      collections.NewSyncStrSet(),
+	}
+	handler := api.adminMiddleware(api.handleBatchDeletes())
+
+	tests := []struct {
+		token, auth string
+		status      int
+	}{
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "secret", http.StatusUnauthorized},
+		{"", "Bearer ", http.StatusNotFound},
+		{"secret", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		config.C.AdminToken = tt.token
+		r := httptest.NewRequest(http.MethodPost, "/api/batch",
+			strings.NewReader(`{"paths":["missing.jpg"]}`))
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%q with token %q responded %d, want %d", tt.auth, tt.token, w.Code, tt.status)
+		}
+		if tt.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%q with token %q has no WWW-Authenticate", tt.auth, tt.token)
+		}
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("../testdata", "TestAdminRoutes")
+	if err != nil {
+		t.Fatalf("Error creating temp dir")
+	}
+	defer os.RemoveAll(tmpdir)
+	defer func(token string) { config.C.AdminToken = token }(config.C.AdminToken)
+	config.C.AdminToken = ""
+	api := &Api{
+		Originals:  store.NewTiered(store.NewFileStore(tmpdir + "/originals")),
+		Thumbnails: store.NewTiered(store.NewFileStore(tmpdir + "/thumbnails")),
+		Tiers:      collections.NewSyncStrSet(),
+		Router:     mux.NewRouter().StrictSlash(true),
+	}
+	api.routes()
+
+	for _, path := range []string{"/api/batch", "/api/purge", "/api/warm"} {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"paths":["a.jpg"]}`))
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("POST %s without admin.token responded %d, want %d", path, w.Code, http.StatusNotFound)
+		}
+	}
+	if names, _ := api.Originals.List(context.Background(), ""); len(names) > 0 {
+		t.Errorf("Admin requests should not create originals, got %v", names)
+	}
+}
@@ -1,14 +1,23 @@
 package api
 
 import (
+	"context"
 	"github.com/gorilla/mux"
 	"github.com/kxlt/imageresizer/collections"
 	"github.com/kxlt/imageresizer/config"
+	"github.com/kxlt/imageresizer/cutout"
+	"github.com/kxlt/imageresizer/imager"
 	"github.com/kxlt/imageresizer/store"
+	"github.com/kxlt/imageresizer/upscale"
+	"github.com/kxlt/imageresizer/video"
 	"github.com/rcrowley/go-metrics"
 	"github.com/rcrowley/go-metrics/exp"
+	"io/ioutil"
 	"log"
-	"path"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,63 +27,127 @@ func init() {
 
 // Api type embeds a router
 type Api struct {
-	Originals  *store.TwoTier
+	Originals  store.Cache
 	Thumbnails store.Cache
 	Tiers      *collections.SyncStrSet
 	Etags      *collections.SyncStrSet
+	// Watermarks are keyed by namespace, "" being the default
+	Watermarks map[string]*imager.Watermark
+	// FFmpeg transcodes animations to videos, nil when disabled
+	FFmpeg *video.FFmpeg
+	// Upscaler enlarges originals thumbnails are larger than, nil when
+	// disabled
+	Upscaler upscale.Upscaler
+	// Cutout removes the background of originals, nil when disabled
+	Cutout cutout.Remover
+	// Fetcher downloads the originals uploaded by URL, nil when disabled
+	Fetcher *http.Client
+	// Presets are the named transformations served under /p/
+	Presets map[string]*preset
+	// PHashes index the perceptual hashes of originals by path, when
+	// enabled
+	PHashes *collections.SyncMap
+	// Jobs are the background jobs by id, see startJob
+	Jobs *collections.SyncMap
+	// jobSlots bounds the jobs running at once to jobs.workers
+	jobSlots chan struct{}
+	// activeJobs are the jobs queued or running by key, pendingJobs their
+	// count along with the jobs without a key
+	activeJobs  map[string]*job
+	pendingJobs int
+	jobsMu      sync.Mutex
+	// loaded is set once the caches are loaded
+	loaded int32
 	*mux.Router
 }
 
 func NewApi(ready chan<- bool) *Api {
-	var origStore store.Store
-	if config.C.S3Enable {
-		var err error
-		origStore, err = store.NewS3Store(&store.S3Config{
-			Region: config.C.S3Region,
-			Bucket: config.C.S3Bucket,
-			Prefix: config.C.S3Prefix,
-		})
+	if config.C.FaceCascade != "" {
+		cascade, err := ioutil.ReadFile(config.C.FaceCascade)
+		if err == nil {
+			err = imager.SetFaceCascade(cascade)
+		}
 		if err != nil {
-			log.Fatalln("S3 store could not be initialized")
+			log.Fatalln("Face detection cascade could not be loaded:", err)
 		}
-	} else {
-		origStore = store.NewFileStore(config.C.LocalPrefix)
 	}
+	imager.SetAnimationLimits(
+		config.C.AnimationMaxFrames,
+		time.Duration(config.C.AnimationMaxDuration)*time.Millisecond)
+	imager.SetSVGLimits(config.C.SVGMaxSize, config.C.SVGMaxElements)
+	imager.SetSourceLimits(
+		int(config.C.SourceMaxMegapixels*1000000),
+		config.C.SourceMaxFrames)
+	origStore := NewOriginStore()
 	var origCache store.Cache
 	if config.C.CacheOrigEnable {
-		origCache = store.NewFileCache(
+		c := store.NewFileCache(
 			config.C.CacheOrigPath,
 			config.C.CacheOrigMaxSize,
 			config.C.CacheOrigShards)
+		c.ShardDepth = config.C.CacheOrigShardDepth
+		origCache = c
+		if config.C.MetricsStores {
+			origCache = store.NewInstrumented(c, "origin.cache")
+		}
 	}
-	var thumbCache store.Cache
-	if config.C.CacheThumbEnable {
-		thumbCache = store.NewFileCache(
-			config.C.CacheThumbPath,
-			config.C.CacheThumbMaxSize,
-			config.C.CacheThumbShards)
-	} else {
-		thumbCache = &store.NoopCache{}
+	var originals store.Cache = store.NewTiered(origCache, origStore)
+	if config.C.OriginDedup {
+		// refs and blobs both go through the cache
+		originals = store.NewDedup(originals)
+	}
+	// quotas count originals by name, not the refs and blobs of dedup
+	originals = WithQuota(originals).(store.Cache)
+	if config.C.ServerReadOnly {
+		originals = store.NewReadOnly(originals)
 	}
 	var etags *collections.SyncStrSet
 	if config.C.EtagCacheEnable {
 		etags = collections.NewSyncStrSet()
 	}
 	api := &Api{
-		Originals: &store.TwoTier{
-			Store: origStore,
-			Cache: origCache,
-		},
-		Thumbnails: thumbCache,
+		Originals:  originals,
+		Thumbnails: NewThumbnails(),
 		Tiers:      collections.NewSyncStrSet(),
 		Etags:      etags,
+		Watermarks: loadWatermarks(),
+		Presets:    loadPresets(),
+		Jobs:       collections.NewSyncMap(),
+		jobSlots:   make(chan struct{}, config.C.JobsWorkers),
+		activeJobs: map[string]*job{},
 		Router:     mux.NewRouter().StrictSlash(true),
 	}
+	if config.C.VideoFFmpeg != "" {
+		api.FFmpeg = video.NewFFmpeg(config.C.VideoFFmpeg)
+	}
+	if config.C.UpscaleURL != "" {
+		api.Upscaler = upscale.NewHTTP(
+			config.C.UpscaleURL,
+			time.Duration(config.C.UpscaleTimeout)*time.Millisecond,
+			config.C.UpscaleMaxSize)
+	}
+	if config.C.CutoutURL != "" {
+		api.Cutout = cutout.NewHTTP(
+			config.C.CutoutURL,
+			time.Duration(config.C.CutoutTimeout)*time.Millisecond,
+			config.C.CutoutMaxSize)
+	}
+	if config.C.UploadFetchEnable {
+		api.Fetcher = newFetchClient(
+			time.Duration(config.C.UploadFetchTimeout) * time.Millisecond)
+	}
+	if config.C.PHashEnable {
+		api.PHashes = collections.NewSyncMap()
+		go api.indexPHashes()
+	}
 	go api.initCacheLoader(ready)
 	api.initCacheManager()
 	if config.C.EtagCacheEnable {
 		api.initEtagManager()
 	}
+	if config.C.GCEnable {
+		api.initThumbnailGC()
+	}
 	api.routes()
 	return api
 }
@@ -88,13 +161,16 @@ func (api *Api) initCacheLoader(ready chan<- bool) {
 	}
 	api.Thumbnails.LoadCache(func(item interface{}) error {
 		filename := item.(string)
-		api.Tiers.Add(path.Dir(filename))
+		if tier, _, ok := splitThumbnailKey(filename); ok {
+			api.Tiers.Add(tier)
+		}
 		return nil
 	})
 	if err != nil {
 		ready <- false
 		return
 	}
+	atomic.StoreInt32(&api.loaded, 1)
 	ready <- true
 	log.Println("Caches loaded")
 }
@@ -121,8 +197,24 @@ func (api *Api) initEtagManager() {
 	}()
 }
 
-func (api *Api) removeThumbnails(filePath string) {
+// splitThumbnailKey splits the key of a cached thumbnail into its tier and
+// the path of its original, which may hold slashes. Resize tiers are 3
+// segments, e.g. 300x200/crop/smart, the tiers of data derived from
+// originals 1, e.g. blurhash.
+func splitThumbnailKey(key string) (string, string, bool) {
+	n := 2
+	if i := strings.Index(key, "/"); i > 0 && tierDimensions.MatchString(key[:i]) {
+		n = 4
+	}
+	parts := strings.SplitN(key, "/", n)
+	if len(parts) < n || parts[n-1] == "" {
+		return "", "", false
+	}
+	return strings.Join(parts[:n-1], "/"), parts[n-1], true
+}
+
+func (api *Api) removeThumbnails(ctx context.Context, filePath string) {
 	api.Tiers.Walk(func(item string) {
-		api.Thumbnails.Remove(item + "/" + filePath)
+		api.Thumbnails.Remove(ctx, item+"/"+filePath)
 	})
 }
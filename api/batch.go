@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/kxlt/imageresizer/store"
+	"github.com/rcrowley/go-metrics"
+	"io"
+	"net/http"
+	"os"
+)
+
+// maxBatchPaths is the number of originals a batch deletion removes at most
+const maxBatchPaths = 10000
+
+var errInvalidPath = errors.New("invalid path")
+
+// batchRequest lists the originals of a batch deletion, by path or prefix
+type batchRequest struct {
+	Paths  []string `json:"paths"`
+	Prefix string   `json:"prefix"`
+}
+
+// batchResult sums a batch deletion up, failures are keyed by path
+type batchResult struct {
+	Deleted []string          `json:"deleted"`
+	Failed  map[string]string `json:"failed"`
+}
+
+// add records the outcome of the deletion of path
+func (b *batchResult) add(path string, err error) {
+	if err == errInvalidPath {
+		b.Failed[path] = "invalid path"
+	} else if os.IsNotExist(err) {
+		b.Failed[path] = "not found"
+	} else if err != nil {
+		b.Failed[path] = err.Error()
+	} else {
+		b.Deleted = append(b.Deleted, path)
+	}
+}
+
+// deleteOriginal removes the original at path along with its thumbnails
+// and data derived from it
+func (api *Api) deleteOriginal(ctx context.Context, path string) error {
+	err := api.Originals.Remove(ctx, path)
+	if err == store.ErrReadOnly {
+		return err
+	}
+	// thumbnails of missing originals are stale too
+	api.removeThumbnails(ctx, path)
+	if api.PHashes != nil {
+		api.PHashes.Remove(path)
+	}
+	return err
+}
+
+// deleteBatch removes the originals at paths, reporting the outcome of
+// each, until the store turns out to be read-only
+func (api *Api) deleteBatch(ctx context.Context, paths []string, report func(path string, err error)) error {
+	for _, path := range paths {
+		if !validPath(path) {
+			report(path, errInvalidPath)
+			continue
+		}
+		err := api.deleteOriginal(ctx, path)
+		if err == store.ErrReadOnly {
+			return err
+		}
+		report(path, err)
+	}
+	return nil
+}
+
+// handleBatchDeletes removes the originals listed in the JSON body, e.g.
+// {"paths":["a.jpg","b.jpg"]} or {"prefix":"albums/2019/"}, responding
+// with the deleted ones and the reasons of the failures, or with a job
+// with Prefer: respond-async
+func (api *Api) handleBatchDeletes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t := metrics.GetOrRegisterTimer("api.batchdeletes.latency", nil)
+		t.Time(func() {
+			var req batchRequest
+			err := json.NewDecoder(io.LimitReader(r.Body, 1024*1024)).Decode(&req)
+			if err != nil || (len(req.Paths) == 0) == (req.Prefix == "") {
+				respondWithErr(w, http.StatusBadRequest)
+				return
+			}
+			paths := req.Paths
+			if req.Prefix != "" {
+				if paths, err = api.Originals.List(r.Context(), req.Prefix); err != nil {
+					respondWithErr(w, http.StatusInternalServerError)
+					return
+				}
+			}
+			if len(paths) > maxBatchPaths {
+				respondWithErr(w, http.StatusRequestEntityTooLarge)
+				return
+			}
+			if preferAsync(r) {
+				j, err := api.startJob("delete", "", func(ctx context.Context, j *job) error {
+					j.setTotal(len(paths))
+					return api.deleteBatch(ctx, paths, func(path string, err error) {
+						j.progress(err)
+					})
+				})
+				respondWithJob(w, j, err)
+				return
+			}
+			result := batchResult{Deleted: []string{}, Failed: map[string]string{}}
+			err = api.deleteBatch(r.Context(), paths, result.add)
+			if err == store.ErrReadOnly {
+				respondWithErr(w, http.StatusMethodNotAllowed)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(result)
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/kxlt/imageresizer/imager"
+	"net/http"
+)
+
+// serveBlurHash responds with the BlurHash of an original, rendered by
+// clients as a placeholder while the image loads
+func (api *Api) serveBlurHash() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buf, err := api.derived(r.Context(), "blurhash", mux.Vars(r)["path"], func(src []byte) ([]byte, error) {
+			src, err := api.still(r.Context(), src)
+			if err != nil {
+				return nil, err
+			}
+			hash, err := imager.BlurHash(r.Context(), src)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(map[string]string{"blurhash": hash})
+		})
+		respondWithDerived(w, buf, err)
+	}
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/kxlt/imageresizer/imager"
+	"net/http"
+)
+
+// paletteSize is the number of colors of palettes
+const paletteSize = 5
+
+// serveColors responds with the average color of an original and its
+// palette, the dominant color first, e.g. for placeholder backgrounds
+func (api *Api) serveColors() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buf, err := api.derived(r.Context(), "colors", mux.Vars(r)["path"], func(src []byte) ([]byte, error) {
+			src, err := api.still(r.Context(), src)
+			if err != nil {
+				return nil, err
+			}
+			average, colors, err := imager.Colors(r.Context(), src, paletteSize)
+			if err != nil {
+				return nil, err
+			}
+			palette := make([]string, len(colors))
+			for i, c := range colors {
+				palette[i] = c.Hex()
+			}
+			return json.Marshal(map[string]interface{}{
+				"average":  average.Hex(),
+				"dominant": palette[0],
+				"palette":  palette,
+			})
+		})
+		respondWithDerived(w, buf, err)
+	}
+}
@@ -0,0 +1,21 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/kxlt/imageresizer/imager"
+)
+
+// cutout returns the cutout of src when options ask for it, cached like the
+// data derived from originals, or src as is
+func (api *Api) cutout(ctx context.Context, path string, src []byte, options *imager.Options) ([]byte, error) {
+	if !options.RemoveBackground {
+		return src, nil
+	}
+	if api.Cutout == nil || !modelFormats[imager.GetImageType(src)] {
+		return nil, errors.New("background removal unavailable")
+	}
+	return api.derived(ctx, "cutout", path, func(src []byte) ([]byte, error) {
+		return api.Cutout.RemoveBackground(ctx, src)
+	})
+}
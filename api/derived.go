@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/kxlt/imageresizer/imager"
+	"net/http"
+)
+
+// errNoOriginal is returned for data derived from missing originals
+var errNoOriginal = errors.New("original not found")
+
+// derived returns the data compute derives from the original at path,
+// cached with the thumbnails under tier so it goes away with the original
+func (api *Api) derived(
+	ctx context.Context,
+	tier string,
+	path string,
+	compute func(src []byte) ([]byte, error)) ([]byte, error) {
+
+	key := tier + "/" + path
+	if buf, _ := api.Thumbnails.Get(ctx, key); buf != nil {
+		return buf, nil
+	}
+	src, err := api.Originals.Get(ctx, path)
+	if err != nil {
+		return nil, errNoOriginal
+	}
+	buf, err := compute(src)
+	if err != nil {
+		return nil, err
+	}
+	api.Tiers.Add(tier)
+	go api.Thumbnails.Put(context.Background(), key, buf)
+	return buf, nil
+}
+
+// still returns the first frame of video originals, and other originals as
+// they are
+func (api *Api) still(ctx context.Context, src []byte) ([]byte, error) {
+	if _, ok := videoFormats[imager.GetImageType(src)]; ok && api.FFmpeg != nil {
+		return api.FFmpeg.Frame(ctx, src, 0)
+	}
+	return src, nil
+}
+
+// respondWithDerived responds with the JSON derived from an original
+func respondWithDerived(w http.ResponseWriter, buf []byte, err error) {
+	if err == errNoOriginal {
+		respondWithErr(w, http.StatusNotFound)
+		return
+	}
+	if err == imager.ErrSourceTooLarge {
+		respondWithErr(w, http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		respondWithErr(w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf)
+}
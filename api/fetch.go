@@ -0,0 +1,192 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/kxlt/imageresizer/config"
+	"github.com/kxlt/imageresizer/imager"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxFetchRedirects is the number of redirects followed by uploads by URL
+const maxFetchRedirects = 5
+
+var (
+	errFetchURL       = errors.New("invalid fetch url")
+	errFetchForbidden = errors.New("fetch of a non public address")
+	errFetchType      = errors.New("fetched file is not an image or video")
+	errFetchTooLarge  = errors.New("fetched file too large")
+)
+
+// nonPublicNets are the networks uploads by URL can't reach besides the
+// loopback, link-local, multicast and unspecified addresses, so clients
+// can't have internal services requested (SSRF)
+var nonPublicNets = parseNets(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"240.0.0.0/4",
+	"fc00::/7",
+)
+
+func parseNets(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, nets[i], _ = net.ParseCIDR(cidr)
+	}
+	return nets
+}
+
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range nonPublicNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// newFetchClient returns the client of uploads by URL. Addresses are checked
+// once resolved, when connecting, so neither redirects nor DNS answers
+// changing between a check and the connection reach internal services.
+// Proxies are not used as they would connect instead.
+func newFetchClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return errFetchForbidden
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxFetchRedirects {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return errFetchURL
+			}
+			return nil
+		},
+	}
+}
+
+// fetchRequest is the JSON body of uploads by URL, e.g.
+// {"url":"https://example.com/photo.jpg"}
+type fetchRequest struct {
+	URL string `json:"url"`
+}
+
+// isFetch reports whether r uploads the original at a URL rather than in
+// its body
+func (api *Api) isFetch(r *http.Request) bool {
+	return api.Fetcher != nil &&
+		strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+}
+
+// fetch downloads the original at the URL of the JSON body of r. The
+// response must be an image or a video of at most upload.maxsize, its first
+// bytes are checked rather than trusting its Content-Type.
+func (api *Api) fetch(r *http.Request) (io.ReadCloser, error) {
+	var body fetchRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, 64*1024))
+	if err := dec.Decode(&body); err != nil {
+		return nil, errFetchURL
+	}
+	u, err := url.Parse(body.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errFetchURL
+	}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, errFetchURL
+	}
+	req.Header.Set("Accept", "image/*,video/*")
+	resp, err := api.Fetcher.Do(req.WithContext(r.Context()))
+	if err != nil {
+		if fetchForbidden(err) {
+			return nil, errFetchForbidden
+		}
+		if urlErr, ok := err.(*url.Error); ok && urlErr.Err == errFetchURL {
+			return nil, errFetchURL
+		}
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.New("fetch responded " + resp.Status)
+	}
+	if config.C.UploadMaxSize > 0 && resp.ContentLength > config.C.UploadMaxSize {
+		resp.Body.Close()
+		return nil, errFetchTooLarge
+	}
+	if !fetchableType(resp.Header.Get("Content-Type")) {
+		resp.Body.Close()
+		return nil, errFetchType
+	}
+	br := bufio.NewReaderSize(resp.Body, 1024)
+	head, _ := br.Peek(1024)
+	if imager.GetImageType(head) == imager.UNKNOWN {
+		resp.Body.Close()
+		return nil, errFetchType
+	}
+	return &fetchBody{Reader: br, Closer: resp.Body}, nil
+}
+
+// fetchableType reports whether a Content-Type may be an image or video,
+// generic types are left to the check of the content
+func fetchableType(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	return mediaType == "" || mediaType == "application/octet-stream" ||
+		mediaType == "application/pdf" ||
+		strings.HasPrefix(mediaType, "image/") || strings.HasPrefix(mediaType, "video/")
+}
+
+// fetchForbidden reports whether err comes from a refused address
+func fetchForbidden(err error) bool {
+	for err != nil {
+		switch e := err.(type) {
+		case *url.Error:
+			err = e.Err
+		case *net.OpError:
+			err = e.Err
+		default:
+			return err == errFetchForbidden
+		}
+	}
+	return false
+}
+
+type fetchBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"github.com/kxlt/imageresizer/config"
+	"github.com/rcrowley/go-metrics"
+	"log"
+	"os"
+	"time"
+)
+
+// initThumbnailGC periodically removes the thumbnails of deleted originals
+func (api *Api) initThumbnailGC() {
+	interval := time.Duration(config.C.GCInterval) * time.Millisecond
+	go func() {
+		for range time.Tick(interval) {
+			n, size, err := api.collectThumbnails(context.Background(), config.C.GCDryRun)
+			if err != nil {
+				log.Println("Thumbnail GC failed:", err)
+				continue
+			}
+			if config.C.GCDryRun {
+				log.Printf("Thumbnail GC (dry run): %d orphans, %d bytes", n, size)
+			} else if n > 0 {
+				log.Printf("Thumbnail GC: removed %d orphans, %d bytes", n, size)
+			}
+		}
+	}()
+}
+
+// collectThumbnails removes the thumbnails whose original doesn't exist
+// anymore and returns their number and total size. Nothing is removed in
+// dry run mode.
+func (api *Api) collectThumbnails(ctx context.Context, dryRun bool) (int, int64, error) {
+	names, err := api.Thumbnails.List(ctx, "")
+	if err != nil {
+		return 0, 0, err
+	}
+	removed := metrics.GetOrRegisterCounter("gc.thumbs.removed", nil)
+	reclaimed := metrics.GetOrRegisterCounter("gc.thumbs.reclaimed", nil)
+	// originals are shared by all the tiers of a thumbnail
+	exists := make(map[string]bool)
+	var (
+		count int
+		size  int64
+	)
+	for _, name := range names {
+		_, orig, ok := splitThumbnailKey(name)
+		if !ok {
+			continue
+		}
+		found, ok := exists[orig]
+		if !ok {
+			_, err := api.Originals.Stat(ctx, orig)
+			if err != nil && !os.IsNotExist(err) {
+				// can't tell, keep the thumbnail
+				continue
+			}
+			found = err == nil
+			exists[orig] = found
+		}
+		if found {
+			continue
+		}
+		info, err := api.Thumbnails.Stat(ctx, name)
+		if err != nil {
+			continue
+		}
+		if !dryRun {
+			if err := api.Thumbnails.Remove(ctx, name); err != nil {
+				continue
+			}
+			removed.Inc(1)
+			reclaimed.Inc(info.Size)
+		}
+		count++
+		size += info.Size
+	}
+	return count, size, nil
+}
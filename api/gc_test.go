@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"github.com/kxlt/imageresizer/collections"
+	"github.com/kxlt/imageresizer/config"
+	"github.com/kxlt/imageresizer/store"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCollectThumbnails(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestCollectThumbnails")
+	if err != nil {
+		t.Fatalf("Error creating temp dir")
+	}
+	defer os.RemoveAll(tmpdir)
+	api := &Api{
+		Originals:  store.NewTiered(store.NewFileStore(tmpdir + "/originals")),
+		Thumbnails: store.NewTiered(store.NewFileStore(tmpdir + "/thumbnails")),
+		Tiers:      collections.NewSyncStrSet(),
+	}
+	api.Originals.Put(ctx, "albums/2024/img.jpg", []byte("original"))
+	kept := []string{
+		"300x200/crop/smart/albums/2024/img.jpg",
+		"blurhash/albums/2024/img.jpg",
+		"upscale,x2/albums/2024/img.jpg",
+	}
+	orphans := []string{
+		"300x200/crop/smart/albums/gone.jpg",
+		"blurhash/x.jpg",
+		"lqip,blur=5/albums/2024/gone.jpg",
+	}
+	for _, name := range append(kept, orphans...) {
+		if err := api.Thumbnails.Put(ctx, name, []byte("thumbnail")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	n, _, err := api.collectThumbnails(ctx, false)
+	if err != nil || n != len(orphans) {
+		t.Errorf("collectThumbnails returned %d, %v, want %d", n, err, len(orphans))
+	}
+	for _, name := range kept {
+		if _, err := api.Thumbnails.Stat(ctx, name); err != nil {
+			t.Errorf("%s should be kept, got %v", name, err)
+		}
+	}
+	for _, name := range orphans {
+		if _, err := api.Thumbnails.Stat(ctx, name); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed, got %v", name, err)
+		}
+	}
+}
+
+func TestInitCacheLoader(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestInitCacheLoader")
+	if err != nil {
+		t.Fatalf("Error creating temp dir")
+	}
+	defer os.RemoveAll(tmpdir)
+	config.C.CacheLoaderFiles = 100
+	api := &Api{
+		Originals:  store.NewTiered(store.NewFileCache(tmpdir+"/originals", 1<<20, 1)),
+		Thumbnails: store.NewTiered(store.NewFileCache(tmpdir+"/thumbnails", 1<<20, 1)),
+		Tiers:      collections.NewSyncStrSet(),
+	}
+	for _, name := range []string{
+		"300x200/crop/smart/albums/2024/img.jpg",
+		"blurhash/albums/2024/img.jpg",
+	} {
+		if err := api.Thumbnails.Put(ctx, name, []byte("thumbnail")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	ready := make(chan bool, 1)
+	api.initCacheLoader(ready)
+	if !<-ready {
+		t.Fatalf("initCacheLoader failed")
+	}
+	var tiers []string
+	api.Tiers.Walk(func(tier string) {
+		tiers = append(tiers, tier)
+	})
+	sort.Strings(tiers)
+	if !reflect.DeepEqual(tiers, []string{"300x200/crop/smart", "blurhash"}) {
+		t.Errorf("Tiers = %v", tiers)
+	}
+}
+
+func TestSplitThumbnailKey(t *testing.T) {
+	tests := []struct {
+		key, tier, path string
+		ok              bool
+	}{
+		{"300x200/crop/smart/img.jpg", "300x200/crop/smart", "img.jpg", true},
+		{"x200/fit/0,format=webp/albums/2024/img.jpg", "x200/fit/0,format=webp", "albums/2024/img.jpg", true},
+		{"blurhash/albums/2024/img.jpg", "blurhash", "albums/2024/img.jpg", true},
+		{"blurhash/x.jpg", "blurhash", "x.jpg", true},
+		{"300x200/crop/smart", "", "", false},
+		{"blurhash", "", "", false},
+	}
+	for _, tt := range tests {
+		tier, path, ok := splitThumbnailKey(tt.key)
+		if tier != tt.tier || path != tt.path || ok != tt.ok {
+			t.Errorf("splitThumbnailKey(%q) = %q, %q, %v, want %q, %q, %v",
+				tt.key, tier, path, ok, tt.tier, tt.path, tt.ok)
+		}
+	}
+}
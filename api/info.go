@@ -0,0 +1,36 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/kxlt/imageresizer/imager"
+	"net/http"
+)
+
+// serveInfo responds with the format, dimensions, orientation, size and
+// some EXIF fields of an original, read from its header. Only the format
+// and size of videos are known.
+func (api *Api) serveInfo() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buf, err := api.derived(r.Context(), "info", mux.Vars(r)["path"], func(src []byte) ([]byte, error) {
+			format := imager.GetImageType(src)
+			fields := map[string]interface{}{
+				"format": format.String(),
+				"size":   len(src),
+			}
+			if _, ok := videoFormats[format]; !ok {
+				info, err := imager.Info(r.Context(), src)
+				if err != nil {
+					return nil, err
+				}
+				fields["width"] = info.Width
+				fields["height"] = info.Height
+				fields["orientation"] = info.Orientation
+				fields["pages"] = info.Pages
+				fields["exif"] = info.EXIF
+			}
+			return json.Marshal(fields)
+		})
+		respondWithDerived(w, buf, err)
+	}
+}
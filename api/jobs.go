@@ -0,0 +1,229 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/kxlt/imageresizer/config"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jobTTL is how long finished jobs are kept for their status to be read
+const jobTTL = time.Hour
+
+// errJobsFull is returned when jobs.queue jobs are queued or running
+// already
+var errJobsFull = errors.New("too many jobs")
+
+// jobKey is the key of the job in the context of the work it runs
+type jobKey struct{}
+
+// job statuses
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// job is an operation run in the background, followed by clients with
+// GET /api/jobs/{id}
+type job struct {
+	mu     sync.Mutex
+	ID     string `json:"id"`
+	Kind   string `json:"kind"`
+	Status string `json:"status"`
+	Total  int    `json:"total"`
+	Done   int    `json:"done"`
+	Failed int    `json:"failed"`
+	Error  string `json:"error,omitempty"`
+	// Result is the URL of the resource made by the job, if any
+	Result string `json:"result,omitempty"`
+	// Skipped lists what the job left out, e.g. the tiers a warm can't
+	// rebuild the requests of
+	Skipped  []string   `json:"skipped,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+func (j *job) setTotal(total int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Total = total
+}
+
+// progress counts an item of the job as done, or failed with err
+func (j *job) progress(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err != nil {
+		j.Failed++
+	} else {
+		j.Done++
+	}
+}
+
+// skip records items the job leaves out
+func (j *job) skip(items []string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Skipped = append(j.Skipped, items...)
+}
+
+func (j *job) MarshalJSON() ([]byte, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	// the alias has the fields without the methods
+	type snapshot job
+	return json.Marshal((*snapshot)(j))
+}
+
+// startJob runs fn in the background as a job of kind, once one of the
+// jobs.workers slots is free. With a key, e.g. the cache key of a
+// thumbnail, the job of the same key queued or running is returned rather
+// than starting another. Beyond jobs.queue jobs queued or running, new ones
+// are refused with errJobsFull. Jobs are kept in api.Jobs until jobTTL
+// after they finished.
+func (api *Api) startJob(kind string, key string, fn func(ctx context.Context, j *job) error) (*job, error) {
+	api.jobsMu.Lock()
+	if j, ok := api.activeJobs[key]; ok && key != "" {
+		api.jobsMu.Unlock()
+		return j, nil
+	}
+	if api.pendingJobs >= config.C.JobsQueue {
+		api.jobsMu.Unlock()
+		return nil, errJobsFull
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	j := &job{
+		ID:      hex.EncodeToString(id),
+		Kind:    kind,
+		Status:  jobQueued,
+		Started: time.Now(),
+	}
+	api.pendingJobs++
+	if key != "" {
+		api.activeJobs[key] = j
+	}
+	api.jobsMu.Unlock()
+	api.Jobs.Put(j.ID, j)
+	go func() {
+		api.jobSlots <- struct{}{}
+		defer func() { <-api.jobSlots }()
+		j.mu.Lock()
+		j.Status = jobRunning
+		j.mu.Unlock()
+		err := fn(context.WithValue(context.Background(), jobKey{}, j), j)
+		j.mu.Lock()
+		j.Status = jobDone
+		if err != nil {
+			j.Status = jobFailed
+			j.Error = err.Error()
+		}
+		finished := time.Now()
+		j.Finished = &finished
+		j.mu.Unlock()
+		api.jobsMu.Lock()
+		api.pendingJobs--
+		if key != "" {
+			delete(api.activeJobs, key)
+		}
+		api.jobsMu.Unlock()
+		time.AfterFunc(jobTTL, func() {
+			api.Jobs.Remove(j.ID)
+		})
+	}()
+	return j, nil
+}
+
+// preferAsync tells whether the client would rather have a job started
+// than wait for the response, with a Prefer: respond-async header
+func preferAsync(r *http.Request) bool {
+	for _, prefer := range r.Header["Prefer"] {
+		for _, pref := range strings.Split(prefer, ",") {
+			if strings.EqualFold(strings.TrimSpace(pref), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// respondWithJob answers 202 with the id of a started job, or 503 when it
+// could not be started
+func respondWithJob(w http.ResponseWriter, j *job, err error) {
+	if err != nil {
+		w.Header().Set("Retry-After", "60")
+		respondWithErr(w, http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/jobs/"+j.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"job": j.ID})
+}
+
+// serveJobs responds with the status of a job
+func (api *Api) serveJobs() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		j, ok := api.Jobs.Get(mux.Vars(r)["id"]).(*job)
+		if !ok {
+			respondWithErr(w, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(j)
+	}
+}
+
+// async tells whether the thumbnail of the original at path is made in a
+// job, when the client prefers it or the original is over jobs.asyncsize,
+// e.g. videos and large PDFs, unless r is made by a job already
+func (api *Api) async(r *http.Request, path string) bool {
+	if r.Context().Value(jobKey{}) != nil {
+		return false
+	}
+	if preferAsync(r) {
+		return true
+	}
+	if config.C.JobsAsyncSize <= 0 {
+		return false
+	}
+	info, err := api.Originals.Stat(r.Context(), path)
+	return err == nil && info.Size > config.C.JobsAsyncSize
+}
+
+// startThumbJob makes the thumbnail of r, cached under thumbPath, in a
+// job whose result is the URL of r once the thumbnail is cached. Requests
+// of a thumbnail being made already follow the same job.
+func (api *Api) startThumbJob(
+	w http.ResponseWriter,
+	r *http.Request,
+	vars map[string]string,
+	query url.Values,
+	thumbPath string) {
+
+	uri := r.URL.RequestURI()
+	j, err := api.startJob("thumbnail", thumbPath, func(ctx context.Context, j *job) error {
+		j.setTotal(1)
+		// r was checked before starting the job
+		err := api.warm(ctx, vars, query, vars["path"], true)
+		j.progress(err)
+		if err != nil {
+			return err
+		}
+		j.mu.Lock()
+		j.Result = uri
+		j.mu.Unlock()
+		return nil
+	})
+	respondWithJob(w, j, err)
+}
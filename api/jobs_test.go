@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"github.com/kxlt/imageresizer/collections"
+	"github.com/kxlt/imageresizer/config"
+	"github.com/kxlt/imageresizer/store"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStartJob(t *testing.T) {
+	defer func(queue int) { config.C.JobsQueue = queue }(config.C.JobsQueue)
+	config.C.JobsQueue = 2
+	api := &Api{
+		Jobs:       collections.NewSyncMap(),
+		jobSlots:   make(chan struct{}, 1),
+		activeJobs: map[string]*job{},
+	}
+	release := make(chan struct{})
+	done := make(chan struct{}, 2)
+	run := func(ctx context.Context, j *job) error {
+		<-release
+		done <- struct{}{}
+		return nil
+	}
+
+	first, err := api.startJob("thumbnail", "300x200/crop/smart/a.jpg", run)
+	if err != nil {
+		t.Fatalf("startJob failed: %v", err)
+	}
+	same, err := api.startJob("thumbnail", "300x200/crop/smart/a.jpg", run)
+	if err != nil || same != first {
+		t.Errorf("startJob of the same key started another job: %v", err)
+	}
+	if _, err := api.startJob("delete", "", run); err != nil {
+		t.Errorf("startJob failed: %v", err)
+	}
+	if _, err := api.startJob("thumbnail", "300x200/crop/smart/b.jpg", run); err != errJobsFull {
+		t.Errorf("startJob past jobs.queue returned %v, want %v", err, errJobsFull)
+	}
+
+	close(release)
+	<-done
+	<-done
+	// the jobs finish right after running
+	for {
+		api.jobsMu.Lock()
+		pending := api.pendingJobs
+		api.jobsMu.Unlock()
+		if pending == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	again, err := api.startJob("thumbnail", "300x200/crop/smart/a.jpg", func(ctx context.Context, j *job) error {
+		return nil
+	})
+	if err != nil || again == first {
+		t.Errorf("startJob of a finished key returned %v, %v", again, err)
+	}
+}
+
+func TestAsyncInJob(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("../testdata", "TestAsyncInJob")
+	if err != nil {
+		t.Fatalf("Error creating temp dir")
+	}
+	defer os.RemoveAll(tmpdir)
+	defer func(size int64, queue int) {
+		config.C.JobsAsyncSize, config.C.JobsQueue = size, queue
+	}(config.C.JobsAsyncSize, config.C.JobsQueue)
+	config.C.JobsAsyncSize, config.C.JobsQueue = 4, 1
+	api := &Api{
+		Originals:  store.NewTiered(store.NewFileStore(tmpdir)),
+		Jobs:       collections.NewSyncMap(),
+		jobSlots:   make(chan struct{}, 1),
+		activeJobs: map[string]*job{},
+	}
+	api.Originals.Put(context.Background(), "large.mp4", []byte("over the async size"))
+
+	r := httptest.NewRequest("GET", "/300x200/fit/0/large.mp4", nil)
+	if !api.async(r, "large.mp4") {
+		t.Errorf("Originals over jobs.asyncsize should be made in jobs")
+	}
+	inJob := make(chan bool, 1)
+	_, err = api.startJob("thumbnail", "300x200/fit/0/large.mp4", func(ctx context.Context, j *job) error {
+		inJob <- api.async(r.WithContext(ctx), "large.mp4")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("startJob failed: %v", err)
+	}
+	if <-inJob {
+		t.Errorf("Thumbnails made by jobs should not start other jobs")
+	}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"github.com/kxlt/imageresizer/config"
+	"github.com/kxlt/imageresizer/imager"
+	"strconv"
+	"strings"
+)
+
+// allowedSize tells whether the thumbnail of the resize tier in vars and
+// options, once the query parameters are applied, is within resize.allowed
+// and resize.maxmegapixels
+func allowedSize(vars map[string]string, options imager.Options) bool {
+	if max := config.C.ResizeMaxMegapixels; max > 0 &&
+		float64(options.Width)*float64(options.Height) > max*1e6 {
+		return false
+	}
+	if len(config.C.ResizeAllowed) == 0 {
+		return true
+	}
+	tier := []string{
+		dimension(options.Width) + "x" + dimension(options.Height),
+		vars["resizeOp"],
+		vars["options"],
+	}
+	for _, allowed := range config.C.ResizeAllowed {
+		// dimensions, optionally followed by the resize op and its options
+		parts := strings.Split(allowed, "/")
+		if len(parts) > len(tier) {
+			continue
+		}
+		match := true
+		for i, part := range parts {
+			if part != tier[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// dimension formats a dimension of thumbnails, empty when left to the
+// aspect ratio of the original
+func dimension(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/kxlt/imageresizer/imager"
+	"net/http"
+)
+
+const (
+	// lqipSize bounds the dimensions of low quality image placeholders
+	lqipSize = 24
+	// lqipQuality is the JPEG quality of placeholders, which are blown up
+	// and usually blurred by clients
+	lqipQuality = 20
+)
+
+// serveLQIP responds with a tiny JPEG of an original as a data URI, to be
+// inlined in pages as a placeholder. It is blurred with the blur parameter.
+func (api *Api) serveLQIP() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		options := imager.Options{
+			Width:    lqipSize,
+			Height:   lqipSize,
+			ResizeOp: imager.INSIDE,
+			Format:   imager.JPEG,
+			Quality:  lqipQuality,
+		}
+		tier := "lqip"
+		if blur := r.URL.Query().Get("blur"); blur != "" {
+			value, err := parseBlur(blur, &options)
+			if err != nil {
+				respondWithErr(w, http.StatusBadRequest)
+				return
+			}
+			tier += ",blur=" + value
+		}
+		buf, err := api.derived(r.Context(), tier, mux.Vars(r)["path"], func(src []byte) ([]byte, error) {
+			src, err := api.still(r.Context(), src)
+			if err != nil {
+				return nil, err
+			}
+			thumbBuf, err := imager.Resize(r.Context(), src, options)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(map[string]string{
+				"lqip": "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(thumbBuf),
+			})
+		})
+		respondWithDerived(w, buf, err)
+	}
+}
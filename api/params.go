@@ -0,0 +1,853 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/kxlt/imageresizer/config"
+	"github.com/kxlt/imageresizer/imager"
+	"math"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// thumbParam applies a query parameter of thumbnail requests to options and
+// returns its normalized value, used in cache keys
+type thumbParam func(value string, options *imager.Options) (string, error)
+
+// thumbParams lists the query parameters accepted by thumbnail requests
+var thumbParams = map[string]thumbParam{
+	"format":         parseFormat,
+	"quality":        parseQuality,
+	"progressive":    parseProgressive,
+	"fp":             parseFocalPoint,
+	"rotate":         parseRotate,
+	"blur":           parseBlur,
+	"sharpen":        parseSharpen,
+	"grayscale":      parseGrayscale,
+	"mask":           parseMask,
+	"redact":         parseRedact,
+	"redactmode":     parseRedactMode,
+	"t":              parseTime,
+	"page":           parsePage,
+	"overlay":        parseOverlay,
+	"overlaygravity": parseOverlayGravity,
+	"overlayblend":   parseOverlayBlend,
+	"overlayscale":   parseOverlayScale,
+	"text":           parseText,
+	"font":           parseFont,
+	"textsize":       parseTextSize,
+	"textcolor":      parseTextColor,
+	"textgravity":    parseTextGravity,
+	"brightness":     parseBrightness,
+	"contrast":       parseContrast,
+	"gamma":          parseGamma,
+	"saturation":     parseSaturation,
+	"flip":           parseFlip,
+	"flop":           parseFlop,
+	"enlarge":        parseEnlarge,
+	"dpr":            parseDPR,
+	"trim":           parseTrim,
+	"scale":          parseScale,
+	"crop":           parseCrop,
+	"zoom":           parseZoom,
+	"filter":         parseFilter,
+	"maxbytes":       parseMaxBytes,
+	"lossless":       parseLossless,
+	"removebg":       parseRemoveBackground,
+	"denoise":        parseDenoise,
+	"frame":          parseFrame,
+	"duotone":        parseDuotone,
+	"tint":           parseTint,
+	"canvas":         parseCanvas,
+	"canvasbg":       parseCanvasBackground,
+	"kernel":         parseKernel,
+}
+
+// videoFormats are transcoded from GIFs by ffmpeg
+var videoFormats = map[imager.ImageType]string{
+	imager.MP4:  "mp4",
+	imager.WEBM: "webm",
+}
+
+func parseFormat(value string, options *imager.Options) (string, error) {
+	value = strings.ToLower(value)
+	format, ok := imager.Formats[value]
+	if _, video := videoFormats[format]; !ok || video && config.C.VideoFFmpeg == "" {
+		return "", errors.New("invalid format")
+	}
+	options.Format = format
+	if value == "jpeg" {
+		value = "jpg"
+	}
+	return value, nil
+}
+
+func parseQuality(value string, options *imager.Options) (string, error) {
+	quality, err := strconv.Atoi(value)
+	if err != nil || quality < config.C.QualityMin || quality > config.C.QualityMax {
+		return "", errors.New("invalid quality")
+	}
+	options.Quality = quality
+	return strconv.Itoa(quality), nil
+}
+
+// parseMaxBytes parses the size in bytes thumbnails are lowered in quality
+// to fit in
+func parseMaxBytes(value string, options *imager.Options) (string, error) {
+	maxBytes, err := strconv.Atoi(value)
+	if err != nil || maxBytes < 1 {
+		return "", errors.New("invalid maxbytes")
+	}
+	options.MaxBytes = maxBytes
+	return strconv.Itoa(maxBytes), nil
+}
+
+// parseLossless parses a bool, or near for near-lossless WebPs
+func parseLossless(value string, options *imager.Options) (string, error) {
+	if value == "near" {
+		options.Lossless, options.NearLossless = false, true
+		return value, nil
+	}
+	lossless, err := strconv.ParseBool(value)
+	if err != nil {
+		return "", errors.New("invalid lossless")
+	}
+	options.Lossless, options.NearLossless = lossless, false
+	return strconv.FormatBool(lossless), nil
+}
+
+func parseProgressive(value string, options *imager.Options) (string, error) {
+	progressive, err := strconv.ParseBool(value)
+	if err != nil {
+		return "", errors.New("invalid progressive")
+	}
+	options.Progressive = progressive
+	return strconv.FormatBool(progressive), nil
+}
+
+// parseFocalPoint parses normalized coordinates such as 0.3,0.7. The comma
+// is reserved in cache keys, so the normalized value is 0.3x0.7.
+func parseFocalPoint(value string, options *imager.Options) (string, error) {
+	coords := strings.Split(value, ",")
+	if len(coords) != 2 {
+		return "", errors.New("invalid focal point")
+	}
+	var point [2]float64
+	for i, c := range coords {
+		v, err := strconv.ParseFloat(strings.TrimSpace(c), 64)
+		if err != nil || v < 0 || v > 1 {
+			return "", errors.New("invalid focal point")
+		}
+		point[i] = v
+	}
+	options.Focal = &imager.Point{X: point[0], Y: point[1]}
+	return strconv.FormatFloat(point[0], 'f', -1, 64) + "x" + strconv.FormatFloat(point[1], 'f', -1, 64), nil
+}
+
+// maxBlur bounds the sigma of blurs, whose cost grows with it
+const maxBlur = 100
+
+func parseBlur(value string, options *imager.Options) (string, error) {
+	sigma, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(sigma >= 0 && sigma <= maxBlur) {
+		return "", errors.New("invalid blur")
+	}
+	options.Blur = sigma
+	return strconv.FormatFloat(sigma, 'f', -1, 64), nil
+}
+
+// defaultTrimThreshold tolerates the noise of JPEG backgrounds
+const defaultTrimThreshold = 10
+
+// parseTrim parses the threshold of trims, from 0 to 255, or a boolean for
+// the default one
+func parseTrim(value string, options *imager.Options) (string, error) {
+	threshold, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		trim, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", errors.New("invalid trim")
+		}
+		if !trim {
+			return "false", nil
+		}
+		threshold = defaultTrimThreshold
+	}
+	if !(threshold >= 0 && threshold <= 255) {
+		return "", errors.New("invalid trim")
+	}
+	options.Trim = true
+	options.TrimThreshold = threshold
+	return strconv.FormatFloat(threshold, 'f', -1, 64), nil
+}
+
+// defaultDenoise is the size of the median filter of denoise=true
+const defaultDenoise = 3
+
+// parseDenoise parses the size of the median filter, 3, 5 or 7, or a
+// boolean for the default one
+func parseDenoise(value string, options *imager.Options) (string, error) {
+	size, err := strconv.Atoi(value)
+	if err != nil {
+		denoise, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", errors.New("invalid denoise")
+		}
+		if !denoise {
+			return "false", nil
+		}
+		size = defaultDenoise
+	}
+	if size != 3 && size != 5 && size != 7 {
+		return "", errors.New("invalid denoise")
+	}
+	options.Denoise = size
+	return strconv.Itoa(size), nil
+}
+
+// maxSharpen bounds the sigma of unsharp masks
+const maxSharpen = 10
+
+// sharpenSigma parses a preset of imager.SharpenPresets or a sigma
+func sharpenSigma(value string) (float64, error) {
+	if sigma, ok := imager.SharpenPresets[strings.ToLower(value)]; ok {
+		return sigma, nil
+	}
+	sigma, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(sigma >= 0 && sigma <= maxSharpen) {
+		return 0, errors.New("invalid sharpen")
+	}
+	return sigma, nil
+}
+
+func parseSharpen(value string, options *imager.Options) (string, error) {
+	sigma, err := sharpenSigma(value)
+	if err != nil {
+		return "", err
+	}
+	options.Sharpen = sigma
+	return strconv.FormatFloat(sigma, 'f', -1, 64), nil
+}
+
+func parseBrightness(value string, options *imager.Options) (string, error) {
+	brightness, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(brightness >= -255 && brightness <= 255) {
+		return "", errors.New("invalid brightness")
+	}
+	options.Brightness = brightness
+	return strconv.FormatFloat(brightness, 'f', -1, 64), nil
+}
+
+func parseContrast(value string, options *imager.Options) (string, error) {
+	contrast, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(contrast > 0 && contrast <= 5) {
+		return "", errors.New("invalid contrast")
+	}
+	options.Contrast = contrast
+	return strconv.FormatFloat(contrast, 'f', -1, 64), nil
+}
+
+func parseGamma(value string, options *imager.Options) (string, error) {
+	gamma, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(gamma >= 0.1 && gamma <= 10) {
+		return "", errors.New("invalid gamma")
+	}
+	options.Gamma = gamma
+	return strconv.FormatFloat(gamma, 'f', -1, 64), nil
+}
+
+// overlayOf returns the overlay of options, set up with the defaults
+func overlayOf(options *imager.Options) *imager.Overlay {
+	if options.Overlay == nil {
+		options.Overlay = &imager.Overlay{Position: imager.MIDDLE}
+	}
+	return options.Overlay
+}
+
+// parseOverlay sets the path of the original composited onto the
+// thumbnail, loaded by serveThumbs. Its normalized value is a hash as paths
+// contain slashes.
+func parseOverlay(value string, options *imager.Options) (string, error) {
+	value = strings.TrimPrefix(value, "/")
+	if value == "" {
+		return "", errors.New("invalid overlay")
+	}
+	overlayOf(options).Path = value
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+func parseOverlayGravity(value string, options *imager.Options) (string, error) {
+	position, ok := imager.Positions[value]
+	if !ok {
+		return "", errors.New("invalid overlaygravity")
+	}
+	overlayOf(options).Position = position
+	return value, nil
+}
+
+func parseOverlayBlend(value string, options *imager.Options) (string, error) {
+	blend, ok := imager.BlendModes[value]
+	if !ok {
+		return "", errors.New("invalid overlayblend")
+	}
+	overlayOf(options).Blend = blend
+	return value, nil
+}
+
+func parseOverlayScale(value string, options *imager.Options) (string, error) {
+	scale, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(scale > 0 && scale <= 1) {
+		return "", errors.New("invalid overlayscale")
+	}
+	overlayOf(options).Scale = scale
+	return strconv.FormatFloat(scale, 'f', -1, 64), nil
+}
+
+// maxTextLength bounds the length of text captions, in characters
+const maxTextLength = 256
+
+// textOf returns the text caption of options, set up with the defaults
+func textOf(options *imager.Options) *imager.Text {
+	if options.Text == nil {
+		options.Text = &imager.Text{
+			Font:     config.C.TextFont,
+			Size:     24,
+			Color:    []float64{255, 255, 255},
+			Position: imager.SOUTH,
+		}
+	}
+	return options.Text
+}
+
+// parseText sets the caption, its normalized value is a hash as it may be
+// long and contain slashes
+func parseText(value string, options *imager.Options) (string, error) {
+	if utf8.RuneCountInString(value) > maxTextLength || !utf8.ValidString(value) {
+		return "", errors.New("invalid text")
+	}
+	textOf(options).Text = value
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+func parseFont(value string, options *imager.Options) (string, error) {
+	for _, r := range value {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != ' ' && r != '-' {
+			return "", errors.New("invalid font")
+		}
+	}
+	textOf(options).Font = value
+	return url.QueryEscape(value), nil
+}
+
+func parseTextSize(value string, options *imager.Options) (string, error) {
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 1 || size > 500 {
+		return "", errors.New("invalid textsize")
+	}
+	textOf(options).Size = size
+	return strconv.Itoa(size), nil
+}
+
+func parseTextColor(value string, options *imager.Options) (string, error) {
+	if n := utf8.RuneCountInString(value); n != 6 && n != 8 {
+		return "", errors.New("invalid textcolor")
+	}
+	color, err := decodeHexRGB(value)
+	if err != nil {
+		return "", err
+	}
+	textOf(options).Color = color
+	return strings.ToLower(value), nil
+}
+
+func parseTextGravity(value string, options *imager.Options) (string, error) {
+	position, ok := imager.Positions[value]
+	if !ok {
+		return "", errors.New("invalid textgravity")
+	}
+	textOf(options).Position = position
+	return value, nil
+}
+
+// parsePage parses the page of PDF originals, starting from 1
+func parsePage(value string, options *imager.Options) (string, error) {
+	page, err := strconv.Atoi(value)
+	if err != nil || page < 1 || page > 100000 {
+		return "", errors.New("invalid page")
+	}
+	options.Page = page - 1
+	return strconv.Itoa(page), nil
+}
+
+func parseKernel(value string, options *imager.Options) (string, error) {
+	kernel, ok := imager.Kernels[value]
+	if !ok {
+		return "", errors.New("invalid kernel")
+	}
+	options.Kernel = kernel
+	return value, nil
+}
+
+// parseFrame parses the frame of animated originals, starting from 1
+func parseFrame(value string, options *imager.Options) (string, error) {
+	frame, err := strconv.Atoi(value)
+	if err != nil || frame < 1 || frame > 100000 {
+		return "", errors.New("invalid frame")
+	}
+	options.Frame = frame
+	return strconv.Itoa(frame), nil
+}
+
+// parseTime parses the timestamp in seconds of the frame of videos
+func parseTime(value string, options *imager.Options) (string, error) {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(seconds >= 0 && seconds <= 24*60*60) {
+		return "", errors.New("invalid t")
+	}
+	options.Time = time.Duration(seconds * float64(time.Second))
+	return strconv.FormatFloat(seconds, 'f', -1, 64), nil
+}
+
+// parseRemoveBackground parses a bool. Since cutouts are transparent, JPEG
+// output is refused.
+func parseRemoveBackground(value string, options *imager.Options) (string, error) {
+	remove, err := strconv.ParseBool(value)
+	if err != nil {
+		return "", errors.New("invalid removebg")
+	}
+	if remove && config.C.CutoutURL == "" {
+		return "", errors.New("background removal disabled")
+	}
+	if remove && options.Format == imager.JPEG {
+		return "", errors.New("removebg needs transparency")
+	}
+	options.RemoveBackground = remove
+	return strconv.FormatBool(remove), nil
+}
+
+// maxRedact bounds the number of areas redacted at once
+const maxRedact = 10
+
+// parseRedact parses x,y,w,h areas separated by semicolons, in fractions of
+// the width and height of the original. Its normalized value is a hash as
+// it is long.
+func parseRedact(value string, options *imager.Options) (string, error) {
+	areas := strings.Split(value, ";")
+	if len(areas) > maxRedact {
+		return "", errors.New("invalid redact")
+	}
+	var canonical []string
+	for _, area := range areas {
+		coords := strings.Split(area, ",")
+		if len(coords) != 4 {
+			return "", errors.New("invalid redact")
+		}
+		var v [4]float64
+		for i, c := range coords {
+			f, err := strconv.ParseFloat(strings.TrimSpace(c), 64)
+			if err != nil || f < 0 || f > 1 {
+				return "", errors.New("invalid redact")
+			}
+			v[i] = f
+			canonical = append(canonical, strconv.FormatFloat(f, 'f', -1, 64))
+		}
+		if v[2] == 0 || v[3] == 0 {
+			return "", errors.New("invalid redact")
+		}
+		options.Redact = append(options.Redact, imager.Rect{X: v[0], Y: v[1], W: v[2], H: v[3]})
+	}
+	sum := sha256.Sum256([]byte(strings.Join(canonical, ",")))
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+func parseRedactMode(value string, options *imager.Options) (string, error) {
+	mode, ok := imager.RedactModes[value]
+	if !ok {
+		return "", errors.New("invalid redactmode")
+	}
+	options.RedactMode = mode
+	return value, nil
+}
+
+// parseMask parses circle or a corner radius in pixels. Since masks need
+// transparency, JPEG output is refused.
+func parseMask(value string, options *imager.Options) (string, error) {
+	if options.Format == imager.JPEG {
+		return "", errors.New("mask needs transparency")
+	}
+	if value == "circle" {
+		options.Mask = imager.CIRCLE
+		return value, nil
+	}
+	radius, err := strconv.Atoi(value)
+	if err != nil || radius < 1 || radius > 10000 {
+		return "", errors.New("invalid mask")
+	}
+	options.Mask = imager.ROUNDED
+	options.MaskRadius = radius
+	return strconv.Itoa(radius), nil
+}
+
+func parseGrayscale(value string, options *imager.Options) (string, error) {
+	grayscale, err := strconv.ParseBool(value)
+	if err != nil {
+		return "", errors.New("invalid grayscale")
+	}
+	options.Grayscale = grayscale
+	return strconv.FormatBool(grayscale), nil
+}
+
+func parseFilter(value string, options *imager.Options) (string, error) {
+	filter, ok := imager.Filters[value]
+	if !ok {
+		return "", errors.New("invalid filter")
+	}
+	if options.Filter != imager.NOFILTER {
+		return "", errors.New("filters can't be combined")
+	}
+	options.Filter = filter
+	return value, nil
+}
+
+// parseDuotone parses the rrggbb colors of the shadows and highlights of
+// duotones, separated by a comma
+func parseDuotone(value string, options *imager.Options) (string, error) {
+	hexColors := strings.Split(value, ",")
+	if len(hexColors) != 2 {
+		return "", errors.New("invalid duotone")
+	}
+	if options.Filter != imager.NOFILTER {
+		return "", errors.New("filters can't be combined")
+	}
+	var colors [][]float64
+	for i, hexColor := range hexColors {
+		if utf8.RuneCountInString(hexColor) != 6 {
+			return "", errors.New("invalid duotone")
+		}
+		color, err := decodeHexRGB(hexColor)
+		if err != nil {
+			return "", err
+		}
+		colors = append(colors, color)
+		hexColors[i] = strings.ToLower(hexColor)
+	}
+	options.Filter = imager.DUOTONE
+	options.FilterColors = colors
+	return strings.Join(hexColors, ","), nil
+}
+
+// parseTint parses the rrggbb color the thumbnail is multiplied by
+func parseTint(value string, options *imager.Options) (string, error) {
+	if utf8.RuneCountInString(value) != 6 {
+		return "", errors.New("invalid tint")
+	}
+	if options.Filter != imager.NOFILTER {
+		return "", errors.New("filters can't be combined")
+	}
+	color, err := decodeHexRGB(value)
+	if err != nil {
+		return "", err
+	}
+	options.Filter = imager.TINT
+	options.FilterColors = [][]float64{color}
+	return strings.ToLower(value), nil
+}
+
+// maxSaturation bounds the saturation multiplier
+const maxSaturation = 5
+
+// parseSaturation parses a multiplier of the saturation, 0 being grayscale
+func parseSaturation(value string, options *imager.Options) (string, error) {
+	saturation, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(saturation >= 0 && saturation <= maxSaturation) {
+		return "", errors.New("invalid saturation")
+	}
+	if saturation == 0 {
+		options.Grayscale = true
+	}
+	options.Saturation = saturation
+	return strconv.FormatFloat(saturation, 'f', -1, 64), nil
+}
+
+func parseFlip(value string, options *imager.Options) (string, error) {
+	flip, err := strconv.ParseBool(value)
+	if err != nil {
+		return "", errors.New("invalid flip")
+	}
+	options.Flip = flip
+	return strconv.FormatBool(flip), nil
+}
+
+func parseFlop(value string, options *imager.Options) (string, error) {
+	flop, err := strconv.ParseBool(value)
+	if err != nil {
+		return "", errors.New("invalid flop")
+	}
+	options.Flop = flop
+	return strconv.FormatBool(flop), nil
+}
+
+func parseEnlarge(value string, options *imager.Options) (string, error) {
+	enlarge, err := strconv.ParseBool(value)
+	if err != nil {
+		return "", errors.New("invalid enlarge")
+	}
+	options.NoEnlarge = !enlarge
+	return strconv.FormatBool(enlarge), nil
+}
+
+// parseCrop parses an area of the original in pixels, as x,y,w,h. It is
+// normalized as wxh+x+y, commas being reserved.
+func parseCrop(value string, options *imager.Options) (string, error) {
+	coords := strings.Split(value, ",")
+	if len(coords) != 4 {
+		return "", errors.New("invalid crop")
+	}
+	var v [4]int
+	for i, c := range coords {
+		n, err := strconv.Atoi(strings.TrimSpace(c))
+		if err != nil || n < 0 || n > 100000 {
+			return "", errors.New("invalid crop")
+		}
+		v[i] = n
+	}
+	if v[2] == 0 || v[3] == 0 {
+		return "", errors.New("invalid crop")
+	}
+	options.Crop = &imager.Area{Left: v[0], Top: v[1], Width: v[2], Height: v[3]}
+	return strconv.Itoa(v[2]) + "x" + strconv.Itoa(v[3]) + "+" + strconv.Itoa(v[0]) + "+" + strconv.Itoa(v[1]), nil
+}
+
+// maxCanvas bounds the dimensions of canvases
+const maxCanvas = 10000
+
+// canvasOf returns the canvas of options, set up with the defaults
+func canvasOf(options *imager.Options) (*imager.Canvas, error) {
+	if options.Canvas == nil {
+		bg, err := parseBackground("0")
+		if err != nil {
+			return nil, err
+		}
+		options.Canvas = &imager.Canvas{Centered: true, Background: bg}
+	}
+	return options.Canvas, nil
+}
+
+// parseCanvas parses the dimensions of the canvas as w,h, optionally
+// followed by the position of the thumbnail, x,y, which is centered
+// otherwise. It is normalized as wxh or wxh+x+y, commas being reserved.
+func parseCanvas(value string, options *imager.Options) (string, error) {
+	coords := strings.Split(value, ",")
+	if len(coords) != 2 && len(coords) != 4 {
+		return "", errors.New("invalid canvas")
+	}
+	var v [4]int
+	for i, c := range coords {
+		n, err := strconv.Atoi(strings.TrimSpace(c))
+		if err != nil || n < 0 || n > maxCanvas {
+			return "", errors.New("invalid canvas")
+		}
+		v[i] = n
+	}
+	if v[0] == 0 || v[1] == 0 {
+		return "", errors.New("invalid canvas")
+	}
+	canvas, err := canvasOf(options)
+	if err != nil {
+		return "", err
+	}
+	canvas.Width, canvas.Height = v[0], v[1]
+	normalized := strconv.Itoa(v[0]) + "x" + strconv.Itoa(v[1])
+	if len(coords) == 4 {
+		canvas.Left, canvas.Top, canvas.Centered = v[2], v[3], false
+		normalized += "+" + strconv.Itoa(v[2]) + "+" + strconv.Itoa(v[3])
+	}
+	return normalized, nil
+}
+
+// parseCanvasBackground parses the rrggbb or rrggbbaa color of canvases,
+// transparent, or 0 for pad.background
+func parseCanvasBackground(value string, options *imager.Options) (string, error) {
+	bg, err := parseBackground(value)
+	if err != nil {
+		return "", err
+	}
+	canvas, err := canvasOf(options)
+	if err != nil {
+		return "", err
+	}
+	canvas.Background = bg
+	return strings.ToLower(value), nil
+}
+
+// parseScale parses a percentage of the dimensions of the original, up to
+// 100, the % sign being optional
+func parseScale(value string, options *imager.Options) (string, error) {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || !(percent > 0 && percent <= 100) {
+		return "", errors.New("invalid scale")
+	}
+	options.Scale = percent / 100
+	return strconv.FormatFloat(percent, 'f', -1, 64), nil
+}
+
+// maxZoom bounds the zoom of crops
+const maxZoom = 10
+
+// parseZoom parses the factor crops are narrowed by around the focal point
+// or gravity
+func parseZoom(value string, options *imager.Options) (string, error) {
+	zoom, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(zoom >= 1 && zoom <= maxZoom) {
+		return "", errors.New("invalid zoom")
+	}
+	options.Zoom = zoom
+	return strconv.FormatFloat(zoom, 'f', -1, 64), nil
+}
+
+// maxDPR bounds the device pixel ratio
+const maxDPR = 3
+
+// parseDPR parses the device pixel ratio the requested dimensions, in CSS
+// pixels, are multiplied by
+func parseDPR(value string, options *imager.Options) (string, error) {
+	dpr, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(dpr >= 1 && dpr <= maxDPR) {
+		return "", errors.New("invalid dpr")
+	}
+	options.Width = int(math.Round(float64(options.Width) * dpr))
+	options.Height = int(math.Round(float64(options.Height) * dpr))
+	return strconv.FormatFloat(dpr, 'f', -1, 64), nil
+}
+
+// parseRotate parses a clockwise angle in degrees, normalized to [0, 360).
+// Arbitrary angles are filled with the pad color, or pad.background.
+func parseRotate(value string, options *imager.Options) (string, error) {
+	angle, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(angle) || math.IsInf(angle, 0) {
+		return "", errors.New("invalid rotate")
+	}
+	if angle = math.Mod(angle, 360); angle < 0 {
+		angle += 360
+	}
+	options.Rotate = angle
+	options.Background = options.ExtendBackground
+	if len(options.Background) == 0 {
+		if options.Background, err = parseBackground("0"); err != nil {
+			return "", err
+		}
+	}
+	return strconv.FormatFloat(angle, 'f', -1, 64), nil
+}
+
+// applyDefaults fills the options left unset by the request from the
+// configuration
+func applyDefaults(options *imager.Options) {
+	switch options.Format {
+	case imager.AVIF:
+		if options.Quality == 0 {
+			options.Quality = config.C.AVIFQuality
+		}
+		options.Effort = config.C.AVIFEffort
+	case imager.WEBP:
+		options.Effort = config.C.WebPEffort
+	case imager.PNG:
+		options.Effort = config.C.PNGEffort
+	}
+	if options.Quality == 0 {
+		options.Quality = config.C.QualityDefault
+	}
+}
+
+// parseQuery applies the known parameters of query to options. It returns
+// their canonical form, sorted "key=value" pairs separated by commas, so
+// equivalent requests share a cache key. Unknown parameters are ignored.
+func parseQuery(query url.Values, options *imager.Options) (string, error) {
+	var keys []string
+	for key := range thumbParams {
+		if query.Get(key) != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, key := range keys {
+		value, err := thumbParams[key](query.Get(key), options)
+		if err != nil {
+			return "", err
+		}
+		params = append(params, key+"="+value)
+	}
+	return strings.Join(params, ","), nil
+}
+
+// negotiateFormat returns the first of the configured formats accepted by
+// the client, or "" if none
+func negotiateFormat(accept string) string {
+	for _, format := range config.C.FormatNegotiate {
+		if accepts(accept, "image/"+format) {
+			return format
+		}
+	}
+	return ""
+}
+
+// accepts tells whether the Accept header lists mimeType explicitly, with
+// a non zero quality. Wildcards are ignored since browsers send */* for
+// images without supporting every format.
+func accepts(accept string, mimeType string) bool {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), mimeType) {
+			continue
+		}
+		for _, p := range params[1:] {
+			if q := strings.TrimSpace(p); strings.HasPrefix(q, "q=") {
+				if v, err := strconv.ParseFloat(q[2:], 64); err == nil && v == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// sourceExts are the extensions of originals which aren't output formats
+var sourceExts = map[string]bool{
+	"pdf":  true,
+	"svg":  true,
+	"heic": true,
+	"heif": true,
+	"tif":  true,
+	"tiff": true,
+	"mov":  true,
+}
+
+// isImageExt tells whether ext, e.g. ".jpg", is the extension of an original
+func isImageExt(ext string) bool {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	_, ok := imager.Formats[ext]
+	return ok || sourceExts[ext]
+}
+
+// splitFormatSuffix splits an output format extension appended to the path
+// of the original, e.g. photo.jpg.webp. The rest must end with the extension
+// of an original, so that dotted names such as my.photo.png are kept whole.
+func splitFormatSuffix(filename string) (string, string) {
+	ext := path.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	if _, ok := imager.Formats[strings.ToLower(strings.TrimPrefix(ext, "."))]; !ok || !isImageExt(path.Ext(base)) {
+		return filename, ""
+	}
+	return base, ext[1:]
+}
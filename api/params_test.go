@@ -0,0 +1,29 @@
+package api
+
+import "testing"
+
+func TestSplitFormatSuffix(t *testing.T) {
+	tests := []struct {
+		filename string
+		path     string
+		format   string
+	}{
+		{"photo.jpg", "photo.jpg", ""},
+		{"photo.jpg.webp", "photo.jpg", "webp"},
+		{"albums/2024/photo.PNG.avif", "albums/2024/photo.PNG", "avif"},
+		{"scan.pdf.png", "scan.pdf", "png"},
+		{"my.photo.png", "my.photo.png", ""},
+		{"img.2024.jpg", "img.2024.jpg", ""},
+		{"foo.v2.png", "foo.v2.png", ""},
+		{"albums/v1.2/photo.png", "albums/v1.2/photo.png", ""},
+		{"photo.jpg.txt", "photo.jpg.txt", ""},
+		{"photo", "photo", ""},
+	}
+	for _, tt := range tests {
+		path, format := splitFormatSuffix(tt.filename)
+		if path != tt.path || format != tt.format {
+			t.Errorf("splitFormatSuffix(%q) = %q, %q, want %q, %q",
+				tt.filename, path, format, tt.path, tt.format)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/kxlt/imageresizer/imager"
+	"log"
+	"math/bits"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	phashTier = "phash"
+	// defaultDuplicateDistance is the Hamming distance under which hashes
+	// are near-duplicates, e.g. resized, recompressed or slightly edited
+	defaultDuplicateDistance = 10
+)
+
+// phash returns the perceptual hash of the original at path and adds it to
+// the index of hashes
+func (api *Api) phash(ctx context.Context, path string) (uint64, error) {
+	buf, err := api.derived(ctx, phashTier, path, func(src []byte) ([]byte, error) {
+		src, err := api.still(ctx, src)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := imager.PerceptualHash(ctx, src)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(map[string]string{"phash": fmt.Sprintf("%016x", hash)})
+	})
+	if err != nil {
+		return 0, err
+	}
+	hash, err := parsePHash(buf)
+	if err != nil {
+		return 0, err
+	}
+	api.PHashes.Put(path, hash)
+	return hash, nil
+}
+
+func parsePHash(buf []byte) (uint64, error) {
+	var fields map[string]string
+	if err := json.Unmarshal(buf, &fields); err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(fields["phash"], 16, 64)
+}
+
+// indexPHashes loads the cached hashes into the index, originals without
+// one are indexed once uploaded or once their hash is requested
+func (api *Api) indexPHashes() {
+	ctx := context.Background()
+	names, err := api.Thumbnails.List(ctx, phashTier+"/")
+	if err != nil {
+		log.Println("Perceptual hashes could not be listed:", err)
+		return
+	}
+	for _, name := range names {
+		buf, err := api.Thumbnails.Get(ctx, name)
+		if err != nil {
+			continue
+		}
+		if hash, err := parsePHash(buf); err == nil {
+			api.PHashes.Put(strings.TrimPrefix(name, phashTier+"/"), hash)
+		}
+	}
+	log.Println("Perceptual hashes loaded:", api.PHashes.Size())
+}
+
+// reindexPHash replaces the hash of an uploaded original
+func (api *Api) reindexPHash(path string) {
+	ctx := context.Background()
+	api.Thumbnails.Remove(ctx, phashTier+"/"+path)
+	if _, err := api.phash(ctx, path); err != nil {
+		api.PHashes.Remove(path)
+	}
+}
+
+func (api *Api) servePHash() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hash, err := api.phash(r.Context(), mux.Vars(r)["path"])
+		var buf []byte
+		if err == nil {
+			buf, err = json.Marshal(map[string]string{"phash": fmt.Sprintf("%016x", hash)})
+		}
+		respondWithDerived(w, buf, err)
+	}
+}
+
+// serveDuplicates responds with the indexed originals whose hash is at most
+// distance bits away from the one of the original, the closest first
+func (api *Api) serveDuplicates() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := mux.Vars(r)["path"]
+		distance := defaultDuplicateDistance
+		if value := r.URL.Query().Get("distance"); value != "" {
+			var err error
+			if distance, err = strconv.Atoi(value); err != nil || distance < 0 || distance > 64 {
+				respondWithErr(w, http.StatusBadRequest)
+				return
+			}
+		}
+		hash, err := api.phash(r.Context(), path)
+		if err != nil {
+			respondWithDerived(w, nil, err)
+			return
+		}
+		type duplicate struct {
+			Path     string `json:"path"`
+			Distance int    `json:"distance"`
+		}
+		duplicates := []duplicate{}
+		api.PHashes.Walk(func(key string, val interface{}) {
+			d := bits.OnesCount64(hash ^ val.(uint64))
+			if key != path && d <= distance {
+				duplicates = append(duplicates, duplicate{Path: key, Distance: d})
+			}
+		})
+		sort.Slice(duplicates, func(i, j int) bool {
+			if duplicates[i].Distance != duplicates[j].Distance {
+				return duplicates[i].Distance < duplicates[j].Distance
+			}
+			return duplicates[i].Path < duplicates[j].Path
+		})
+		buf, err := json.Marshal(map[string]interface{}{"duplicates": duplicates})
+		respondWithDerived(w, buf, err)
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"errors"
+	"github.com/kxlt/imageresizer/imager"
+	"net/url"
+	"strings"
+)
+
+// pipelineMatch matches the operations of pipeline URLs, name:value path
+// segments, e.g. resize:300x200/crop:smart/blur:3/format:webp
+const pipelineMatch = "{ops:[a-z]+:[^/]+(?:/[a-z]+:[^/]+)*}"
+
+// parsePipeline turns the operations of a pipeline URL into the route
+// variables and query parameters of the equivalent thumbnail URL, so both
+// share their cache keys. Besides resize, one of the resize ops may be
+// given, fit:0 by default. The other operations are query parameters,
+// applied in the usual order whatever theirs in the URL.
+func parsePipeline(ops string, query url.Values) (map[string]string, error) {
+	vars := map[string]string{"resizeOp": "fit", "options": "0"}
+	seen := map[string]bool{}
+	resizeOp := false
+	for _, op := range strings.Split(ops, "/") {
+		i := strings.Index(op, ":")
+		name, value := op[:i], op[i+1:]
+		// crop:x,y,w,h is the crop parameter rather than the resize op
+		area := name == "crop" && strings.Contains(value, ",")
+		key := name
+		if area {
+			key = "crop area"
+		}
+		if seen[key] {
+			return nil, errors.New("duplicate operation " + name)
+		}
+		seen[key] = true
+		if _, ok := imager.ResizeOp[name]; ok && !area {
+			if resizeOp {
+				return nil, errors.New("more than one resize op")
+			}
+			resizeOp = true
+			vars["resizeOp"], vars["options"] = name, value
+			continue
+		}
+		if name == "resize" {
+			dims := strings.SplitN(value, "x", 2)
+			for _, dim := range dims {
+				if n, err := parseDimension(dim); err != nil || n < 0 {
+					return nil, errors.New("invalid dimensions")
+				}
+			}
+			vars["width"], vars["height"] = dims[0], dims[len(dims)-1]
+			continue
+		}
+		if _, ok := thumbParams[name]; !ok {
+			return nil, errors.New("invalid operation " + name)
+		}
+		query.Set(name, value)
+	}
+	if !seen["resize"] && !seen["scale"] {
+		return nil, errors.New("missing resize or scale")
+	}
+	return vars, nil
+}
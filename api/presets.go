@@ -0,0 +1,68 @@
+package api
+
+import (
+	"errors"
+	"github.com/kxlt/imageresizer/config"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// preset is a named thumbnail transformation
+type preset struct {
+	// vars hold the resize tier, as the route variables of thumbnails
+	vars  map[string]string
+	query url.Values
+}
+
+// loadPresets parses the configured presets, exiting on invalid ones
+func loadPresets() map[string]*preset {
+	presets := map[string]*preset{}
+	for name, spec := range config.C.Presets {
+		p, err := parsePreset(spec)
+		if err != nil {
+			log.Fatalln("Invalid preset", name+":", err)
+		}
+		presets[name] = p
+	}
+	return presets
+}
+
+// parsePreset parses a resize tier followed by optional query parameters,
+// e.g. 200x200/crop/smart?format=webp&quality=75
+func parsePreset(spec string) (*preset, error) {
+	tier, rawQuery := spec, ""
+	if i := strings.Index(spec, "?"); i >= 0 {
+		tier, rawQuery = spec[:i], spec[i+1:]
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(tier, "/")
+	if len(parts) != 3 {
+		return nil, errors.New("invalid resize tier")
+	}
+	dims := strings.SplitN(parts[0], "x", 2)
+	vars := map[string]string{
+		"width":    dims[0],
+		"height":   dims[len(dims)-1],
+		"resizeOp": parts[1],
+		"options":  parts[2],
+	}
+	// checked once rather than failing every request
+	options, err := parseParams(vars)
+	if err != nil {
+		return nil, err
+	}
+	if options.Width < 0 || options.Height < 0 {
+		return nil, errors.New("invalid dimensions")
+	}
+	if _, err := parseQuery(query, &options); err != nil {
+		return nil, err
+	}
+	if err := checkDimensions(options); err != nil {
+		return nil, err
+	}
+	return &preset{vars: vars, query: query}, nil
+}
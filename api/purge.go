@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/kxlt/imageresizer/etag"
+	"github.com/rcrowley/go-metrics"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// purgeRequest selects the thumbnails of a purge, of the original at Path
+// or of those under Prefix, in Tier or in all tiers
+type purgeRequest struct {
+	Path   string `json:"path"`
+	Prefix string `json:"prefix"`
+	Tier   string `json:"tier"`
+}
+
+// purgeThumbnails removes the thumbnails and data derived from the
+// originals at path, or under it with prefix, returning how many were. Their
+// etags are forgotten so clients holding them get the new thumbnails.
+func (api *Api) purgeThumbnails(ctx context.Context, tiers []string, path string, prefix bool) int {
+	purged := 0
+	for _, tier := range tiers {
+		keys := []string{tier + "/" + path}
+		if prefix {
+			var err error
+			if keys, err = api.Thumbnails.List(ctx, tier+"/"+path); err != nil {
+				continue
+			}
+		}
+		for _, key := range keys {
+			if api.Etags != nil {
+				if buf, _ := api.Thumbnails.Get(ctx, key); buf != nil {
+					api.Etags.Remove(etag.Generate(buf, true))
+				}
+			}
+			if api.Thumbnails.Remove(ctx, key) == nil {
+				purged++
+			}
+		}
+	}
+	return purged
+}
+
+// handlePurges removes cached thumbnails while keeping the originals, e.g.
+// once an original was replaced upstream, responding with how many were
+func (api *Api) handlePurges() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t := metrics.GetOrRegisterTimer("api.purges.latency", nil)
+		t.Time(func() {
+			var req purgeRequest
+			err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&req)
+			if err != nil || (req.Path == "") == (req.Prefix == "") ||
+				(req.Path != "" && !validPath(req.Path)) ||
+				strings.Contains(req.Prefix, "..") {
+				respondWithErr(w, http.StatusBadRequest)
+				return
+			}
+			var tiers []string
+			if req.Tier != "" {
+				if !api.Tiers.Contains(req.Tier) {
+					respondWithErr(w, http.StatusNotFound)
+					return
+				}
+				tiers = []string{req.Tier}
+			} else {
+				api.Tiers.Walk(func(tier string) {
+					tiers = append(tiers, tier)
+				})
+			}
+			var purged int
+			if req.Path != "" {
+				purged = api.purgeThumbnails(r.Context(), tiers, req.Path, false)
+			} else {
+				purged = api.purgeThumbnails(r.Context(), tiers, req.Prefix, true)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]int{"purged": purged})
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/kxlt/imageresizer/config"
+	"github.com/kxlt/imageresizer/imager"
+	"net/http"
+	"net/url"
+)
+
+// queryAliases maps the short parameters of query string transformations
+// to the thumbnail query parameters they stand for
+var queryAliases = map[string]string{
+	"fmt": "format",
+	"q":   "quality",
+}
+
+// hasQueryTransform matches the requests of originals asking for a
+// thumbnail in their query string, e.g. /photo.jpg?w=300&h=200
+func hasQueryTransform(r *http.Request, _ *mux.RouteMatch) bool {
+	query := r.URL.Query()
+	return query.Get("w") != "" || query.Get("h") != "" || query.Get("scale") != ""
+}
+
+// parseQueryTransform turns the w, h, fit, g and bg query parameters into
+// the route variables of the equivalent thumbnail URL, so both share their
+// cache keys. fit is the resize op, crop when only g is given and fit
+// otherwise, and g and bg its setting. fmt and q are aliases of format and
+// quality, the other query parameters are kept as is.
+func parseQueryTransform(query url.Values) (map[string]string, error) {
+	vars := map[string]string{"width": query.Get("w"), "height": query.Get("h"), "options": "0"}
+	for _, dim := range []string{vars["width"], vars["height"]} {
+		if n, err := parseDimension(dim); err != nil || n < 0 {
+			return nil, errors.New("invalid dimensions")
+		}
+	}
+	vars["resizeOp"] = query.Get("fit")
+	if vars["resizeOp"] == "" {
+		vars["resizeOp"] = "fit"
+		if query.Get("g") != "" {
+			vars["resizeOp"] = "crop"
+		}
+	}
+	if _, ok := imager.ResizeOp[vars["resizeOp"]]; !ok {
+		return nil, errors.New("invalid fit")
+	}
+	if g, bg := query.Get("g"), query.Get("bg"); g != "" && bg != "" {
+		return nil, errors.New("g and bg can't be combined")
+	} else if g != "" {
+		vars["options"] = g
+	} else if bg != "" {
+		vars["options"] = bg
+	}
+	for alias, name := range queryAliases {
+		value := query.Get(alias)
+		if value == "" {
+			continue
+		}
+		if query.Get(name) != "" {
+			return nil, errors.New(alias + " and " + name + " can't be combined")
+		}
+		query.Set(name, value)
+	}
+	return vars, nil
+}
+
+// serveQueryThumbs serves the thumbnails of query string transformations
+func (api *Api) serveQueryThumbs() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.C.PresetsOnly {
+			respondWithErr(w, http.StatusForbidden)
+			return
+		}
+		query := r.URL.Query()
+		vars, err := parseQueryTransform(query)
+		if err != nil {
+			respondWithErr(w, http.StatusBadRequest)
+			return
+		}
+		vars["path"] = mux.Vars(r)["path"]
+		api.serveThumb(w, r, vars, query)
+	}
+}
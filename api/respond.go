@@ -3,8 +3,11 @@ package api
 import (
 	"encoding/json"
 	"github.com/kxlt/imageresizer/imager"
+	"github.com/kxlt/imageresizer/store"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type ImageResponse struct {
@@ -16,6 +19,14 @@ type ImageResponse struct {
 var mimeTypes = map[imager.ImageType]string{
 	imager.JPEG: "image/jpeg",
 	imager.PNG:  "image/png",
+	imager.WEBP: "image/webp",
+	imager.AVIF: "image/avif",
+	imager.GIF:  "image/gif",
+	imager.MP4:  "video/mp4",
+	imager.WEBM: "video/webm",
+	imager.PDF:  "application/pdf",
+	imager.HEIC: "image/heic",
+	imager.TIFF: "image/tiff",
 }
 
 func respondWithImage(w http.ResponseWriter, imgResponse *ImageResponse) {
@@ -26,6 +37,40 @@ func respondWithImage(w http.ResponseWriter, imgResponse *ImageResponse) {
 	w.Write(imgResponse.buf)
 }
 
+// respondWithFile streams content, http.ServeContent takes care of range and
+// conditional requests
+func respondWithFile(
+	w http.ResponseWriter,
+	r *http.Request,
+	content io.ReadSeeker,
+	modTime time.Time,
+	imgResponse *ImageResponse) {
+
+	w.Header().Set("Content-Type", mimeTypes[imgResponse.format])
+	w.Header().Set("ETag", imgResponse.etag)
+	http.ServeContent(w, r, "", modTime, content)
+}
+
+// respondWithStream copies content to the response, headers are set from
+// the stored file's metadata
+func respondWithStream(
+	w http.ResponseWriter,
+	content io.Reader,
+	info store.Info,
+	imgResponse *ImageResponse) {
+
+	w.Header().Set("Content-Type", mimeTypes[imgResponse.format])
+	if info.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	w.Header().Set("ETag", imgResponse.etag)
+	if !info.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, content)
+}
+
 func respondWithErr(w http.ResponseWriter, statusCode int) {
 	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(map[string]interface{}{
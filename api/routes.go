@@ -1,40 +1,161 @@
 package api
 
 import (
+	"bufio"
+	"context"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/kxlt/imageresizer/config"
 	"io"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 	"unicode/utf8"
 
 	"github.com/gorilla/mux"
 	"github.com/kxlt/imageresizer/etag"
 	"github.com/kxlt/imageresizer/imager"
+	"github.com/kxlt/imageresizer/store"
 	"github.com/rcrowley/go-metrics"
 )
 
+// pathMatch matches the paths of originals, slashes included, e.g.
+// albums/2024/img.jpg, see validPath
 const pathMatch = "{path:.+}"
 
+// maxPathLength bounds the length of paths, the longest keys of most
+// object stores
+const maxPathLength = 1024
+
 func (api *Api) routes() {
+	api.Use(api.timeoutMiddleware)
+	api.Use(api.pathMiddleware)
+	api.Use(api.signatureMiddleware)
 	api.Handle("/favicon.ico", api.handle404())
 	api.Handle("/debug/metrics", http.DefaultServeMux)
+	api.HandleFunc("/healthz", api.handleHealth(false)).Methods("GET", "HEAD")
+	api.HandleFunc("/readyz", api.handleHealth(true)).Methods("GET", "HEAD")
+	api.HandleFunc("/p/{preset}/"+pathMatch,
+		api.etagMiddleware(api.servePresets())).Methods("GET", "HEAD")
+	api.HandleFunc("/blurhash/"+pathMatch, api.serveBlurHash()).Methods("GET", "HEAD")
+	api.HandleFunc("/lqip/"+pathMatch, api.serveLQIP()).Methods("GET", "HEAD")
+	api.HandleFunc("/colors/"+pathMatch, api.serveColors()).Methods("GET", "HEAD")
+	api.HandleFunc("/info/"+pathMatch, api.serveInfo()).Methods("GET", "HEAD")
+	if config.C.PHashEnable {
+		api.HandleFunc("/phash/"+pathMatch, api.servePHash()).Methods("GET", "HEAD")
+		api.HandleFunc("/duplicates/"+pathMatch, api.serveDuplicates()).Methods("GET", "HEAD")
+	}
+	// job ids can't be guessed, they are enough to follow jobs
+	api.HandleFunc("/api/jobs/{id}", api.serveJobs()).Methods("GET", "HEAD")
+	// registered without admin.token too, so they don't fall through to
+	// the originals
+	api.HandleFunc("/api/batch", api.adminMiddleware(api.handleBatchDeletes())).Methods("POST", "DELETE")
+	api.HandleFunc("/api/purge", api.adminMiddleware(api.handlePurges())).Methods("POST")
+	api.HandleFunc("/api/warm", api.adminMiddleware(api.handleWarms())).Methods("POST")
 	// shortcut
 	api.HandleFunc("/{width:[1-9][0-9]*}/{resizeOp}/{options}/"+pathMatch,
 		api.etagMiddleware(api.serveThumbs())).Methods("GET", "HEAD")
-	api.HandleFunc("/{width:[1-9][0-9]*}x{height:[1-9][0-9]*}/{resizeOp}/{options}/"+pathMatch,
+	// either dimension may be left to the aspect ratio of the original
+	api.HandleFunc("/{width:(?:[1-9][0-9]*)?}x{height:(?:[1-9][0-9]*)?}/{resizeOp}/{options}/"+pathMatch,
 		api.etagMiddleware(api.serveThumbs())).Methods("GET", "HEAD")
+	api.HandleFunc("/"+pipelineMatch+"/"+pathMatch,
+		api.etagMiddleware(api.servePipelines())).Methods("GET", "HEAD")
+	api.HandleFunc("/"+pathMatch, api.etagMiddleware(api.serveQueryThumbs())).
+		Methods("GET", "HEAD").MatcherFunc(hasQueryTransform)
 	api.HandleFunc("/"+pathMatch, api.etagMiddleware(api.serveOriginals())).
 		Methods("GET", "HEAD")
 	api.HandleFunc("/"+pathMatch, api.handleCreates()).Methods("POST")
 	api.HandleFunc("/"+pathMatch, api.handleDeletes()).Methods("DELETE")
 }
 
+// handleHealth checks libvips and the stores of originals and thumbnails,
+// answering 503 when any of them is unhealthy. With ready, the caches must
+// be loaded too, so that traffic waits for them.
+func (api *Api) handleHealth(ready bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		checks := map[string]string{"libvips": "ok"}
+		if err := imager.Healthy(r.Context()); err != nil {
+			checks["libvips"] = err.Error()
+			status = http.StatusServiceUnavailable
+		}
+		if ready {
+			checks["caches"] = "ok"
+			if atomic.LoadInt32(&api.loaded) == 0 {
+				checks["caches"] = "loading"
+				status = http.StatusServiceUnavailable
+			}
+		}
+		for name, s := range map[string]store.Store{
+			"originals":  api.Originals,
+			"thumbnails": api.Thumbnails,
+		} {
+			checks[name] = "ok"
+			if err := store.Healthy(r.Context(), s); err != nil {
+				checks[name] = err.Error()
+				status = http.StatusServiceUnavailable
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(checks)
+	}
+}
+
+// pathMiddleware refuses the requests whose path isn't valid with a 400.
+// The router redirects to the cleaned URL beforehand, so only the ones
+// which can't be cleaned are left.
+func (api *Api) pathMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path, ok := mux.Vars(r)["path"]; ok && !validPath(path) {
+			respondWithErr(w, http.StatusBadRequest)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// validPath reports whether path is a file of the stores: slash separated
+// segments other than . and .., without control characters or backslashes,
+// which file stores would take as separators on Windows
+func validPath(path string) bool {
+	if path == "" || len(path) > maxPathLength || !utf8.ValidString(path) {
+		return false
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	for _, r := range path {
+		if r < 0x20 || r == 0x7f || r == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// timeoutMiddleware cancels the request context after the configured
+// timeout, aborting slow store calls and resizes
+func (api *Api) timeoutMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.C.ServerTimeout <= 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(),
+			time.Duration(config.C.ServerTimeout)*time.Millisecond)
+		defer cancel()
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func (api *Api) etagMiddleware(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if config.C.EtagCacheEnable {
@@ -53,7 +174,7 @@ func (api *Api) serveOriginals() http.HandlerFunc {
 		t := metrics.GetOrRegisterTimer("api.originals.latency", nil)
 		t.Time(func() {
 			vars := mux.Vars(r)
-			buf, err := api.Originals.Get(vars["path"])
+			info, err := api.Originals.Stat(r.Context(), vars["path"])
 			if err != nil {
 				if os.IsNotExist(err) {
 					respondWithErr(w, http.StatusNotFound)
@@ -62,9 +183,8 @@ func (api *Api) serveOriginals() http.HandlerFunc {
 				}
 				return
 			}
-			imgResponse := &ImageResponse{buf: buf}
 
-			etg := etag.Generate(buf, true)
+			etg := originalEtag(info)
 			if config.C.EtagCacheEnable {
 				api.Etags.Add(etg)
 			}
@@ -72,63 +192,246 @@ func (api *Api) serveOriginals() http.HandlerFunc {
 				respondWithStatusCode(w, http.StatusNotModified)
 				return
 			}
-			imgResponse.etag = etg
-			imgResponse.format = imager.GetImageType(buf)
-			respondWithImage(w, imgResponse)
+
+			rc, err := store.GetReader(r.Context(), api.Originals, vars["path"])
+			if err != nil {
+				if os.IsNotExist(err) {
+					respondWithErr(w, http.StatusNotFound)
+				} else {
+					respondWithErr(w, http.StatusInternalServerError)
+				}
+				return
+			}
+			defer rc.Close()
+			imgResponse := &ImageResponse{etag: etg}
+			if f, ok := rc.(*os.File); ok {
+				head := make([]byte, 12)
+				n, _ := f.ReadAt(head, 0)
+				imgResponse.format = imager.GetImageType(head[:n])
+				respondWithFile(w, r, f, info.ModTime, imgResponse)
+				return
+			}
+			br := bufio.NewReader(rc)
+			head, _ := br.Peek(12)
+			imgResponse.format = imager.GetImageType(head)
+			respondWithStream(w, br, info, imgResponse)
 		})
 	}
 }
 
+// originalEtag returns the Etag of an original, derived from its content
+// hash when the store knows it
+func originalEtag(info store.Info) string {
+	if info.Hash != "" {
+		return etag.GenerateFromHash(info.Size, info.Hash)
+	}
+	return etag.GenerateFromStat(info.Size, info.ModTime)
+}
+
+// uploadPrecondition builds the precondition of an upload from its
+// If-Match and If-None-Match headers
+func uploadPrecondition(r *http.Request) store.Precondition {
+	cond := store.Precondition{
+		IfNotExists: !config.C.UploadOverwrite || r.Header.Get("If-None-Match") == "*",
+	}
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		cond.IfMatch = func(info store.Info) bool {
+			etg := originalEtag(info)
+			for _, tag := range strings.Split(ifMatch, ",") {
+				if tag = strings.TrimSpace(tag); tag == "*" || tag == etg {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return cond
+}
+
 func (api *Api) serveThumbs() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		t := metrics.GetOrRegisterTimer("api.thumbs.latency", nil)
-		t.Time(func() {
-			vars := mux.Vars(r)
-			if _, ok := vars["height"]; !ok {
-				vars["height"] = vars["width"]
-			}
-			resizeTier := fmt.Sprintf("%sx%s/%s/%s",
-				vars["width"],
-				vars["height"],
-				vars["resizeOp"],
-				vars["options"])
-			path := vars["path"]
-			thumbPath := resizeTier + "/" + path
-			api.Tiers.Add(resizeTier)
-			thumbBuf, _ := api.Thumbnails.Get(thumbPath)
-			if thumbBuf == nil {
-				srcBuf, err := api.Originals.Get(path)
-				if err != nil {
-					respondWithErr(w, http.StatusNotFound)
+		if config.C.PresetsOnly {
+			respondWithErr(w, http.StatusForbidden)
+			return
+		}
+		vars := mux.Vars(r)
+		if _, ok := vars["height"]; !ok {
+			vars["height"] = vars["width"]
+		}
+		api.serveThumb(w, r, vars, r.URL.Query())
+	}
+}
+
+// servePipelines serves the thumbnails of pipeline URLs, parameters of the
+// query string are overridden by the operations
+func (api *Api) servePipelines() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.C.PresetsOnly {
+			respondWithErr(w, http.StatusForbidden)
+			return
+		}
+		query := r.URL.Query()
+		vars, err := parsePipeline(mux.Vars(r)["ops"], query)
+		if err != nil {
+			respondWithErr(w, http.StatusBadRequest)
+			return
+		}
+		vars["path"] = mux.Vars(r)["path"]
+		api.serveThumb(w, r, vars, query)
+	}
+}
+
+// servePresets serves the thumbnails of the named presets, the query string
+// of the request is ignored
+func (api *Api) servePresets() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		p, ok := api.Presets[vars["preset"]]
+		if !ok {
+			respondWithErr(w, http.StatusNotFound)
+			return
+		}
+		// presets are exempt from resize.allowed
+		presetVars := map[string]string{"path": vars["path"], "preset": vars["preset"]}
+		for k, v := range p.vars {
+			presetVars[k] = v
+		}
+		query := url.Values{}
+		for k, v := range p.query {
+			query[k] = v
+		}
+		api.serveThumb(w, r, presetVars, query)
+	}
+}
+
+// serveThumb serves the thumbnail of the resize tier in vars with the
+// parameters of query, which it may modify
+func (api *Api) serveThumb(w http.ResponseWriter, r *http.Request, vars map[string]string, query url.Values) {
+	t := metrics.GetOrRegisterTimer("api.thumbs.latency", nil)
+	t.Time(func() {
+		path, format := splitFormatSuffix(vars["path"])
+		if format != "" && query.Get("format") == "" {
+			query.Set("format", format)
+		}
+		if query.Get("format") == "" && len(config.C.FormatNegotiate) > 0 {
+			// the format depends on the client, variants are cached
+			// under their own key
+			w.Header().Set("Vary", "Accept")
+			if format := negotiateFormat(r.Header.Get("Accept")); format != "" {
+				query.Set("format", format)
+			}
+		}
+		options, err := parseParams(vars)
+		if err != nil {
+			respondWithErr(w, http.StatusBadRequest)
+			return
+		}
+		params, err := parseQuery(query, &options)
+		if err == nil {
+			err = checkDimensions(options)
+		}
+		if err != nil {
+			respondWithErr(w, http.StatusBadRequest)
+			return
+		}
+		if _, preset := vars["preset"]; !preset && !allowedSize(vars, options) {
+			respondWithErr(w, http.StatusBadRequest)
+			return
+		}
+		if config.C.ResizeMaxMegapixels > 0 {
+			// for dimensions left to the aspect ratio of originals
+			options.MaxPixels = int(config.C.ResizeMaxMegapixels * 1e6)
+		}
+		applyDefaults(&options)
+		options.Watermark = api.watermark(path)
+		if options.Overlay != nil && options.Overlay.Path == "" {
+			// overlay options without an overlay
+			respondWithErr(w, http.StatusBadRequest)
+			return
+		}
+		if options.Canvas != nil && options.Canvas.Width == 0 {
+			// canvasbg without a canvas
+			respondWithErr(w, http.StatusBadRequest)
+			return
+		}
+		resizeTier := fmt.Sprintf("%sx%s/%s/%s",
+			vars["width"],
+			vars["height"],
+			vars["resizeOp"],
+			vars["options"])
+		if params != "" {
+			// variants are kept apart from the plain resize
+			resizeTier += "," + params
+		}
+		thumbPath := resizeTier + "/" + path
+		api.Tiers.Add(resizeTier)
+		thumbBuf, _ := api.Thumbnails.Get(r.Context(), thumbPath)
+		if thumbBuf == nil && api.async(r, path) {
+			api.startThumbJob(w, r, vars, query, thumbPath)
+			return
+		}
+		if thumbBuf == nil {
+			srcBuf, err := api.Originals.Get(r.Context(), path)
+			if err != nil {
+				respondWithErr(w, http.StatusNotFound)
+				return
+			}
+			if srcBuf, err = api.upscale(r.Context(), path, srcBuf, &options); err != nil {
+				respondWithErr(w, http.StatusBadGateway)
+				return
+			}
+			if srcBuf, err = api.cutout(r.Context(), path, srcBuf, &options); err != nil {
+				respondWithErr(w, http.StatusBadGateway)
+				return
+			}
+			if _, ok := videoFormats[imager.GetImageType(srcBuf)]; ok {
+				if api.FFmpeg == nil {
+					respondWithErr(w, http.StatusUnsupportedMediaType)
 					return
 				}
-				options, err := parseParams(vars)
-				if err != nil {
-					respondWithErr(w, http.StatusBadRequest)
+				if srcBuf, err = api.FFmpeg.Frame(r.Context(), srcBuf, options.Time); err != nil {
+					respondWithErr(w, http.StatusUnprocessableEntity)
 					return
 				}
-				thumbBuf, err = imager.Resize(srcBuf, options)
-				if err != nil {
-					respondWithErr(w, http.StatusInternalServerError)
+			}
+			if options.Overlay != nil {
+				if options.Overlay.Image, err = api.Originals.Get(r.Context(), options.Overlay.Path); err != nil {
+					respondWithErr(w, http.StatusBadRequest)
 					return
 				}
-				go api.Thumbnails.Put(thumbPath, thumbBuf)
 			}
-			imgResponse := &ImageResponse{buf: thumbBuf}
-
-			etg := etag.Generate(thumbBuf, true)
-			if config.C.EtagCacheEnable {
-				api.Etags.Add(etg)
+			thumbBuf, err = imager.Resize(r.Context(), srcBuf, options)
+			if ext, ok := videoFormats[options.Format]; ok && err == nil {
+				thumbBuf, err = api.FFmpeg.Transcode(r.Context(), thumbBuf, ext)
 			}
-			if r.Header.Get("If-None-Match") == etg {
-				respondWithStatusCode(w, http.StatusNotModified)
+			if err == imager.ErrTooLarge {
+				respondWithErr(w, http.StatusBadRequest)
 				return
 			}
-			imgResponse.etag = etg
-			imgResponse.format = imager.GetImageType(thumbBuf)
-			respondWithImage(w, imgResponse)
-		})
-	}
+			if err == imager.ErrSourceTooLarge {
+				respondWithErr(w, http.StatusUnprocessableEntity)
+				return
+			}
+			if err != nil {
+				respondWithErr(w, http.StatusInternalServerError)
+				return
+			}
+			go api.Thumbnails.Put(context.Background(), thumbPath, thumbBuf)
+		}
+		imgResponse := &ImageResponse{buf: thumbBuf}
+
+		etg := etag.Generate(thumbBuf, true)
+		if config.C.EtagCacheEnable {
+			api.Etags.Add(etg)
+		}
+		if r.Header.Get("If-None-Match") == etg {
+			respondWithStatusCode(w, http.StatusNotModified)
+			return
+		}
+		imgResponse.etag = etg
+		imgResponse.format = imager.GetImageType(thumbBuf)
+		respondWithImage(w, imgResponse)
+	})
 }
 
 func (api *Api) handleCreates() http.HandlerFunc {
@@ -137,7 +440,31 @@ func (api *Api) handleCreates() http.HandlerFunc {
 			reader   io.Reader
 			filename string
 		)
-		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if api.isFetch(r) {
+			body, err := api.fetch(r)
+			if err == errFetchURL {
+				respondWithErr(w, http.StatusBadRequest)
+				return
+			}
+			if err == errFetchForbidden {
+				respondWithErr(w, http.StatusForbidden)
+				return
+			}
+			if err == errFetchType {
+				respondWithErr(w, http.StatusUnsupportedMediaType)
+				return
+			}
+			if err == errFetchTooLarge {
+				respondWithErr(w, http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				respondWithErr(w, http.StatusBadGateway)
+				return
+			}
+			defer body.Close()
+			reader = body
+		} else if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
 			file, _, err := r.FormFile("file")
 			if err != nil {
 				respondWithErr(w, http.StatusBadRequest)
@@ -148,20 +475,48 @@ func (api *Api) handleCreates() http.HandlerFunc {
 			reader = r.Body
 		}
 		filename = mux.Vars(r)["path"]
-		buf, err := ioutil.ReadAll(io.LimitReader(reader, config.C.UploadMaxSize))
-		if len(buf) == 0 || err != nil {
+		br := bufio.NewReader(reader)
+		if _, err := br.Peek(1); err != nil {
 			respondWithErr(w, http.StatusBadRequest)
 			return
 		}
-		if int64(len(buf)) == config.C.UploadMaxSize {
+		err := store.PutReaderIf(r.Context(), api.Originals, filename,
+			store.LimitReader(br, config.C.UploadMaxSize), uploadPrecondition(r))
+		if err == store.ErrTooLarge {
 			respondWithErr(w, http.StatusRequestEntityTooLarge)
 			return
 		}
-		err = api.Originals.Put(filename, buf)
+		if err == store.ErrReadOnly {
+			respondWithErr(w, http.StatusMethodNotAllowed)
+			return
+		}
+		if err == store.ErrReservedKey {
+			respondWithErr(w, http.StatusBadRequest)
+			return
+		}
+		if err == store.ErrExists && r.Header.Get("If-None-Match") != "*" {
+			respondWithErr(w, http.StatusConflict)
+			return
+		}
+		if err == store.ErrExists || err == store.ErrPreconditionFailed {
+			respondWithErr(w, http.StatusPreconditionFailed)
+			return
+		}
+		if err == store.ErrQuotaExceeded {
+			respondWithErr(w, http.StatusInsufficientStorage)
+			return
+		}
+		if err == store.ErrPrefixQuotaExceeded {
+			respondWithErr(w, http.StatusTooManyRequests)
+			return
+		}
 		if err != nil {
 			respondWithErr(w, http.StatusInternalServerError)
 			return
 		}
+		if api.PHashes != nil {
+			go api.reindexPHash(filename)
+		}
 		respondWithStatusCode(w, http.StatusCreated)
 	}
 }
@@ -172,11 +527,15 @@ func (api *Api) handleDeletes() http.HandlerFunc {
 		t.Time(func() {
 			vars := mux.Vars(r)
 			path := vars["path"]
-			err := api.Originals.Remove(path)
+			err := api.deleteOriginal(r.Context(), path)
+			if err == store.ErrReadOnly {
+				respondWithErr(w, http.StatusMethodNotAllowed)
+				return
+			}
 			if err != nil {
 				respondWithErr(w, http.StatusNotFound)
+				return
 			}
-			api.removeThumbnails(path)
 			respondWithStatusCode(w, http.StatusNoContent)
 		})
 	}
@@ -189,11 +548,11 @@ func (api *Api) handle404() http.HandlerFunc {
 }
 
 func parseParams(vars map[string]string) (imager.Options, error) {
-	width, err := strconv.Atoi(vars["width"])
+	width, err := parseDimension(vars["width"])
 	if err != nil {
 		return imager.Options{}, err
 	}
-	height, err := strconv.Atoi(vars["height"])
+	height, err := parseDimension(vars["height"])
 	if err != nil {
 		return imager.Options{}, err
 	}
@@ -201,14 +560,35 @@ func parseParams(vars map[string]string) (imager.Options, error) {
 	if !ok {
 		return imager.Options{}, errors.New("invalid resizeOp")
 	}
+	if strings.Contains(vars["options"], ",") {
+		// reserved for the query parameters in cache keys
+		return imager.Options{}, errors.New("invalid options")
+	}
 	options := imager.Options{
-		Width:    width,
-		Height:   height,
-		ResizeOp: resizeOp,
+		Width:        width,
+		Height:       height,
+		ResizeOp:     resizeOp,
+		Progressive:  config.C.FormatProgressive,
+		KeepMetadata: config.C.FormatKeepMetadata,
+		ICC:          imager.ICCModes[config.C.FormatICC],
+		NoEnlarge:    !config.C.EnlargeDefault,
+		Kernel:       imager.Kernels[config.C.ResizeKernel],
+	}
+	if config.C.SharpenDefault != "" {
+		if options.Sharpen, err = sharpenSigma(config.C.SharpenDefault); err != nil {
+			return imager.Options{}, err
+		}
+	}
+	if _, err = parseLossless(config.C.LosslessDefault, &options); err != nil {
+		return imager.Options{}, err
 	}
 	switch resizeOp {
 	case imager.CROP:
-		gravity, ok := imager.Gravity[vars["options"]]
+		name := vars["options"]
+		if name == "0" {
+			name = config.C.GravityDefault
+		}
+		gravity, ok := imager.Gravity[name]
 		if !ok {
 			return imager.Options{}, errors.New("invalid gravity")
 		}
@@ -222,11 +602,57 @@ func parseParams(vars map[string]string) (imager.Options, error) {
 			}
 			options.ExtendBackground = rgb
 		}
+	case imager.PAD:
+		bg, err := parseBackground(vars["options"])
+		if err != nil {
+			return imager.Options{}, err
+		}
+		options.ExtendBackground = bg
+	default:
+		if vars["options"] != "0" {
+			return imager.Options{}, errors.New("invalid options")
+		}
 	}
 
 	return options, nil
 }
 
+// checkDimensions checks that thumbnails have a dimension or are scaled,
+// once the query parameters are applied to options
+func checkDimensions(options imager.Options) error {
+	if options.Scale > 0 && (options.Width > 0 || options.Height > 0) {
+		return errors.New("scale with dimensions")
+	}
+	if options.Scale == 0 && options.Width == 0 && options.Height == 0 {
+		return errors.New("missing dimensions")
+	}
+	return nil
+}
+
+// parseDimension parses a dimension of thumbnails, 0 when empty
+func parseDimension(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// parseBackground parses the color of pad, an rgb or rgba hex color,
+// transparent, or 0 for pad.background
+func parseBackground(color string) ([]float64, error) {
+	switch color {
+	case "transparent":
+		return []float64{0, 0, 0, 0}, nil
+	case "0":
+		color = config.C.PadBackground
+	}
+	if n := utf8.RuneCountInString(color); n != 6 && n != 8 {
+		return nil, errors.New("invalid color")
+	}
+	return decodeHexRGB(color)
+}
+
+// decodeHexRGB decodes rrggbb, or rrggbbaa, colors
 func decodeHexRGB(hexRGB string) ([]float64, error) {
 	runes := []rune(hexRGB)
 	var (
@@ -234,7 +660,7 @@ func decodeHexRGB(hexRGB string) ([]float64, error) {
 		buf []byte
 		err error
 	)
-	for i := 0; i < 3; i++ {
+	for i := 0; i < len(runes)/2; i++ {
 		buf, err = hex.DecodeString(string(runes[i*2 : i*2+2]))
 		if err != nil {
 			return nil, errors.New("invalid color")
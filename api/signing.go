@@ -0,0 +1,77 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/gorilla/mux"
+	"github.com/kxlt/imageresizer/config"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Signature returns the signature of a URL, the unpadded base64url
+// HMAC-SHA256 with key of its escaped path, followed by ? and its query
+// sorted by key when it has one. The s parameter, holding the signature, is
+// left out.
+func Signature(key string, path string, query url.Values) string {
+	unsigned := url.Values{}
+	for k, v := range query {
+		if k != "s" {
+			unsigned[k] = v
+		}
+	}
+	message := path
+	if len(unsigned) > 0 {
+		message += "?" + unsigned.Encode()
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(message))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignURL appends the signature of rawURL to it, expiring at expires unless
+// it is zero
+func SignURL(key string, rawURL string, expires time.Time) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Del("s")
+	if !expires.IsZero() {
+		query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	}
+	query.Set("s", Signature(key, u.EscapedPath(), query))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// signatureMiddleware refuses the requests of originals and thumbnails
+// which aren't signed with sign.key, or past their expires timestamp, with
+// a 403. Uploads and deletes are left as is.
+func (api *Api) signatureMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := mux.Vars(r)["path"]; !ok || config.C.SignKey == "" ||
+			(r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		query := r.URL.Query()
+		expected := Signature(config.C.SignKey, r.URL.EscapedPath(), query)
+		if !hmac.Equal([]byte(query.Get("s")), []byte(expected)) {
+			respondWithErr(w, http.StatusForbidden)
+			return
+		}
+		if expires := query.Get("expires"); expires != "" {
+			t, err := strconv.ParseInt(expires, 10, 64)
+			if err != nil || time.Now().Unix() > t {
+				respondWithErr(w, http.StatusForbidden)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
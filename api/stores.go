@@ -0,0 +1,371 @@
+package api
+
+import (
+	"context"
+	"github.com/kxlt/imageresizer/config"
+	"github.com/kxlt/imageresizer/store"
+	"log"
+	"time"
+)
+
+// NewOriginStore returns the store holding the original images, as selected
+// by the configuration. Defaults to the local filesystem. Namespaces may be
+// routed to other backends, writes are mirrored to the replica backend if
+// any, and when fallback backends are configured reads fail over to them in
+// order. Quotas are left to WithQuota, above dedup if enabled.
+func NewOriginStore() store.Store {
+	primary := withRoutes(withRetry(NewOriginBackend(originBackend())))
+	if config.C.OriginReplica != "" {
+		r := store.NewReplicated(primary,
+			withRetry(NewOriginBackend(config.C.OriginReplica)),
+			config.C.OriginReplicaAsync,
+			config.C.OriginReplicaRetries,
+			config.C.OriginReplicaQueue)
+		withTombstones(r)
+		primary = r
+	}
+	if len(config.C.OriginFallback) == 0 {
+		return primary
+	}
+	stores := []store.Store{primary}
+	for _, name := range config.C.OriginFallback {
+		stores = append(stores, withRetry(NewOriginBackend(name)))
+	}
+	return store.NewFallback(stores...)
+}
+
+// withRoutes sends the configured namespaces to their own backend, the
+// others stay on def
+func withRoutes(def store.Store) store.Store {
+	if len(config.C.OriginRoutes) == 0 {
+		return def
+	}
+	backends := map[string]store.Store{originBackend(): def}
+	routes := map[string]store.Store{}
+	for ns, name := range config.C.OriginRoutes {
+		if backends[name] == nil {
+			backends[name] = withRetry(NewOriginBackend(name))
+		}
+		routes[ns] = backends[name]
+	}
+	return store.NewRouter(def, routes)
+}
+
+// withTombstones records the deletions of r until its replica applies them,
+// pending ones are retried periodically
+func withTombstones(r *store.Replicated) {
+	if config.C.OriginReplicaTombstones == "" {
+		return
+	}
+	r.Tombstones = newMetadataStore(config.C.OriginReplicaTombstones)
+	interval := time.Duration(config.C.OriginReplicaSweep) * time.Millisecond
+	go func() {
+		for {
+			n, err := r.SweepTombstones(context.Background())
+			if err != nil {
+				log.Println("Tombstone sweep failed:", err)
+			} else if n > 0 {
+				log.Printf("Tombstone sweep: %d deletions still pending", n)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// newMetadataStore returns the local store of index or bookkeeping files
+// kept at path, compressed when enabled
+func newMetadataStore(path string) store.Store {
+	var s store.Store = store.NewFileStore(path)
+	if config.C.MetadataCompress {
+		s = store.NewCompressed(s, config.C.MetadataCompressMin)
+	}
+	return s
+}
+
+// WithQuota wraps s to enforce the configured quotas, if any. The current
+// usage is computed in the background.
+func WithQuota(s store.Store) store.Store {
+	var limits []store.QuotaLimit
+	if config.C.QuotaMaxSize > 0 || config.C.QuotaMaxObjects > 0 {
+		limits = append(limits, store.QuotaLimit{
+			MaxBytes:   config.C.QuotaMaxSize,
+			MaxObjects: config.C.QuotaMaxObjects,
+		})
+	}
+	for _, p := range config.C.QuotaPrefixes {
+		limits = append(limits, store.QuotaLimit{
+			Prefix:     p.Prefix,
+			MaxBytes:   p.MaxSize,
+			MaxObjects: p.MaxObjects,
+		})
+	}
+	if len(limits) == 0 {
+		return s
+	}
+	q := store.NewQuota(s, limits...)
+	go func() {
+		if err := q.Load(context.Background()); err != nil {
+			log.Println("Could not compute quota usage:", err)
+		}
+	}()
+	return q
+}
+
+// instrument wraps s to record per operation metrics when enabled
+func instrument(s store.Store, name string) store.Store {
+	if s == nil || !config.C.MetricsStores {
+		return s
+	}
+	return store.NewInstrumented(s, name)
+}
+
+// withRetry wraps s to retry transient errors when retries are enabled
+func withRetry(s store.Store) store.Store {
+	if s == nil || config.C.RetryAttempts <= 0 {
+		return s
+	}
+	return store.NewRetry(s, config.C.RetryAttempts,
+		time.Duration(config.C.RetryBase)*time.Millisecond,
+		time.Duration(config.C.RetryMax)*time.Millisecond)
+}
+
+// originBackend returns the name of the enabled origin backend
+func originBackend() string {
+	switch {
+	case config.C.S3Enable:
+		return "s3"
+	case config.C.GCSEnable:
+		return "gcs"
+	case config.C.AzureEnable:
+		return "azure"
+	case config.C.HTTPEnable:
+		return "http"
+	case config.C.PostgresEnable:
+		return "postgres"
+	case config.C.WebDAVEnable:
+		return "webdav"
+	case config.C.SFTPEnable:
+		return "sftp"
+	case config.C.IPFSEnable:
+		return "ipfs"
+	default:
+		return "local"
+	}
+}
+
+// NewOriginBackend returns the origin store for the named backend (local,
+// s3, gcs, azure, http, postgres, webdav, sftp or ipfs) as configured
+func NewOriginBackend(name string) store.Store {
+	s := instrument(newOriginBackend(name), "origin."+name)
+	for _, ro := range config.C.OriginReadOnly {
+		if ro == name {
+			return store.NewReadOnly(s)
+		}
+	}
+	return s
+}
+
+func newOriginBackend(name string) store.Store {
+	switch name {
+	case "s3":
+		s, err := store.NewS3Store(&store.S3Config{
+			Region:             config.C.S3Region,
+			Bucket:             config.C.S3Bucket,
+			Prefix:             config.C.S3Prefix,
+			AccessKey:          config.C.S3AccessKey,
+			SecretKey:          config.C.S3SecretKey,
+			Endpoint:           config.C.S3Endpoint,
+			PathStyle:          config.C.S3PathStyle,
+			InsecureSkipVerify: config.C.S3SkipVerify,
+		})
+		if err != nil {
+			log.Fatalln("S3 store could not be initialized")
+		}
+		return s
+	case "gcs":
+		s, err := store.NewGCSStore(&store.GCSConfig{
+			Bucket:          config.C.GCSBucket,
+			Prefix:          config.C.GCSPrefix,
+			CredentialsFile: config.C.GCSCredentials,
+		})
+		if err != nil {
+			log.Fatalln("GCS store could not be initialized")
+		}
+		return s
+	case "azure":
+		s, err := store.NewAzureStore(&store.AzureConfig{
+			Account:    config.C.AzureAccount,
+			AccountKey: config.C.AzureAccountKey,
+			SASToken:   config.C.AzureSASToken,
+			Container:  config.C.AzureContainer,
+			Prefix:     config.C.AzurePrefix,
+		})
+		if err != nil {
+			log.Fatalln("Azure store could not be initialized:", err)
+		}
+		return s
+	case "http":
+		s, err := store.NewHTTPStore(&store.HTTPConfig{
+			BaseURL: config.C.HTTPBaseURL,
+			Timeout: time.Duration(config.C.HTTPTimeout) * time.Millisecond,
+			Retries: config.C.HTTPRetries,
+			MaxSize: config.C.HTTPMaxSize,
+		})
+		if err != nil {
+			log.Fatalln("HTTP store could not be initialized:", err)
+		}
+		return s
+	case "postgres":
+		s, err := store.NewPostgresStore(&store.PostgresConfig{
+			DSN:   config.C.PostgresDSN,
+			Table: config.C.PostgresTable,
+		})
+		if err != nil {
+			log.Fatalln("PostgreSQL store could not be initialized:", err)
+		}
+		return s
+	case "webdav":
+		s, err := store.NewWebDAVStore(&store.WebDAVConfig{
+			URL:      config.C.WebDAVURL,
+			Username: config.C.WebDAVUsername,
+			Password: config.C.WebDAVPassword,
+			Timeout:  time.Duration(config.C.WebDAVTimeout) * time.Millisecond,
+		})
+		if err != nil {
+			log.Fatalln("WebDAV store could not be initialized:", err)
+		}
+		return s
+	case "sftp":
+		s, err := store.NewSFTPStore(&store.SFTPConfig{
+			Addr:       config.C.SFTPAddr,
+			User:       config.C.SFTPUser,
+			KeyFile:    config.C.SFTPKeyFile,
+			Password:   config.C.SFTPPassword,
+			KnownHosts: config.C.SFTPKnownHosts,
+			Insecure:   config.C.SFTPInsecure,
+			Root:       config.C.SFTPRoot,
+			Timeout:    time.Duration(config.C.SFTPTimeout) * time.Millisecond,
+		})
+		if err != nil {
+			log.Fatalln("SFTP store could not be initialized:", err)
+		}
+		return s
+	case "ipfs":
+		s, err := store.NewIPFSStore(&store.IPFSConfig{
+			API:     config.C.IPFSAPI,
+			Timeout: time.Duration(config.C.IPFSTimeout) * time.Millisecond,
+			Index:   newMetadataStore(config.C.IPFSIndexPath),
+		})
+		if err != nil {
+			log.Fatalln("IPFS store could not be initialized:", err)
+		}
+		return s
+	case "local":
+		s := store.NewFileStore(config.C.LocalPrefix)
+		s.Sync = config.C.LocalFsync
+		s.ShardDepth = config.C.LocalShardDepth
+		return s
+	default:
+		log.Fatalln("Unknown origin backend:", name)
+		return nil
+	}
+}
+
+// NewThumbnails returns the thumbnail store: the local cache, optionally
+// fronted by a memory tier and backed by the remote thumbnail store when one
+// is configured.
+func NewThumbnails() store.Cache {
+	thumbCache := newThumbCache()
+	if config.C.MetricsStores {
+		thumbCache = store.NewInstrumented(thumbCache, "thumbs.cache")
+	}
+	if config.C.CacheThumbMemSize > 0 {
+		// hot thumbnails are served from memory
+		var mem store.Cache = store.NewMemStore(config.C.CacheThumbMemSize)
+		if config.C.MetricsStores {
+			mem = store.NewInstrumented(mem, "thumbs.memory")
+		}
+		thumbCache = store.NewTiered(mem, thumbCache)
+	}
+	if thumbStore := withRetry(instrument(newThumbStore(), "thumbs.remote")); thumbStore != nil {
+		// thumbnails are written back to the remote store, the local cache
+		// (if enabled) sits in front of it
+		return store.NewTiered(thumbCache, thumbStore)
+	}
+	return thumbCache
+}
+
+// newThumbCache returns the local thumbnail cache selected by the
+// configuration.
+func newThumbCache() store.Cache {
+	if !config.C.CacheThumbEnable {
+		return &store.NoopCache{}
+	}
+	switch config.C.CacheThumbBackend {
+	case "file":
+		c := store.NewFileCache(
+			config.C.CacheThumbPath,
+			config.C.CacheThumbMaxSize,
+			config.C.CacheThumbShards)
+		c.ShardDepth = config.C.CacheThumbShardDepth
+		return c
+	case "bolt":
+		c, err := store.NewBoltCache(
+			config.C.CacheThumbBoltPath,
+			config.C.CacheThumbMaxSize,
+			config.C.CacheThumbShards)
+		if err != nil {
+			log.Fatalln("Bolt thumbnail cache could not be opened:", err)
+		}
+		return c
+	default:
+		log.Fatalln("Unknown thumbnail cache backend:", config.C.CacheThumbBackend)
+		return nil
+	}
+}
+
+// newThumbStore returns the remote store generated thumbnails are written
+// back to, or nil when thumbnails only live in the local cache.
+func newThumbStore() store.Store {
+	switch {
+	case config.C.S3ThumbEnable:
+		s, err := store.NewS3Store(&store.S3Config{
+			Region:             config.C.S3Region,
+			Bucket:             config.C.S3ThumbBucket,
+			Prefix:             config.C.S3ThumbPrefix,
+			AccessKey:          config.C.S3AccessKey,
+			SecretKey:          config.C.S3SecretKey,
+			Endpoint:           config.C.S3Endpoint,
+			PathStyle:          config.C.S3PathStyle,
+			InsecureSkipVerify: config.C.S3SkipVerify,
+		})
+		if err != nil {
+			log.Fatalln("S3 thumbnail store could not be initialized")
+		}
+		return s
+	case config.C.GCSThumbEnable:
+		s, err := store.NewGCSStore(&store.GCSConfig{
+			Bucket:          config.C.GCSThumbBucket,
+			Prefix:          config.C.GCSThumbPrefix,
+			CredentialsFile: config.C.GCSCredentials,
+		})
+		if err != nil {
+			log.Fatalln("GCS thumbnail store could not be initialized")
+		}
+		return s
+	case config.C.AzureThumbEnable:
+		s, err := store.NewAzureStore(&store.AzureConfig{
+			Account:    config.C.AzureAccount,
+			AccountKey: config.C.AzureAccountKey,
+			SASToken:   config.C.AzureSASToken,
+			Container:  config.C.AzureThumbContainer,
+			Prefix:     config.C.AzureThumbPrefix,
+		})
+		if err != nil {
+			log.Fatalln("Azure thumbnail store could not be initialized:", err)
+		}
+		return s
+	default:
+		return nil
+	}
+}
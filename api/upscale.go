@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"github.com/kxlt/imageresizer/config"
+	"github.com/kxlt/imageresizer/imager"
+	"math"
+	"strconv"
+)
+
+// modelFormats are the formats of originals sent to the upscaler and the
+// background removal, vector ones are rendered at any size
+var modelFormats = map[imager.ImageType]bool{
+	imager.JPEG: true,
+	imager.PNG:  true,
+	imager.WEBP: true,
+}
+
+// upscale returns src upscaled when the thumbnail of options enlarges it,
+// cached like the data derived from originals, or src as is. options.Crop
+// is scaled along and the format of src kept. Cutouts are not upscaled.
+func (api *Api) upscale(ctx context.Context, path string, src []byte, options *imager.Options) ([]byte, error) {
+	if api.Upscaler == nil || options.NoEnlarge || options.RemoveBackground ||
+		!modelFormats[imager.GetImageType(src)] {
+		return src, nil
+	}
+	scale, err := imager.Enlargement(ctx, src, *options)
+	if err != nil || scale <= 1 {
+		// errors are left to the resize
+		return src, nil
+	}
+	factor := int(math.Ceil(scale))
+	if factor > config.C.UpscaleMaxFactor {
+		factor = config.C.UpscaleMaxFactor
+	}
+	if factor < 2 {
+		return src, nil
+	}
+	buf, err := api.derived(ctx, "upscale,x"+strconv.Itoa(factor), path, func(src []byte) ([]byte, error) {
+		return api.Upscaler.Upscale(ctx, src, factor)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if options.Format == imager.UNKNOWN {
+		options.Format = imager.GetImageType(src)
+	}
+	if area := options.Crop; area != nil {
+		upscaled := *area
+		upscaled.Left *= factor
+		upscaled.Top *= factor
+		upscaled.Width *= factor
+		upscaled.Height *= factor
+		options.Crop = &upscaled
+	}
+	return buf, nil
+}
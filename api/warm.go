@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/kxlt/imageresizer/config"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tierDimensions matches the dimensions of resize tiers, e.g. 300x200 or
+// x200
+var tierDimensions = regexp.MustCompile(`^[0-9]*x[0-9]*$`)
+
+// warmRequest selects the originals of a warm, Path or those under Prefix,
+// and the tiers of their thumbnails, all the known ones without Tiers
+type warmRequest struct {
+	Path   string   `json:"path"`
+	Prefix string   `json:"prefix"`
+	Tiers  []string `json:"tiers"`
+}
+
+// tierVars returns the route variables and query parameters of the
+// thumbnails of a resize tier, "WxH/op/options[,params]", or false for the
+// tiers of data derived from originals. Parameters are split at the commas
+// followed by the name of one, as values such as duotone colors hold some.
+// Tiers whose parameters were normalized into values requests don't take,
+// e.g. focal points and crops, or hashed, e.g. overlays and texts, can't be
+// rebuilt and are false too.
+func tierVars(tier string) (map[string]string, url.Values, bool) {
+	parts := strings.SplitN(tier, "/", 3)
+	if len(parts) != 3 || !tierDimensions.MatchString(parts[0]) {
+		return nil, nil, false
+	}
+	dimensions := strings.SplitN(parts[0], "x", 2)
+	options, params := parts[2], ""
+	if i := strings.Index(options, ","); i >= 0 {
+		options, params = options[:i], options[i+1:]
+	}
+	vars := map[string]string{
+		"width":    dimensions[0],
+		"height":   dimensions[1],
+		"resizeOp": parts[1],
+		"options":  options,
+	}
+	query := url.Values{}
+	if params == "" {
+		return vars, query, true
+	}
+	var key string
+	for _, param := range strings.Split(params, ",") {
+		kv := strings.SplitN(param, "=", 2)
+		if _, ok := thumbParams[kv[0]]; ok && len(kv) == 2 {
+			key = kv[0]
+			query.Set(key, kv[1])
+			continue
+		}
+		if key == "" {
+			return nil, nil, false
+		}
+		query.Set(key, query.Get(key)+","+param)
+	}
+	thumbOptions, err := parseParams(vars)
+	if err != nil {
+		return nil, nil, false
+	}
+	if normalized, err := parseQuery(query, &thumbOptions); err != nil || normalized != params {
+		return nil, nil, false
+	}
+	return vars, query, true
+}
+
+// discardWriter keeps the status of responses nobody reads
+type discardWriter struct {
+	header http.Header
+	status int
+}
+
+func (d *discardWriter) Header() http.Header {
+	return d.header
+}
+
+func (d *discardWriter) Write(buf []byte) (int, error) {
+	if d.status == 0 {
+		d.status = http.StatusOK
+	}
+	return len(buf), nil
+}
+
+func (d *discardWriter) WriteHeader(status int) {
+	d.status = status
+}
+
+// warm generates the thumbnail of the original at path in a resize tier,
+// unless it is cached already. Tiers not allowed yet, e.g. requested by
+// clients, are checked against resize.allowed and resize.maxmegapixels
+// like thumbnail requests.
+func (api *Api) warm(ctx context.Context, vars map[string]string, query url.Values, path string, allowed bool) error {
+	thumbVars := map[string]string{"path": path}
+	for k, v := range vars {
+		thumbVars[k] = v
+	}
+	if allowed {
+		// served as a preset, which skips the checks
+		thumbVars["preset"] = ""
+	}
+	thumbQuery := url.Values{}
+	for k, v := range query {
+		thumbQuery[k] = v
+	}
+	if config.C.ServerTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx,
+			time.Duration(config.C.ServerTimeout)*time.Millisecond)
+		defer cancel()
+	}
+	r, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		return err
+	}
+	w := &discardWriter{header: http.Header{}}
+	api.serveThumb(w, r.WithContext(ctx), thumbVars, thumbQuery)
+	if w.status != http.StatusOK {
+		return errors.New("thumbnail responded " + strconv.Itoa(w.status))
+	}
+	return nil
+}
+
+// handleWarms pre-generates the thumbnails of new originals in a job, so
+// they are cached before clients request them
+func (api *Api) handleWarms() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req warmRequest
+		err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&req)
+		if err != nil || (req.Path == "") == (req.Prefix == "") ||
+			(req.Path != "" && !validPath(req.Path)) {
+			respondWithErr(w, http.StatusBadRequest)
+			return
+		}
+		tiers := req.Tiers
+		if len(tiers) == 0 {
+			api.Tiers.Walk(func(tier string) {
+				tiers = append(tiers, tier)
+			})
+		}
+		j, err := api.startJob("warm", "", func(ctx context.Context, j *job) error {
+			paths := []string{req.Path}
+			if req.Prefix != "" {
+				var err error
+				if paths, err = api.Originals.List(ctx, req.Prefix); err != nil {
+					return err
+				}
+			}
+			type resizeTier struct {
+				vars  map[string]string
+				query url.Values
+			}
+			var resizeTiers []resizeTier
+			var skipped []string
+			for _, tier := range tiers {
+				if vars, query, ok := tierVars(tier); ok {
+					resizeTiers = append(resizeTiers, resizeTier{vars, query})
+				} else if len(req.Tiers) > 0 || strings.Contains(tier, "/") {
+					// requested tiers are all reported, derived ones included
+					skipped = append(skipped, tier)
+				}
+			}
+			j.skip(skipped)
+			// the known tiers were allowed when first served
+			allowed := len(req.Tiers) == 0
+			j.setTotal(len(paths) * len(resizeTiers))
+			for _, path := range paths {
+				for _, t := range resizeTiers {
+					j.progress(api.warm(ctx, t.vars, t.query, path, allowed))
+				}
+			}
+			return nil
+		})
+		respondWithJob(w, j, err)
+	}
+}
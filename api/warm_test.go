@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"github.com/kxlt/imageresizer/collections"
+	"github.com/kxlt/imageresizer/config"
+	"github.com/kxlt/imageresizer/store"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestTierVars(t *testing.T) {
+	config.C.LosslessDefault = "false"
+	config.C.QualityMin, config.C.QualityMax = 10, 95
+	tests := []struct {
+		tier  string
+		query string
+		ok    bool
+	}{
+		{"300x200/crop/smart", "", true},
+		{"x200/fit/0,format=webp,quality=75", "format=webp&quality=75", true},
+		{"300x200/crop/smart,duotone=112233,ddeeff", "duotone=112233%2Cddeeff", true},
+		{"blurhash", "", false},
+		{"upscale,x2", "", false},
+		{"300x200/crop/smart,fp=0.3x0.7", "", false},
+		{"300x200/fit/0,crop=100x50+10+20", "", false},
+		{"300x200/fit/0,canvas=400x300", "", false},
+		{"300x200/fit/0,text=9f86d081884c7d65", "", false},
+		{"300x200/fit/0,overlay=9f86d081884c7d65", "", false},
+	}
+	for _, tt := range tests {
+		vars, query, ok := tierVars(tt.tier)
+		if ok != tt.ok {
+			t.Errorf("tierVars(%q) ok = %v, want %v", tt.tier, ok, tt.ok)
+			continue
+		}
+		if ok && query.Encode() != tt.query {
+			t.Errorf("tierVars(%q) query = %q, want %q", tt.tier, query.Encode(), tt.query)
+		}
+		if ok && vars["resizeOp"] == "" {
+			t.Errorf("tierVars(%q) has no resizeOp", tt.tier)
+		}
+	}
+}
+
+func TestWarmAllowed(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("../testdata", "TestWarmAllowed")
+	if err != nil {
+		t.Fatalf("Error creating temp dir")
+	}
+	defer os.RemoveAll(tmpdir)
+	defer func(allowed []string) { config.C.ResizeAllowed = allowed }(config.C.ResizeAllowed)
+	config.C.ResizeAllowed = []string{"100x100"}
+	config.C.LosslessDefault = "false"
+	config.C.QualityMin, config.C.QualityMax = 10, 95
+	api := &Api{
+		Originals:  store.NewTiered(store.NewFileStore(tmpdir)),
+		Thumbnails: store.NewTiered(store.NewFileStore(tmpdir)),
+		Tiers:      collections.NewSyncStrSet(),
+	}
+	vars, query, _ := tierVars("300x200/crop/smart")
+
+	err = api.warm(context.Background(), vars, query, "missing.jpg", false)
+	if err == nil || err.Error() != "thumbnail responded 400" {
+		t.Errorf("Requested tiers outside resize.allowed should be refused, got %v", err)
+	}
+	err = api.warm(context.Background(), vars, query, "missing.jpg", true)
+	if err == nil || err.Error() != "thumbnail responded 404" {
+		t.Errorf("Known tiers should skip resize.allowed, got %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"github.com/kxlt/imageresizer/config"
+	"github.com/kxlt/imageresizer/imager"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// loadWatermarks reads the configured watermarks, keyed by the namespace
+// (first path segment) they apply to, "" being the default
+func loadWatermarks() map[string]*imager.Watermark {
+	paths := map[string]string{}
+	for ns, p := range config.C.WatermarkTenants {
+		paths[ns] = p
+	}
+	if config.C.WatermarkPath != "" {
+		paths[""] = config.C.WatermarkPath
+	}
+	position, ok := imager.Positions[config.C.WatermarkPosition]
+	if !ok {
+		log.Fatalln("Invalid watermark position", config.C.WatermarkPosition)
+	}
+	watermarks := map[string]*imager.Watermark{}
+	for ns, p := range paths {
+		buf, err := ioutil.ReadFile(p)
+		if err != nil {
+			log.Fatalln("Watermark could not be loaded:", err)
+		}
+		watermarks[ns] = &imager.Watermark{
+			Image:    buf,
+			Position: position,
+			Opacity:  config.C.WatermarkOpacity,
+			Scale:    config.C.WatermarkScale,
+			Margin:   config.C.WatermarkMargin,
+			MinSize:  config.C.WatermarkMinSize,
+		}
+	}
+	return watermarks
+}
+
+// watermark returns the watermark of the namespace of filename, or the
+// default one
+func (api *Api) watermark(filename string) *imager.Watermark {
+	if i := strings.Index(filename, "/"); i > 0 {
+		if wm, ok := api.Watermarks[filename[:i]]; ok {
+			return wm
+		}
+	}
+	return api.Watermarks[""]
+}
@@ -87,6 +87,20 @@ func (sm *SyncMap) HasKey(key string) bool {
 	return ok
 }
 
+// Walk calls walkFn for each element of a snapshot of the map, which may
+// be modified meanwhile
+func (sm *SyncMap) Walk(walkFn func(key string, val interface{})) {
+	sm.RLock()
+	items := make([]item, len(sm.indexed))
+	for i, elem := range sm.indexed {
+		items[i] = *elem
+	}
+	sm.RUnlock()
+	for _, elem := range items {
+		walkFn(elem.key, elem.val)
+	}
+}
+
 func (sm *SyncMap) GetRand() interface{} {
 	sm.RLock()
 	defer sm.RUnlock()
@@ -75,3 +75,24 @@ func TestSyncMap_GetRand(t *testing.T) {
 		}
 	}
 }
+
+func TestSyncMap_Walk(t *testing.T) {
+	sm := NewSyncMap()
+	sm.Put("a", 1)
+	sm.Put("b", 2)
+	sm.Put("c", 3)
+	sm.Remove("b")
+
+	walked := map[string]interface{}{}
+	sm.Walk(func(key string, val interface{}) {
+		// the map may be modified while walking
+		sm.Remove(key)
+		walked[key] = val
+	})
+	if len(walked) != 2 || walked["a"] != 1 || walked["c"] != 3 {
+		t.Errorf("Walk visited %v", walked)
+	}
+	if sm.Size() != 0 {
+		t.Errorf("Elements removed while walking are still there")
+	}
+}
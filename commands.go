@@ -0,0 +1,318 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/kxlt/imageresizer/api"
+	"github.com/kxlt/imageresizer/config"
+	"github.com/kxlt/imageresizer/store"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runCommand runs the subcommand named by args[0] and returns the exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "migrate":
+		return migrate(args[1:])
+	case "export":
+		return export(args[1:])
+	case "import":
+		return restore(args[1:])
+	case "sign":
+		return sign(args[1:])
+	case "warm":
+		return warm(args[1:])
+	default:
+		log.Println("Unknown command:", args[0])
+		return 2
+	}
+}
+
+// interruptible returns a context cancelled on SIGINT or SIGTERM
+func interruptible() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		log.Println("Interrupted, stopping...")
+		cancel()
+	}()
+	return ctx
+}
+
+// migrate copies the originals from one configured backend to another.
+// Files already copied are skipped, so it can be run again to resume.
+func migrate(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := flags.String("from", "local", "source backend, named as in origin.fallback")
+	to := flags.String("to", "", "destination backend")
+	prefix := flags.String("prefix", "", "only migrate files starting with prefix")
+	workers := flags.Int("workers", 8, "number of concurrent copies")
+	flags.Parse(args)
+	if *to == "" || *to == *from {
+		log.Println("migrate: -to must name a backend other than -from")
+		return 2
+	}
+
+	src := api.NewOriginBackend(*from)
+	dst := api.NewOriginBackend(*to)
+	log.Printf("Migrating %s to %s...", *from, *to)
+	last := time.Now()
+	stats, err := store.Migrate(interruptible(), src, dst, *prefix, *workers,
+		func(s store.MigrateStats) {
+			if time.Since(last) > time.Second {
+				last = time.Now()
+				logMigrateStats(s)
+			}
+		})
+	logMigrateStats(stats)
+	if err != nil {
+		log.Println("Migration failed:", err)
+		return 1
+	}
+	if stats.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func logMigrateStats(s store.MigrateStats) {
+	log.Printf("%d/%d files: %d copied (%d bytes), %d skipped, %d failed",
+		s.Copied+s.Skipped+s.Failed, s.Total, s.Copied, s.Bytes, s.Skipped, s.Failed)
+}
+
+// originals returns the configured store of originals, without the local
+// cache
+func originals() store.Store {
+	s := api.NewOriginStore()
+	if config.C.OriginDedup {
+		s = store.NewDedup(s)
+	}
+	return api.WithQuota(s)
+}
+
+// export writes the originals, and optionally the thumbnails, to a tar
+// archive, gzipped when its name ends with .gz
+func export(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("o", "-", "archive path, - for stdout")
+	prefix := flags.String("prefix", "", "only export originals starting with prefix")
+	thumbs := flags.Bool("thumbnails", false, "export thumbnails too")
+	flags.Parse(args)
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Println("export:", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	if strings.HasSuffix(*output, ".gz") {
+		gw := gzip.NewWriter(w)
+		defer gw.Close()
+		w = gw
+	}
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	ctx := interruptible()
+	n, err := store.Export(ctx, originals(), *prefix, tw, "originals")
+	log.Printf("Exported %d originals", n)
+	if err == nil && *thumbs {
+		n, err = store.Export(ctx, api.NewThumbnails(), "", tw, "thumbnails")
+		log.Printf("Exported %d thumbnails", n)
+	}
+	if err != nil {
+		log.Println("Export failed:", err)
+		return 1
+	}
+	return 0
+}
+
+// restore imports an archive written by export
+func restore(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	input := flags.String("i", "-", "archive path, - for stdin")
+	thumbs := flags.Bool("thumbnails", false, "import thumbnails too")
+	flags.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			log.Println("import:", err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+	if strings.HasSuffix(*input, ".gz") {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			log.Println("import:", err)
+			return 1
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	stores := map[string]store.Store{"originals": originals()}
+	if *thumbs {
+		stores["thumbnails"] = api.NewThumbnails()
+	}
+	n, err := store.Import(interruptible(), tar.NewReader(r), stores)
+	log.Printf("Imported %d files", n)
+	if err != nil {
+		log.Println("Import failed:", err)
+		return 1
+	}
+	return 0
+}
+
+// sign prints the URLs given as arguments signed with sign.key
+func sign(args []string) int {
+	flags := flag.NewFlagSet("sign", flag.ExitOnError)
+	expiresIn := flags.Duration("expires", 0, "validity of the signed URLs, 0 for no expiry")
+	flags.Parse(args)
+	if config.C.SignKey == "" {
+		log.Println("sign: sign.key is not set")
+		return 2
+	}
+	var expires time.Time
+	if *expiresIn > 0 {
+		expires = time.Now().Add(*expiresIn)
+	}
+	for _, rawURL := range flags.Args() {
+		signed, err := api.SignURL(config.C.SignKey, rawURL, expires)
+		if err != nil {
+			log.Println("sign:", err)
+			return 1
+		}
+		fmt.Println(signed)
+	}
+	return 0
+}
+
+// warm has the running server pre-generate the thumbnails of the originals
+// given as arguments, or under -prefix, in the tiers it knows
+func warm(args []string) int {
+	flags := flag.NewFlagSet("warm", flag.ExitOnError)
+	server := flags.String("server", "", "server URL, defaults to server.addr on localhost")
+	prefix := flags.String("prefix", "", "warm the originals starting with prefix")
+	tiers := flags.String("tiers", "", "space separated tiers, which may hold commas, defaults to all known ones")
+	wait := flags.Bool("wait", false, "wait for the jobs to finish")
+	flags.Parse(args)
+	if config.C.AdminToken == "" {
+		log.Println("warm: admin.token is not set")
+		return 2
+	}
+	if (flags.NArg() == 0) == (*prefix == "") {
+		log.Println("warm: give either paths or -prefix")
+		return 2
+	}
+	if *server == "" {
+		*server = config.C.ServerAddr
+		if strings.HasPrefix(*server, ":") {
+			*server = "localhost" + *server
+		}
+		*server = "http://" + *server
+	}
+	var reqs []map[string]interface{}
+	for _, path := range flags.Args() {
+		reqs = append(reqs, map[string]interface{}{"path": strings.TrimPrefix(path, "/")})
+	}
+	if *prefix != "" {
+		reqs = append(reqs, map[string]interface{}{"prefix": *prefix})
+	}
+	status := 0
+	for _, req := range reqs {
+		if *tiers != "" {
+			req["tiers"] = strings.Fields(*tiers)
+		}
+		var started struct {
+			Job string `json:"job"`
+		}
+		if err := adminCall(http.MethodPost, *server+"/api/warm", req, &started); err != nil {
+			log.Println("warm:", err)
+			return 1
+		}
+		fmt.Println(started.Job)
+		if !*wait {
+			continue
+		}
+		for {
+			var j struct {
+				Status  string   `json:"status"`
+				Total   int      `json:"total"`
+				Done    int      `json:"done"`
+				Failed  int      `json:"failed"`
+				Error   string   `json:"error"`
+				Skipped []string `json:"skipped"`
+			}
+			if err := adminCall(http.MethodGet, *server+"/api/jobs/"+started.Job, nil, &j); err != nil {
+				log.Println("warm:", err)
+				return 1
+			}
+			if j.Status == "queued" || j.Status == "running" {
+				time.Sleep(time.Second)
+				continue
+			}
+			log.Printf("Job %s %s: %d/%d thumbnails, %d failed",
+				started.Job, j.Status, j.Done+j.Failed, j.Total, j.Failed)
+			if j.Error != "" {
+				log.Println("warm:", j.Error)
+			}
+			if len(j.Skipped) > 0 {
+				log.Println("warm: skipped tiers", strings.Join(j.Skipped, " "))
+			}
+			if j.Status != "done" || j.Failed > 0 {
+				status = 1
+			}
+			break
+		}
+	}
+	return status
+}
+
+// adminCall requests an admin endpoint of the server with admin.token,
+// sending body and decoding the response into v as JSON
+func adminCall(method, url string, body interface{}, v interface{}) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, url, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+config.C.AdminToken)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.New(url + " responded " + resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
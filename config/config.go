@@ -3,37 +3,204 @@ package config
 import (
 	"github.com/spf13/viper"
 	"log"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 )
 
 type Config struct {
-	ServerAddr string
+	ServerAddr     string
+	ServerTimeout  int
+	ServerReadOnly bool
+	SignKey        string
+	AdminToken     string
 
-	LocalPrefix string
+	OriginFallback          []string
+	OriginReadOnly          []string
+	OriginReplica           string
+	OriginReplicaAsync      bool
+	OriginReplicaRetries    int
+	OriginReplicaQueue      int
+	OriginReplicaTombstones string
+	OriginReplicaSweep      int
+	OriginDedup             bool
+	OriginRoutes            map[string]string
 
-	S3Enable bool
-	S3Region string
-	S3Bucket string
-	S3Prefix string
+	QuotaMaxSize    int64
+	QuotaMaxObjects int64
+	QuotaPrefixes   []QuotaPrefix
+
+	RetryAttempts int
+	RetryBase     int
+	RetryMax      int
+
+	LocalPrefix     string
+	LocalFsync      bool
+	LocalShardDepth int
+
+	S3Enable      bool
+	S3Region      string
+	S3Bucket      string
+	S3Prefix      string
+	S3AccessKey   string
+	S3SecretKey   string
+	S3Endpoint    string
+	S3PathStyle   bool
+	S3SkipVerify  bool
+	S3ThumbEnable bool
+	S3ThumbBucket string
+	S3ThumbPrefix string
+
+	GCSEnable      bool
+	GCSBucket      string
+	GCSPrefix      string
+	GCSCredentials string
+	GCSThumbEnable bool
+	GCSThumbBucket string
+	GCSThumbPrefix string
+
+	AzureEnable         bool
+	AzureAccount        string
+	AzureAccountKey     string
+	AzureSASToken       string
+	AzureContainer      string
+	AzurePrefix         string
+	AzureThumbEnable    bool
+	AzureThumbContainer string
+	AzureThumbPrefix    string
+
+	HTTPEnable  bool
+	HTTPBaseURL string
+	HTTPTimeout int
+	HTTPRetries int
+	HTTPMaxSize int64
+
+	PostgresEnable bool
+	PostgresDSN    string
+	PostgresTable  string
+
+	WebDAVEnable   bool
+	WebDAVURL      string
+	WebDAVUsername string
+	WebDAVPassword string
+	WebDAVTimeout  int
+
+	SFTPEnable     bool
+	SFTPAddr       string
+	SFTPUser       string
+	SFTPKeyFile    string
+	SFTPPassword   string
+	SFTPKnownHosts string
+	SFTPInsecure   bool
+	SFTPRoot       string
+	SFTPTimeout    int
+
+	IPFSEnable    bool
+	IPFSAPI       string
+	IPFSIndexPath string
+	IPFSTimeout   int
 
 	CacheOrigEnable      bool
 	CacheOrigPath        string
 	CacheOrigMaxSize     int64
 	CacheOrigShards      int
+	CacheOrigShardDepth  int
 	CacheThumbEnable     bool
+	CacheThumbBackend    string
 	CacheThumbPath       string
+	CacheThumbBoltPath   string
 	CacheThumbMaxSize    int64
 	CacheThumbShards     int
+	CacheThumbShardDepth int
+	CacheThumbMemSize    int64
 	CacheLoaderFiles     int
 	CacheLoaderSleep     int
 	CacheLoaderThreshold int
 
-	UploadMaxSize int64
+	UploadMaxSize      int64
+	UploadOverwrite    bool
+	UploadFetchEnable  bool
+	UploadFetchTimeout int
 
 	EtagCacheEnable  bool
 	EtagCacheMaxSize int
+
+	FormatNegotiate    []string
+	FormatProgressive  bool
+	FormatKeepMetadata bool
+	FormatICC          string
+	PadBackground      string
+	SharpenDefault     string
+	EnlargeDefault     bool
+	LosslessDefault    string
+	GravityDefault     string
+	ResizeKernel       string
+	FaceCascade        string
+	TextFont           string
+	WatermarkPath      string
+	WatermarkTenants   map[string]string
+	WatermarkPosition  string
+	WatermarkOpacity   float64
+	WatermarkScale     float64
+	WatermarkMargin    int
+	WatermarkMinSize   int
+	QualityDefault     int
+	QualityMin         int
+	QualityMax         int
+	AVIFQuality        int
+	AVIFEffort         int
+	WebPEffort         int
+	PNGEffort          int
+
+	AnimationMaxFrames   int
+	AnimationMaxDuration int
+	VideoFFmpeg          string
+
+	UpscaleURL       string
+	UpscaleTimeout   int
+	UpscaleMaxSize   int64
+	UpscaleMaxFactor int
+
+	CutoutURL     string
+	CutoutTimeout int
+	CutoutMaxSize int64
+
+	SVGMaxSize     int64
+	SVGMaxElements int
+
+	SourceMaxMegapixels float64
+	SourceMaxFrames     int
+
+	Presets     map[string]string
+	PresetsOnly bool
+
+	PHashEnable bool
+
+	ResizeAllowed       []string
+	ResizeMaxMegapixels float64
+
+	MetricsStores bool
+
+	MetadataCompress    bool
+	MetadataCompressMin int
+
+	GCEnable   bool
+	GCInterval int
+	GCDryRun   bool
+
+	JobsWorkers   int
+	JobsQueue     int
+	JobsAsyncSize int64
+}
+
+// QuotaPrefix limits the size and number of originals stored under Prefix
+type QuotaPrefix struct {
+	Prefix     string
+	MaxSize    int64
+	MaxObjects int64
 }
 
 var C Config
@@ -44,43 +211,254 @@ func init() {
 	viper.AutomaticEnv()
 
 	viper.SetDefault("server.addr", ":8080")
+	viper.SetDefault("server.timeout", 30000)
+	viper.SetDefault("server.readonly", false)
+	viper.SetDefault("sign.key", "")
+	viper.SetDefault("admin.token", "")
+	viper.SetDefault("origin.fallback", "")
+	viper.SetDefault("origin.routes", "")
+	viper.SetDefault("origin.readonly", "")
+	viper.SetDefault("origin.replica.backend", "")
+	viper.SetDefault("origin.replica.async", true)
+	viper.SetDefault("origin.replica.retries", 3)
+	viper.SetDefault("origin.replica.queue", 10000)
+	viper.SetDefault("origin.replica.tombstones", "./images/tombstones")
+	viper.SetDefault("origin.replica.sweep", 60000)
+	viper.SetDefault("origin.dedup", false)
+	viper.SetDefault("quota.maxsize", "0")
+	viper.SetDefault("quota.maxobjects", 0)
+	viper.SetDefault("quota.prefixes", "")
+	viper.SetDefault("retry.attempts", 0)
+	viper.SetDefault("retry.base", 100)
+	viper.SetDefault("retry.max", 2000)
 	viper.SetDefault("local.prefix", "./images/originals")
+	viper.SetDefault("local.fsync", false)
+	viper.SetDefault("local.sharddepth", 0)
 	viper.SetDefault("s3.enable", false)
 	viper.SetDefault("s3.prefix", "")
+	viper.SetDefault("s3.pathstyle", false)
+	viper.SetDefault("s3.skipverify", false)
+	viper.SetDefault("s3.thumb.enable", false)
+	viper.SetDefault("s3.thumb.prefix", "")
+	viper.SetDefault("gcs.enable", false)
+	viper.SetDefault("gcs.prefix", "")
+	viper.SetDefault("gcs.thumb.enable", false)
+	viper.SetDefault("gcs.thumb.prefix", "")
+	viper.SetDefault("azure.enable", false)
+	viper.SetDefault("azure.prefix", "")
+	viper.SetDefault("azure.thumb.enable", false)
+	viper.SetDefault("azure.thumb.prefix", "")
+	viper.SetDefault("http.enable", false)
+	viper.SetDefault("http.timeout", 10000)
+	viper.SetDefault("http.retries", 2)
+	viper.SetDefault("http.maxsize", "50M")
+	viper.SetDefault("postgres.enable", false)
+	viper.SetDefault("postgres.table", "images")
+	viper.SetDefault("webdav.enable", false)
+	viper.SetDefault("webdav.timeout", 30000)
+	viper.SetDefault("sftp.enable", false)
+	viper.SetDefault("sftp.knownhosts", "~/.ssh/known_hosts")
+	viper.SetDefault("sftp.insecure", false)
+	viper.SetDefault("sftp.root", ".")
+	viper.SetDefault("sftp.timeout", 10000)
+	viper.SetDefault("ipfs.enable", false)
+	viper.SetDefault("ipfs.api", "http://127.0.0.1:5001")
+	viper.SetDefault("ipfs.indexpath", "./images/ipfs-index")
+	viper.SetDefault("ipfs.timeout", 30000)
 	viper.SetDefault("cache.orig.enable", true)
 	viper.SetDefault("cache.orig.path", "./images/cache")
 	viper.SetDefault("cache.orig.maxsize", "1G")
 	viper.SetDefault("cache.orig.shards", 256)
+	viper.SetDefault("cache.orig.sharddepth", 0)
 	viper.SetDefault("cache.thumb.enable", true)
+	viper.SetDefault("cache.thumb.backend", "file")
 	viper.SetDefault("cache.thumb.path", "./images/thumbnails")
+	viper.SetDefault("cache.thumb.boltpath", "./images/thumbnails.db")
 	viper.SetDefault("cache.thumb.maxsize", "1G")
 	viper.SetDefault("cache.thumb.shards", 256)
+	viper.SetDefault("cache.thumb.sharddepth", 0)
+	viper.SetDefault("cache.thumb.memsize", "0")
 	viper.SetDefault("cache.loader.files", 100)
 	viper.SetDefault("cache.loader.sleep", 50)
 	viper.SetDefault("cache.loader.threshold", 200)
 	viper.SetDefault("upload.maxsize", "50M")
+	viper.SetDefault("upload.overwrite", true)
+	viper.SetDefault("upload.fetch.enable", false)
+	viper.SetDefault("upload.fetch.timeout", 30000)
+	viper.SetDefault("format.negotiate", "webp")
+	viper.SetDefault("format.progressive", false)
+	viper.SetDefault("format.keepmetadata", false)
+	viper.SetDefault("format.icc", "srgb")
+	viper.SetDefault("pad.background", "ffffff")
+	viper.SetDefault("sharpen.default", "")
+	viper.SetDefault("enlarge.default", true)
+	viper.SetDefault("lossless.default", "false")
+	viper.SetDefault("gravity.default", "smart")
+	viper.SetDefault("resize.kernel", "lanczos3")
+	viper.SetDefault("face.cascade", "")
+	viper.SetDefault("animation.maxframes", 200)
+	viper.SetDefault("animation.maxduration", 60000)
+	viper.SetDefault("video.ffmpeg", "")
+	viper.SetDefault("upscale.url", "")
+	viper.SetDefault("upscale.timeout", 60000)
+	viper.SetDefault("upscale.maxsize", "100M")
+	viper.SetDefault("upscale.maxfactor", 4)
+	viper.SetDefault("cutout.url", "")
+	viper.SetDefault("cutout.timeout", 60000)
+	viper.SetDefault("cutout.maxsize", "100M")
+	viper.SetDefault("svg.maxsize", "1M")
+	viper.SetDefault("svg.maxelements", 10000)
+	viper.SetDefault("source.maxmegapixels", 100)
+	viper.SetDefault("source.maxframes", 1000)
+	viper.SetDefault("presets.list", "")
+	viper.SetDefault("presets.only", false)
+	viper.SetDefault("phash.enable", false)
+	viper.SetDefault("resize.allowed", "")
+	viper.SetDefault("resize.maxmegapixels", 0)
+	viper.SetDefault("text.font", "sans")
+	viper.SetDefault("watermark.path", "")
+	viper.SetDefault("watermark.tenants", "")
+	viper.SetDefault("watermark.position", "se")
+	viper.SetDefault("watermark.opacity", 0.5)
+	viper.SetDefault("watermark.scale", 0.2)
+	viper.SetDefault("watermark.margin", 10)
+	viper.SetDefault("watermark.minsize", 200)
+	viper.SetDefault("quality.default", 80)
+	viper.SetDefault("quality.min", 10)
+	viper.SetDefault("quality.max", 95)
+	viper.SetDefault("avif.quality", 50)
+	viper.SetDefault("avif.effort", 4)
+	viper.SetDefault("webp.effort", 4)
+	viper.SetDefault("png.effort", 6)
 	viper.SetDefault("etag.cache.enable", true)
 	viper.SetDefault("etag.cache.maxsize", 50000)
+	viper.SetDefault("metrics.stores", true)
+	viper.SetDefault("metadata.compress", false)
+	viper.SetDefault("metadata.compress.minsize", 0)
+	viper.SetDefault("gc.enable", false)
+	viper.SetDefault("gc.interval", 3600000)
+	viper.SetDefault("gc.dryrun", false)
+	viper.SetDefault("jobs.workers", 4)
+	viper.SetDefault("jobs.queue", 100)
+	viper.SetDefault("jobs.asyncsize", "0")
 }
 
 func RefreshConfig() {
 	C.ServerAddr = viper.GetString("server.addr")
+	C.ServerTimeout = viper.GetInt("server.timeout")
+	C.SignKey = viper.GetString("sign.key")
+	C.AdminToken = viper.GetString("admin.token")
+	C.ServerReadOnly = viper.GetBool("server.readonly")
+	C.OriginFallback = splitList(viper.GetString("origin.fallback"))
+	C.OriginRoutes = parseRoutes(viper.GetString("origin.routes"))
+	C.OriginReadOnly = splitList(viper.GetString("origin.readonly"))
+	C.OriginReplica = viper.GetString("origin.replica.backend")
+	C.OriginReplicaAsync = viper.GetBool("origin.replica.async")
+	C.OriginReplicaRetries = viper.GetInt("origin.replica.retries")
+	C.OriginReplicaQueue = viper.GetInt("origin.replica.queue")
+	C.OriginReplicaTombstones = viper.GetString("origin.replica.tombstones")
+	C.OriginReplicaSweep = viper.GetInt("origin.replica.sweep")
+	C.OriginDedup = viper.GetBool("origin.dedup")
+	C.QuotaMaxSize = parseSize(viper.GetString("quota.maxsize"))
+	C.QuotaMaxObjects = viper.GetInt64("quota.maxobjects")
+	C.QuotaPrefixes = parseQuotaPrefixes(viper.GetString("quota.prefixes"))
+	C.RetryAttempts = viper.GetInt("retry.attempts")
+	C.RetryBase = viper.GetInt("retry.base")
+	C.RetryMax = viper.GetInt("retry.max")
 	C.LocalPrefix = viper.GetString("local.prefix")
+	C.LocalFsync = viper.GetBool("local.fsync")
+	C.LocalShardDepth = viper.GetInt("local.sharddepth")
 	C.S3Enable = viper.GetBool("s3.enable")
 	C.S3Region = viper.GetString("s3.region")
 	C.S3Bucket = viper.GetString("s3.bucket")
 	C.S3Prefix = viper.GetString("s3.prefix")
+	C.S3AccessKey = viper.GetString("s3.accesskey")
+	C.S3SecretKey = viper.GetString("s3.secretkey")
+	C.S3Endpoint = viper.GetString("s3.endpoint")
+	C.S3PathStyle = viper.GetBool("s3.pathstyle")
+	C.S3SkipVerify = viper.GetBool("s3.skipverify")
+	C.S3ThumbEnable = viper.GetBool("s3.thumb.enable")
+	C.S3ThumbBucket = viper.GetString("s3.thumb.bucket")
+	if C.S3ThumbBucket == "" {
+		C.S3ThumbBucket = C.S3Bucket
+	}
+	C.S3ThumbPrefix = viper.GetString("s3.thumb.prefix")
+	if C.S3ThumbEnable && C.S3ThumbBucket == C.S3Bucket &&
+		overlappingPrefixes(C.S3Prefix, C.S3ThumbPrefix) {
+		log.Fatalln("s3.thumb.prefix must not overlap s3.prefix in the same bucket")
+	}
+	C.GCSEnable = viper.GetBool("gcs.enable")
+	C.GCSBucket = viper.GetString("gcs.bucket")
+	C.GCSPrefix = viper.GetString("gcs.prefix")
+	C.GCSCredentials = viper.GetString("gcs.credentials")
+	C.GCSThumbEnable = viper.GetBool("gcs.thumb.enable")
+	C.GCSThumbBucket = viper.GetString("gcs.thumb.bucket")
+	if C.GCSThumbBucket == "" {
+		C.GCSThumbBucket = C.GCSBucket
+	}
+	C.GCSThumbPrefix = viper.GetString("gcs.thumb.prefix")
+	if C.GCSThumbEnable && C.GCSThumbBucket == C.GCSBucket &&
+		overlappingPrefixes(C.GCSPrefix, C.GCSThumbPrefix) {
+		log.Fatalln("gcs.thumb.prefix must not overlap gcs.prefix in the same bucket")
+	}
+	C.AzureEnable = viper.GetBool("azure.enable")
+	C.AzureAccount = viper.GetString("azure.account")
+	C.AzureAccountKey = viper.GetString("azure.accountkey")
+	C.AzureSASToken = viper.GetString("azure.sastoken")
+	C.AzureContainer = viper.GetString("azure.container")
+	C.AzurePrefix = viper.GetString("azure.prefix")
+	C.AzureThumbEnable = viper.GetBool("azure.thumb.enable")
+	C.AzureThumbContainer = viper.GetString("azure.thumb.container")
+	if C.AzureThumbContainer == "" {
+		C.AzureThumbContainer = C.AzureContainer
+	}
+	C.AzureThumbPrefix = viper.GetString("azure.thumb.prefix")
+	if C.AzureThumbEnable && C.AzureThumbContainer == C.AzureContainer &&
+		overlappingPrefixes(C.AzurePrefix, C.AzureThumbPrefix) {
+		log.Fatalln("azure.thumb.prefix must not overlap azure.prefix in the same container")
+	}
+	C.HTTPEnable = viper.GetBool("http.enable")
+	C.HTTPBaseURL = viper.GetString("http.baseurl")
+	C.HTTPTimeout = viper.GetInt("http.timeout")
+	C.HTTPRetries = viper.GetInt("http.retries")
+	C.HTTPMaxSize = parseSize(viper.GetString("http.maxsize"))
+	C.PostgresEnable = viper.GetBool("postgres.enable")
+	C.PostgresDSN = viper.GetString("postgres.dsn")
+	C.PostgresTable = viper.GetString("postgres.table")
+	C.WebDAVEnable = viper.GetBool("webdav.enable")
+	C.WebDAVURL = viper.GetString("webdav.url")
+	C.WebDAVUsername = viper.GetString("webdav.username")
+	C.WebDAVPassword = viper.GetString("webdav.password")
+	C.WebDAVTimeout = viper.GetInt("webdav.timeout")
+	C.SFTPEnable = viper.GetBool("sftp.enable")
+	C.SFTPAddr = viper.GetString("sftp.addr")
+	C.SFTPUser = viper.GetString("sftp.user")
+	C.SFTPKeyFile = expandHome(viper.GetString("sftp.keyfile"))
+	C.SFTPPassword = viper.GetString("sftp.password")
+	C.SFTPKnownHosts = expandHome(viper.GetString("sftp.knownhosts"))
+	C.SFTPInsecure = viper.GetBool("sftp.insecure")
+	C.SFTPRoot = viper.GetString("sftp.root")
+	C.SFTPTimeout = viper.GetInt("sftp.timeout")
+	C.IPFSEnable = viper.GetBool("ipfs.enable")
+	C.IPFSAPI = viper.GetString("ipfs.api")
+	C.IPFSIndexPath = viper.GetString("ipfs.indexpath")
+	C.IPFSTimeout = viper.GetInt("ipfs.timeout")
 	C.CacheOrigEnable = viper.GetBool("cache.orig.enable")
 	C.CacheOrigPath = viper.GetString("cache.orig.path")
 	C.CacheOrigMaxSize = parseSize(viper.GetString("cache.orig.maxsize"))
 	C.CacheOrigShards = viper.GetInt("cache.orig.shards")
+	C.CacheOrigShardDepth = viper.GetInt("cache.orig.sharddepth")
 	if C.CacheOrigShards < 1 {
 		log.Fatalln("Minimum 1 shard required")
 	}
 	C.CacheThumbEnable = viper.GetBool("cache.thumb.enable")
+	C.CacheThumbBackend = viper.GetString("cache.thumb.backend")
 	C.CacheThumbPath = viper.GetString("cache.thumb.path")
+	C.CacheThumbBoltPath = viper.GetString("cache.thumb.boltpath")
 	C.CacheThumbMaxSize = parseSize(viper.GetString("cache.thumb.maxsize"))
 	C.CacheThumbShards = viper.GetInt("cache.thumb.shards")
+	C.CacheThumbShardDepth = viper.GetInt("cache.thumb.sharddepth")
+	C.CacheThumbMemSize = parseSize(viper.GetString("cache.thumb.memsize"))
 	if C.CacheThumbShards < 1 {
 		log.Fatalln("Minimum 1 shard required")
 	}
@@ -88,13 +466,175 @@ func RefreshConfig() {
 	C.CacheLoaderSleep = viper.GetInt("cache.loader.sleep")
 	C.CacheLoaderThreshold = viper.GetInt("cache.loader.threshold")
 	C.UploadMaxSize = parseSize(viper.GetString("upload.maxsize"))
+	C.UploadOverwrite = viper.GetBool("upload.overwrite")
+	C.UploadFetchEnable = viper.GetBool("upload.fetch.enable")
+	C.UploadFetchTimeout = viper.GetInt("upload.fetch.timeout")
+	C.FormatNegotiate = splitList(viper.GetString("format.negotiate"))
+	C.FormatProgressive = viper.GetBool("format.progressive")
+	C.FormatKeepMetadata = viper.GetBool("format.keepmetadata")
+	C.FormatICC = viper.GetString("format.icc")
+	C.PadBackground = viper.GetString("pad.background")
+	C.SharpenDefault = viper.GetString("sharpen.default")
+	C.EnlargeDefault = viper.GetBool("enlarge.default")
+	C.LosslessDefault = viper.GetString("lossless.default")
+	C.GravityDefault = viper.GetString("gravity.default")
+	C.ResizeKernel = viper.GetString("resize.kernel")
+	C.FaceCascade = viper.GetString("face.cascade")
+	C.AnimationMaxFrames = viper.GetInt("animation.maxframes")
+	C.AnimationMaxDuration = viper.GetInt("animation.maxduration")
+	C.VideoFFmpeg = viper.GetString("video.ffmpeg")
+	C.UpscaleURL = viper.GetString("upscale.url")
+	C.UpscaleTimeout = viper.GetInt("upscale.timeout")
+	C.UpscaleMaxSize = parseSize(viper.GetString("upscale.maxsize"))
+	C.UpscaleMaxFactor = viper.GetInt("upscale.maxfactor")
+	C.CutoutURL = viper.GetString("cutout.url")
+	C.CutoutTimeout = viper.GetInt("cutout.timeout")
+	C.CutoutMaxSize = parseSize(viper.GetString("cutout.maxsize"))
+	C.SVGMaxSize = parseSize(viper.GetString("svg.maxsize"))
+	C.SVGMaxElements = viper.GetInt("svg.maxelements")
+	C.SourceMaxMegapixels = viper.GetFloat64("source.maxmegapixels")
+	C.SourceMaxFrames = viper.GetInt("source.maxframes")
+	C.Presets = parsePresets(viper.GetString("presets.list"))
+	C.PresetsOnly = viper.GetBool("presets.only")
+	C.PHashEnable = viper.GetBool("phash.enable")
+	C.ResizeAllowed = splitList(viper.GetString("resize.allowed"))
+	C.ResizeMaxMegapixels = viper.GetFloat64("resize.maxmegapixels")
+	C.TextFont = viper.GetString("text.font")
+	C.WatermarkPath = viper.GetString("watermark.path")
+	C.WatermarkTenants = parseRoutes(viper.GetString("watermark.tenants"))
+	C.WatermarkPosition = viper.GetString("watermark.position")
+	C.WatermarkOpacity = viper.GetFloat64("watermark.opacity")
+	C.WatermarkScale = viper.GetFloat64("watermark.scale")
+	C.WatermarkMargin = viper.GetInt("watermark.margin")
+	C.WatermarkMinSize = viper.GetInt("watermark.minsize")
+	C.QualityDefault = viper.GetInt("quality.default")
+	C.QualityMin = viper.GetInt("quality.min")
+	C.QualityMax = viper.GetInt("quality.max")
+	C.AVIFQuality = viper.GetInt("avif.quality")
+	C.AVIFEffort = viper.GetInt("avif.effort")
+	C.WebPEffort = viper.GetInt("webp.effort")
+	C.PNGEffort = viper.GetInt("png.effort")
 	C.EtagCacheEnable = viper.GetBool("etag.cache.enable")
 	C.EtagCacheMaxSize = viper.GetInt("etag.cache.maxsize")
+	C.MetricsStores = viper.GetBool("metrics.stores")
+	C.MetadataCompress = viper.GetBool("metadata.compress")
+	C.MetadataCompressMin = viper.GetInt("metadata.compress.minsize")
+	C.GCEnable = viper.GetBool("gc.enable")
+	C.GCInterval = viper.GetInt("gc.interval")
+	if C.GCEnable && C.GCInterval <= 0 {
+		log.Fatalln("gc.interval must be positive")
+	}
+	C.GCDryRun = viper.GetBool("gc.dryrun")
+	C.JobsWorkers = viper.GetInt("jobs.workers")
+	if C.JobsWorkers <= 0 {
+		log.Fatalln("jobs.workers must be positive")
+	}
+	C.JobsQueue = viper.GetInt("jobs.queue")
+	if C.JobsQueue < C.JobsWorkers {
+		log.Fatalln("jobs.queue must be at least jobs.workers")
+	}
+	C.JobsAsyncSize = parseSize(viper.GetString("jobs.asyncsize"))
+}
+
+// expandHome replaces a leading ~ with the home directory of the user
+func expandHome(p string) string {
+	if !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	home := os.Getenv("HOME")
+	if home == "" {
+		return p
+	}
+	return filepath.Join(home, p[2:])
+}
+
+// overlappingPrefixes reports whether the keys under one of the path
+// prefixes a and b may be under the other, e.g. for the originals and
+// thumbnails of a bucket, whose garbage collection would take originals
+// for thumbnails
+func overlappingPrefixes(a, b string) bool {
+	a, b = strings.Trim(a, "/"), strings.Trim(b, "/")
+	if a == "" || b == "" {
+		return true
+	}
+	return strings.HasPrefix(a+"/", b+"/") || strings.HasPrefix(b+"/", a+"/")
+}
+
+// splitList splits a comma separated config value, ignoring empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseRoutes parses a comma separated list of namespace=value items, e.g.
+// the backends of origin.routes, "products=s3,avatars=webdav", or the
+// watermarks of watermark.tenants
+func parseRoutes(s string) map[string]string {
+	routes := map[string]string{}
+	for _, item := range splitList(s) {
+		i := strings.Index(item, "=")
+		if i <= 0 {
+			log.Fatalln("Could not parse route", item)
+		}
+		ns := strings.Trim(strings.TrimSpace(item[:i]), "/")
+		routes[ns] = strings.TrimSpace(item[i+1:])
+	}
+	return routes
+}
+
+// parsePresets parses a whitespace separated list of name=tier?params
+// items, e.g. "small=200x200/crop/smart?format=webp
+// focus=300x200/crop/smart?fp=0.3,0.7". Queries hold commas, e.g. in focal
+// points and crops, but no unescaped spaces.
+func parsePresets(s string) map[string]string {
+	presets := map[string]string{}
+	for _, item := range strings.Fields(s) {
+		i := strings.Index(item, "=")
+		if i <= 0 {
+			log.Fatalln("Could not parse preset", item)
+		}
+		presets[item[:i]] = item[i+1:]
+	}
+	return presets
+}
+
+// parseQuotaPrefixes parses a comma separated list of prefix=size[/objects]
+// items, e.g. "users/alice/=1G/1000,users/bob/=500M"
+func parseQuotaPrefixes(s string) []QuotaPrefix {
+	var prefixes []QuotaPrefix
+	for _, item := range splitList(s) {
+		i := strings.LastIndex(item, "=")
+		if i < 0 {
+			log.Fatalln("Could not parse quota prefix", item)
+		}
+		q := QuotaPrefix{Prefix: item[:i]}
+		limits := strings.SplitN(item[i+1:], "/", 2)
+		q.MaxSize = parseSize(limits[0])
+		if len(limits) > 1 {
+			n, err := strconv.ParseInt(limits[1], 10, 64)
+			if err != nil {
+				log.Fatalln("Could not parse quota prefix", item)
+			}
+			q.MaxObjects = n
+		}
+		prefixes = append(prefixes, q)
+	}
+	return prefixes
 }
 
 func parseSize(sizeStr string) int64 {
 	runes := []rune(sizeStr)
 	length := utf8.RuneCountInString(sizeStr)
+	if length > 0 && unicode.IsDigit(runes[length-1]) {
+		// no unit, plain bytes
+		runes = append(runes, 'B')
+		length++
+	}
 	unit := string(runes[length-1:])
 	number, err := strconv.Atoi(string(runes[:length-1]))
 	if err != nil {
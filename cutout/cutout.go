@@ -0,0 +1,57 @@
+package cutout
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Remover removes the background of images, e.g. with a segmentation
+// model, returning them auto-oriented with a transparent background
+type Remover interface {
+	RemoveBackground(ctx context.Context, buf []byte) ([]byte, error)
+}
+
+// HTTP posts images to a background removal service at URL, which
+// responds with their cutout as a PNG or WebP
+type HTTP struct {
+	URL     string
+	MaxSize int64
+	client  *http.Client
+}
+
+func NewHTTP(url string, timeout time.Duration, maxSize int64) *HTTP {
+	return &HTTP{URL: url, MaxSize: maxSize, client: &http.Client{Timeout: timeout}}
+}
+
+func (h *HTTP) RemoveBackground(ctx context.Context, buf []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", http.DetectContentType(buf))
+	resp, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("background removal responded " + resp.Status)
+	}
+	var body io.Reader = resp.Body
+	if h.MaxSize > 0 {
+		body = io.LimitReader(resp.Body, h.MaxSize+1)
+	}
+	cutout, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if h.MaxSize > 0 && int64(len(cutout)) > h.MaxSize {
+		return nil, errors.New("cutout too large")
+	}
+	return cutout, nil
+}
@@ -3,6 +3,7 @@ package etag
 import (
 	"crypto/sha1"
 	"fmt"
+	"time"
 )
 
 func getHash(buf []byte) string {
@@ -19,3 +20,15 @@ func Generate(buf []byte, weak bool) string {
 
 	return tag
 }
+
+// GenerateFromStat generates a weak Etag from the size and modification time
+// of a file, avoiding reading its content
+func GenerateFromStat(size int64, modTime time.Time) string {
+	return fmt.Sprintf("W/\"%d-%x\"", size, modTime.UnixNano())
+}
+
+// GenerateFromHash generates a strong Etag from a content hash computed
+// beforehand
+func GenerateFromHash(size int64, hash string) string {
+	return fmt.Sprintf("\"%d-%s\"", size, hash)
+}
@@ -1,12 +1,24 @@
 module github.com/kxlt/imageresizer
 
 require (
+	cloud.google.com/go v0.34.0
+	github.com/Azure/azure-storage-blob-go v0.6.0
 	github.com/aws/aws-sdk-go v1.15.59
 	github.com/cespare/xxhash v1.1.0
 	github.com/cloudflare/tableflip v0.0.0-20181019105324-78281f93d075
 	github.com/djherbis/atime v1.0.0
+	github.com/esimov/pigo v1.4.6
+	github.com/googleapis/gax-go v2.0.2+incompatible
 	github.com/gorilla/mux v1.6.2
+	github.com/klauspost/compress v1.10.3
+	github.com/kr/fs v0.0.0-20131111012553-2788f0dbd169
+	github.com/lib/pq v1.0.0
 	github.com/pkg/errors v0.8.0 // indirect
+	github.com/pkg/sftp v1.8.3
 	github.com/rcrowley/go-metrics v0.0.0-20181016184325-3113b8401b8a
 	github.com/spf13/viper v1.2.1
+	go.etcd.io/bbolt v1.3.0
+	golang.org/x/crypto v0.0.0-20181030102418-4d3f4d9ffa16
+	golang.org/x/sys v0.7.0
+	google.golang.org/api v0.1.0
 )
@@ -0,0 +1,98 @@
+package imager
+
+import (
+	"math"
+	"strings"
+)
+
+// blurHashSize is the size images are scaled down to before computing
+// their BlurHash, which keeps only the low frequencies anyway
+const blurHashSize = 32
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// encodeBlurHash encodes the 8 bit rgb pixels with xComponents by
+// yComponents (1-9) cosine components, see https://blurha.sh
+func encodeBlurHash(pixels []byte, width, height, xComponents, yComponents int) string {
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			var f [3]float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := math.Cos(math.Pi*float64(i*x)/float64(width)) *
+						math.Cos(math.Pi*float64(j*y)/float64(height))
+					p := pixels[3*(y*width+x):]
+					for c := 0; c < 3; c++ {
+						f[c] += basis * srgbToLinear(p[c])
+					}
+				}
+			}
+			scale := 2 / float64(width*height)
+			if i == 0 && j == 0 {
+				scale = 1 / float64(width*height)
+			}
+			for c := range f {
+				f[c] *= scale
+			}
+			factors = append(factors, f)
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(base83((xComponents-1)+(yComponents-1)*9, 1))
+	maxValue := 1.0
+	if len(factors) > 1 {
+		actualMax := 0.0
+		for _, f := range factors[1:] {
+			for _, v := range f {
+				actualMax = math.Max(actualMax, math.Abs(v))
+			}
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		hash.WriteString(base83(quantisedMax, 1))
+	} else {
+		hash.WriteString(base83(0, 1))
+	}
+	dc := factors[0]
+	hash.WriteString(base83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+	for _, f := range factors[1:] {
+		value := 0
+		for _, v := range f {
+			q := math.Floor(signPow(v/maxValue, 0.5)*9 + 9.5)
+			value = value*19 + int(math.Max(0, math.Min(18, q)))
+		}
+		hash.WriteString(base83(value, 2))
+	}
+	return hash.String()
+}
+
+func base83(value, length int) string {
+	b := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		b[i] = base83Chars[value%83]
+		value /= 83
+	}
+	return string(b)
+}
+
+func srgbToLinear(v byte) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
@@ -0,0 +1,114 @@
+package imager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// colorsSize is the size images are scaled down to before extracting their
+// colors
+const colorsSize = 64
+
+// minPaletteDistance is the squared distance under which colors are
+// considered the same shade in palettes
+const minPaletteDistance = 48 * 48
+
+// RGB is an 8 bit sRGB color
+type RGB [3]uint8
+
+// Hex returns the color as #rrggbb
+func (c RGB) Hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", c[0], c[1], c[2])
+}
+
+// Colors queues the extraction of the average color of buf and of a
+// palette of up to n of its colors, the most frequent first
+func Colors(ctx context.Context, buf []byte, n int) (RGB, []RGB, error) {
+	pixels, err := run(ctx, func() ([]byte, error) {
+		pixels, _, _, err := rgbPixels(buf, colorsSize)
+		return pixels, err
+	})
+	if err != nil {
+		return RGB{}, nil, err
+	}
+	return averageColor(pixels), palette(pixels, n), nil
+}
+
+func averageColor(pixels []byte) RGB {
+	var sum [3]int
+	for i := 0; i+2 < len(pixels); i += 3 {
+		for c := range sum {
+			sum[c] += int(pixels[i+c])
+		}
+	}
+	var avg RGB
+	if count := len(pixels) / 3; count > 0 {
+		for c := range avg {
+			avg[c] = uint8(sum[c] / count)
+		}
+	}
+	return avg
+}
+
+// palette quantizes the pixels to 4 bits per channel and returns the mean
+// colors of the most populated buckets, skipping shades of colors already
+// picked
+func palette(pixels []byte, n int) []RGB {
+	type bucket struct {
+		key   int
+		count int
+		sum   [3]int
+	}
+	buckets := map[int]*bucket{}
+	for i := 0; i+2 < len(pixels); i += 3 {
+		key := int(pixels[i]>>4)<<8 | int(pixels[i+1]>>4)<<4 | int(pixels[i+2]>>4)
+		b, ok := buckets[key]
+		if !ok {
+			b = &bucket{key: key}
+			buckets[key] = b
+		}
+		b.count++
+		for c := range b.sum {
+			b.sum[c] += int(pixels[i+c])
+		}
+	}
+	sorted := make([]*bucket, 0, len(buckets))
+	for _, b := range buckets {
+		sorted = append(sorted, b)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		// deterministic order of ties
+		return sorted[i].key < sorted[j].key
+	})
+	var colors []RGB
+	for _, b := range sorted {
+		if len(colors) == n {
+			break
+		}
+		color := RGB{uint8(b.sum[0] / b.count), uint8(b.sum[1] / b.count), uint8(b.sum[2] / b.count)}
+		distinct := true
+		for _, picked := range colors {
+			if colorDistance(color, picked) < minPaletteDistance {
+				distinct = false
+				break
+			}
+		}
+		if distinct {
+			colors = append(colors, color)
+		}
+	}
+	return colors
+}
+
+func colorDistance(a, b RGB) int {
+	d := 0
+	for c := range a {
+		diff := int(a[c]) - int(b[c])
+		d += diff * diff
+	}
+	return d
+}
@@ -0,0 +1,62 @@
+package imager
+
+import (
+	pigo "github.com/esimov/pigo/core"
+)
+
+// faceDetectionSize is the size images are scaled down to before looking
+// for faces, larger is slower but finds smaller faces
+const faceDetectionSize = 512
+
+// minFaceQuality filters out weak detections
+const minFaceQuality = 5.0
+
+var faceClassifier *pigo.Pigo
+
+// SetFaceCascade loads the pigo cascade file (facefinder) used by the FACE
+// gravity. Without it FACE falls back to SMART.
+func SetFaceCascade(cascade []byte) error {
+	classifier, err := pigo.NewPigo().Unpack(cascade)
+	if err != nil {
+		return err
+	}
+	faceClassifier = classifier
+	return nil
+}
+
+// detectFaces returns the center of the box enclosing the faces found in
+// the grayscale pixels, or nil if there are none
+func detectFaces(pixels []byte, width, height int) *Point {
+	dets := faceClassifier.RunCascade(pigo.CascadeParams{
+		MinSize:     20,
+		MaxSize:     faceDetectionSize,
+		ShiftFactor: 0.1,
+		ScaleFactor: 1.1,
+		ImageParams: pigo.ImageParams{
+			Pixels: pixels,
+			Rows:   height,
+			Cols:   width,
+			Dim:    width,
+		},
+	}, 0)
+	dets = faceClassifier.ClusterDetections(dets, 0.2)
+	left, top, right, bottom := width, height, 0, 0
+	found := false
+	for _, d := range dets {
+		if d.Q < minFaceQuality {
+			continue
+		}
+		found = true
+		left = minInt(left, d.Col-d.Scale/2)
+		top = minInt(top, d.Row-d.Scale/2)
+		right = maxInt(right, d.Col+d.Scale/2)
+		bottom = maxInt(bottom, d.Row+d.Scale/2)
+	}
+	if !found {
+		return nil
+	}
+	return &Point{
+		X: float64(left+right) / 2 / float64(width),
+		Y: float64(top+bottom) / 2 / float64(height),
+	}
+}
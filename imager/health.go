@@ -0,0 +1,22 @@
+package imager
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+)
+
+// probeImage is the image resized by Healthy, a 2x2 PNG
+var probeImage = func() []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2)))
+	return buf.Bytes()
+}()
+
+// Healthy resizes a tiny image, checking that libvips works and the resize
+// workers aren't all stuck
+func Healthy(ctx context.Context) error {
+	_, err := Resize(ctx, probeImage, Options{Width: 1, Height: 1, ResizeOp: FIT})
+	return err
+}
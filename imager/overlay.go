@@ -0,0 +1,138 @@
+package imager
+
+import (
+	"fmt"
+)
+
+type PositionType int
+
+const (
+	MIDDLE PositionType = iota
+	NORTH
+	NORTHEAST
+	EAST
+	SOUTHEAST
+	SOUTH
+	SOUTHWEST
+	WEST
+	NORTHWEST
+)
+
+// Positions maps the compass points overlays can be placed at to their type
+var Positions = map[string]PositionType{
+	"c":      MIDDLE,
+	"center": MIDDLE,
+	"n":      NORTH,
+	"ne":     NORTHEAST,
+	"e":      EAST,
+	"se":     SOUTHEAST,
+	"s":      SOUTH,
+	"sw":     SOUTHWEST,
+	"w":      WEST,
+	"nw":     NORTHWEST,
+}
+
+// BlendMode selects how an overlay is combined with the image below
+type BlendMode int
+
+const (
+	OVER BlendMode = iota
+	MULTIPLY
+	SCREEN
+	OVERLAY
+	DARKEN
+	LIGHTEN
+	DIFFERENCE
+)
+
+var BlendModes = map[string]BlendMode{
+	"over":       OVER,
+	"multiply":   MULTIPLY,
+	"screen":     SCREEN,
+	"overlay":    OVERLAY,
+	"darken":     DARKEN,
+	"lighten":    LIGHTEN,
+	"difference": DIFFERENCE,
+}
+
+// Overlay is a stored image composited onto a thumbnail, e.g. a badge or
+// a frame
+type Overlay struct {
+	// Path is the original the api loads into Image
+	Path     string
+	Image    []byte
+	Position PositionType
+	Blend    BlendMode
+	// Scale is the width of the overlay relative to the thumbnail, 0 keeps
+	// its own size
+	Scale float64
+}
+
+// Watermark is an image composited onto thumbnails
+type Watermark struct {
+	Image    []byte
+	Position PositionType
+	// Opacity multiplies the alpha of the watermark (0-1)
+	Opacity float64
+	// Scale is the width of the watermark relative to the thumbnail
+	Scale float64
+	// Margin is the distance in pixels from the edges of the thumbnail
+	Margin int
+	// MinSize is the width and height under which thumbnails are too small
+	// to be watermarked
+	MinSize int
+}
+
+type MaskType int
+
+const (
+	NOMASK MaskType = iota
+	// ROUNDED rounds the corners of images
+	ROUNDED
+	// CIRCLE cuts the largest centered circle out of images
+	CIRCLE
+)
+
+// maskSVG returns an SVG of width x height, opaque where mask keeps the image
+func maskSVG(mask MaskType, radius, width, height int) []byte {
+	shape := fmt.Sprintf(`<rect width="%d" height="%d" rx="%d" ry="%d"/>`, width, height, radius, radius)
+	if mask == CIRCLE {
+		shape = fmt.Sprintf(`<circle cx="%g" cy="%g" r="%g"/>`,
+			float64(width)/2, float64(height)/2, float64(minInt(width, height))/2)
+	}
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d">%s</svg>`,
+		width, height, shape))
+}
+
+// Text is a caption drawn onto thumbnails
+type Text struct {
+	Text string
+	// Font is a Pango font family and style, e.g. "sans bold"
+	Font string
+	// Size is the size of the font in pixels
+	Size     int
+	Color    []float64 // rgb or rgba
+	Position PositionType
+}
+
+// textMargin is the distance in pixels of text from the edges
+const textMargin = 10
+
+// place returns the top left corner of an overlay of w x h at pos within
+// an image of width x height, margin pixels away from its edges
+func place(pos PositionType, width, height, w, h, margin int) (int, int) {
+	x, y := (width-w)/2, (height-h)/2
+	switch pos {
+	case NORTHWEST, WEST, SOUTHWEST:
+		x = margin
+	case NORTHEAST, EAST, SOUTHEAST:
+		x = width - w - margin
+	}
+	switch pos {
+	case NORTHWEST, NORTH, NORTHEAST:
+		y = margin
+	case SOUTHWEST, SOUTH, SOUTHEAST:
+		y = height - h - margin
+	}
+	return x, y
+}
@@ -0,0 +1,38 @@
+package imager
+
+// dHashSize is the size images are scaled down to before being reduced to
+// the 9x8 grid of their difference hash
+const dHashSize = 64
+
+// dHash computes the difference hash of the grayscale pixels: a bit per
+// pair of horizontally adjacent cells of a 9x8 grid, set when the left one
+// is brighter. Similar images have hashes a few bits apart.
+func dHash(pixels []byte, width, height int) uint64 {
+	var grid [8][9]float64
+	for gy := range grid {
+		top, bottom := gy*height/8, maxInt((gy+1)*height/8, gy*height/8+1)
+		for gx := range grid[gy] {
+			left, right := gx*width/9, maxInt((gx+1)*width/9, gx*width/9+1)
+			sum, count := 0, 0
+			for y := top; y < bottom && y < height; y++ {
+				for x := left; x < right && x < width; x++ {
+					sum += int(pixels[y*width+x])
+					count++
+				}
+			}
+			if count > 0 {
+				grid[gy][gx] = float64(sum) / float64(count)
+			}
+		}
+	}
+	var hash uint64
+	for gy := range grid {
+		for gx := 0; gx < 8; gx++ {
+			hash <<= 1
+			if grid[gy][gx] > grid[gy][gx+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
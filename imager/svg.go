@@ -0,0 +1,54 @@
+package imager
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+)
+
+var (
+	svgMaxSize     int64
+	svgMaxElements int
+)
+
+// SetSVGLimits bounds the size in bytes and the number of elements of SVG
+// originals, 0 for no limit
+func SetSVGLimits(maxSize int64, maxElements int) {
+	svgMaxSize = maxSize
+	svgMaxElements = maxElements
+}
+
+// isSVG looks for an svg element at the start of buf, after the XML
+// declaration, comments or doctype
+func isSVG(buf []byte) bool {
+	head := bytes.TrimSpace(buf)
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	if !bytes.HasPrefix(head, []byte("<")) {
+		return false
+	}
+	return bytes.Contains(head, []byte("<svg"))
+}
+
+var svgHref = regexp.MustCompile(`(?i)href\s*=\s*["']\s*([^"'#\s][^"']*)`)
+
+// checkSVG refuses SVGs over the limits, declaring entities (exponential
+// expansion) or referencing external resources
+func checkSVG(buf []byte) error {
+	if svgMaxSize > 0 && int64(len(buf)) > svgMaxSize {
+		return errors.New("svg too large")
+	}
+	if svgMaxElements > 0 && bytes.Count(buf, []byte("<")) > svgMaxElements {
+		return errors.New("svg too complex")
+	}
+	if bytes.Contains(buf, []byte("<!ENTITY")) {
+		return errors.New("svg entities are not allowed")
+	}
+	for _, m := range svgHref.FindAllSubmatch(buf, -1) {
+		if !bytes.HasPrefix(bytes.ToLower(m[1]), []byte("data:")) {
+			return errors.New("svg external references are not allowed")
+		}
+	}
+	return nil
+}
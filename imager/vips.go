@@ -6,9 +6,15 @@ package imager
 */
 import "C"
 import (
+	"context"
 	"errors"
+	"fmt"
+	"html"
 	"log"
+	"math"
 	"runtime"
+	"strings"
+	"time"
 	"unsafe"
 )
 
@@ -18,30 +24,223 @@ const (
 	UNKNOWN ImageType = iota
 	JPEG
 	PNG
+	WEBP
+	AVIF
+	GIF
+	// MP4 (and QuickTime) and WEBM videos are rendered as GIFs, to be
+	// transcoded by the caller
+	MP4
+	WEBM
+	// PDF documents are rendered page by page, see Options.Page
+	PDF
+	// SVG drawings are rasterized, see SetSVGLimits
+	SVG
+	// HEIC photos, from iPhones, are read only
+	HEIC
+	// TIFF scans, possibly multi-page, are read only
+	TIFF
 )
 
+// Formats maps the output formats clients can request to their type
+var Formats = map[string]ImageType{
+	"jpg":  JPEG,
+	"jpeg": JPEG,
+	"png":  PNG,
+	"webp": WEBP,
+	"avif": AVIF,
+	"gif":  GIF,
+	"mp4":  MP4,
+	"webm": WEBM,
+}
+
+// formatNames are the names of the image types, in their order
+var formatNames = [...]string{"unknown", "jpeg", "png", "webp", "avif", "gif", "mp4", "webm", "pdf", "svg", "heic", "tiff"}
+
+func (t ImageType) String() string {
+	if int(t) < 0 || int(t) >= len(formatNames) {
+		return formatNames[UNKNOWN]
+	}
+	return formatNames[t]
+}
+
 type GravityType int
 
 const (
 	CENTER GravityType = iota + 1
+	// SMART crops around the most salient region (attention)
 	SMART
+	// ENTROPY crops around the region with the most detail
+	ENTROPY
+	// FACE crops around the detected faces, see SetFaceCascade
+	FACE
+
+	// noCrop resizes to fit within the requested dimensions
+	noCrop GravityType = -1
 )
 
+// SharpenPresets maps sharpening levels to the sigma of the unsharp mask
+var SharpenPresets = map[string]float64{
+	"none":   0,
+	"light":  0.5,
+	"medium": 1,
+	"strong": 2,
+}
+
 var Gravity = map[string]GravityType{
-	"c": CENTER,
-	"s": SMART,
+	"c":       CENTER,
+	"center":  CENTER,
+	"s":       SMART,
+	"smart":   SMART,
+	"e":       ENTROPY,
+	"entropy": ENTROPY,
+	"f":       FACE,
+	"face":    FACE,
+}
+
+// KernelType is the resampling kernel of resizes
+type KernelType int
+
+const (
+	LANCZOS3 KernelType = iota
+	LANCZOS2
+	MITCHELL
+	CUBIC
+	LINEAR
+	// NEAREST keeps the pixels of pixel art sharp
+	NEAREST
+)
+
+var Kernels = map[string]KernelType{
+	"lanczos3": LANCZOS3,
+	"lanczos2": LANCZOS2,
+	"mitchell": MITCHELL,
+	"cubic":    CUBIC,
+	"linear":   LINEAR,
+	"nearest":  NEAREST,
+}
+
+var vipsKernels = map[KernelType]C.VipsKernel{
+	LANCZOS2: C.VIPS_KERNEL_LANCZOS2,
+	MITCHELL: C.VIPS_KERNEL_MITCHELL,
+	CUBIC:    C.VIPS_KERNEL_CUBIC,
+	LINEAR:   C.VIPS_KERNEL_LINEAR,
+	NEAREST:  C.VIPS_KERNEL_NEAREST,
+}
+
+// Rect is an area of an image, in fractions of its width and height
+type Rect struct {
+	X float64
+	Y float64
+	W float64
+	H float64
+}
+
+// Area is an area of an image, in pixels
+type Area struct {
+	Left   int
+	Top    int
+	Width  int
+	Height int
+}
+
+type RedactMode int
+
+const (
+	PIXELATE RedactMode = iota
+	BLUR
+)
+
+var RedactModes = map[string]RedactMode{
+	"pixelate": PIXELATE,
+	"blur":     BLUR,
+}
+
+// FilterType is a stylistic color filter of thumbnails
+type FilterType int
+
+const (
+	NOFILTER FilterType = iota
+	SEPIA
+	DUOTONE
+	NEGATE
+	// TINT multiplies the colors by Options.FilterColors[0]
+	TINT
+)
+
+var Filters = map[string]FilterType{
+	"sepia":   SEPIA,
+	"duotone": DUOTONE,
+	"negate":  NEGATE,
+}
+
+// duotoneShadows and duotoneHighlights are the default rgb colors of the
+// DUOTONE filter
+var (
+	duotoneShadows    = []float64{30, 35, 90}
+	duotoneHighlights = []float64{250, 220, 160}
+)
+
+// Canvas is a fixed size background thumbnails are placed onto, e.g. for
+// uniform marketplace cards. Thumbnails larger than it are clipped.
+type Canvas struct {
+	Width  int
+	Height int
+	// Left and Top are the position of the thumbnail, ignored when Centered
+	Left       int
+	Top        int
+	Centered   bool
+	Background []float64 // rgb or rgba
 }
 
+// Point is a position within an image, in fractions of its width and height
+type Point struct {
+	X float64
+	Y float64
+}
+
+// ICCMode selects how color profiles are handled
+type ICCMode int
+
+var ICCModes = map[string]ICCMode{
+	"srgb": ICCSRGB,
+	"keep": ICCKeep,
+}
+
+const (
+	// ICCSRGB converts images with a color profile to sRGB
+	ICCSRGB ICCMode = iota
+	// ICCKeep embeds the profile of the source in the thumbnail
+	ICCKeep
+)
+
 type ResizeOpType int
 
 const (
+	// CROP covers the requested dimensions, cropping the edges
 	CROP ResizeOpType = iota
+	// FIT fits within the requested dimensions, optionally extending the
+	// edges up to them
 	FIT
+	// FILL stretches to the requested dimensions, ignoring the aspect ratio
+	FILL
+	// INSIDE fits within the requested dimensions, without enlarging
+	INSIDE
+	// OUTSIDE covers the requested dimensions, without cropping
+	OUTSIDE
+	// PAD fits within the requested dimensions and letterboxes the image up
+	// to them with ExtendBackground
+	PAD
 )
 
 var ResizeOp = map[string]ResizeOpType{
-	"crop": CROP,
-	"fit":  FIT,
+	"crop":    CROP,
+	"cover":   CROP,
+	"fit":     FIT,
+	"contain": FIT,
+	"fill":    FILL,
+	"inside":  INSIDE,
+	"outside": OUTSIDE,
+	"pad":     PAD,
 }
 
 type Options struct {
@@ -50,13 +249,131 @@ type Options struct {
 	ResizeOp         ResizeOpType
 	Gravity          GravityType
 	Quality          int
-	ExtendBackground []float64
+	ExtendBackground []float64 // rgb or rgba color of the edges added by FIT and PAD
+	// Format is the output format, UNKNOWN keeps the source format
+	Format ImageType
+	// Effort trades encoding speed for size (AVIF: 0-9, WebP: 0-6, PNG:
+	// 0-9 zlib compression). 0 keeps the defaults of WebP and PNG.
+	Effort int
+	// Lossless encodes WebP and AVIF thumbnails losslessly. NearLossless
+	// instead preprocesses WebP ones by Quality, to compress better while
+	// staying visually lossless.
+	Lossless     bool
+	NearLossless bool
+	// MaxBytes, when set, lowers the Quality of JPEG, WebP and AVIF
+	// thumbnails until they fit in as many bytes, down to minBudgetQuality
+	MaxBytes int
+	// Progressive emits progressive JPEGs and interlaced PNGs
+	Progressive bool
+	// KeepMetadata preserves the EXIF and XMP metadata of the source, which
+	// may include its GPS location and camera serial numbers
+	KeepMetadata bool
+	ICC          ICCMode
+	// Scale, when set, sizes the thumbnail relative to the source (0-1),
+	// Width and Height are then 0
+	Scale float64
+	// MaxPixels, when set, refuses thumbnails of more pixels with
+	// ErrTooLarge, once the dimension left to the aspect ratio of the source
+	// is known
+	MaxPixels int
+	// Kernel resamples the source, LANCZOS3 being the one of libvips
+	// thumbnails. Others resize the whole decoded source, animations are
+	// left to LANCZOS3.
+	Kernel KernelType
+	// NoEnlarge keeps images smaller than the requested dimensions at their
+	// size, PAD still extends them up to the requested dimensions
+	NoEnlarge bool
+	// Crop, when set, is the area of the auto-oriented image thumbnails are
+	// made of, e.g. picked in an editor. It is clipped to the image.
+	Crop *Area
+	// Trim crops the borders of the color of the top left pixel, or the
+	// transparent ones, before resizing. TrimThreshold is the difference
+	// from it still considered border (0-255).
+	Trim          bool
+	TrimThreshold float64
+	// Denoise is the size of the median filter applied before resizing,
+	// e.g. to high ISO photos, 0 for none
+	Denoise int
+	// Focal, when set, is the point crops are centered on, in coordinates
+	// normalized to 0-1 of the auto-oriented image. It takes precedence
+	// over Gravity.
+	Focal *Point
+	// Zoom, when above 1, narrows crops around Focal, or according to
+	// Gravity, by that factor, e.g. for tighter face crops
+	Zoom float64
+	// Page is the page of PDF and TIFF originals, starting from 0
+	Page int
+	// Frame, when set, is the frame of animated GIF and WebP originals,
+	// starting from 1, resized as a still image. Past the last frame, the
+	// last one is.
+	Frame int
+	// Time is the timestamp of the frame of video originals, which the
+	// caller extracts
+	Time time.Duration
+	// RemoveBackground replaces the source by its cutout, which the caller
+	// obtains
+	RemoveBackground bool
+	// Redact pixelates or blurs areas of the auto-oriented image, e.g.
+	// faces or license plates
+	Redact     []Rect
+	RedactMode RedactMode
+	// Blur is the sigma of a gaussian blur of the thumbnail, 0 for none
+	Blur float64
+	// Sharpen is the sigma of an unsharp mask applied to the thumbnail, 0
+	// for none
+	Sharpen float64
+	// Brightness is added to the pixel values of the thumbnail (-255-255)
+	Brightness float64
+	// Contrast multiplies the distance of the pixel values of the thumbnail
+	// from mid-gray, 0 leaves it as is
+	Contrast float64
+	// Gamma corrects the thumbnail by raising its pixel values, in 0-1, to
+	// 1/Gamma, 0 leaves it as is
+	Gamma float64
+	// Flip mirrors the thumbnail vertically (upside down), before Rotate
+	Flip bool
+	// Flop mirrors the thumbnail horizontally (left to right), before Rotate
+	Flop bool
+	// Rotate is the clockwise rotation in degrees applied after resizing.
+	// Width and Height are swapped beforehand for 90 and 270 so they hold
+	// for the result, other angles enlarge it to fit the rotated image.
+	Rotate float64
+	// Background is the rgb or rgba color of the corners uncovered by
+	// rotations by arbitrary angles, white by default
+	Background []float64
+	// Grayscale removes the colors of the result
+	Grayscale bool
+	// Saturation multiplies the chroma of the result, 0 leaves it as is
+	Saturation float64
+	// Filter tones the result, after Grayscale and Saturation
+	Filter FilterType
+	// FilterColors are the rgb colors of DUOTONE, shadows then highlights,
+	// navy and cream when unset, and of TINT
+	FilterColors [][]float64
+	// Overlay, when set, is composited onto the result
+	Overlay *Overlay
+	// Text, when set, is drawn onto the result
+	Text *Text
+	// Watermark, when set, is composited onto the result
+	Watermark *Watermark
+	// Mask makes the result transparent outside of rounded corners of
+	// MaskRadius pixels, or of a circle. JPEGs are turned into PNGs.
+	Mask       MaskType
+	MaskRadius int
+	// Canvas, when set, is what the result is placed onto, last. Its pixels
+	// count towards MaxPixels.
+	Canvas *Canvas
 }
 
+// ErrTooLarge is returned for thumbnails over Options.MaxPixels
+var ErrTooLarge = errors.New("thumbnail too large")
+
+// ResizeRequest is a job for the workers, a resize or any other work with
+// libvips
 type ResizeRequest struct {
-	in      []byte
-	options Options
-	out     chan *ResizeResponse
+	ctx context.Context
+	fn  func() ([]byte, error)
+	out chan *ResizeResponse
 }
 
 type ResizeResponse struct {
@@ -88,52 +405,333 @@ func worker(reqChan <-chan *ResizeRequest) {
 	defer C.vips_thread_shutdown()
 
 	for req := range reqChan {
-		buf := req.in
-		options := req.options
-
-		var iWidth, iHeight, origOWidth, origOHeight int
-		if options.ResizeOp == FIT {
-			image, err := vipsImageNew(buf) // this is efficient because vips only reads bytes as needed
-			if err != nil {
-				req.out <- &ResizeResponse{buf: nil, err: err}
-			}
-			iWidth = int(C.vips_image_get_width(image))
-			iHeight = int(C.vips_image_get_height(image))
-			origOWidth = options.Width
-			origOHeight = options.Height
-			if iWidth*options.Height > options.Width*iHeight {
-				// aspect ratio of original image is bigger than target aspect ratio
-				// shrink height
-				options.Height = options.Width * iHeight / iWidth
-			} else {
-				options.Width = iWidth * options.Height / iHeight
-			}
-			C.g_object_unref(C.gpointer(image))
+		if err := req.ctx.Err(); err != nil {
+			// client went away while the request was queued
+			req.out <- &ResizeResponse{buf: nil, err: err}
+			continue
 		}
+		buf, err := req.fn()
+		req.out <- &ResizeResponse{buf: buf, err: err}
+	}
+}
 
-		image, err := vipsThumbnail(buf, options.Width, options.Height, options.Gravity)
-		if err != nil {
-			req.out <- &ResizeResponse{buf: nil, err: err}
+// sourceSize returns the dimensions of buf once auto-oriented
+func sourceSize(buf []byte) (int, int, error) {
+	image, err := vipsImageNew(buf) // this is efficient because vips only reads bytes as needed
+	if err != nil {
+		return 0, 0, err
+	}
+	defer C.g_object_unref(C.gpointer(image))
+	width := int(C.vips_image_get_width(image))
+	height := int(C.vips_image_get_height(image))
+	if C.vips_orientation_cgo(image) >= 5 {
+		// rotated by 90 or 270 degrees when auto-oriented
+		width, height = height, width
+	}
+	return width, height, nil
+}
+
+// screenDPI is the resolution of thumbnails of scans
+const screenDPI = 72
+
+func resize(buf []byte, options Options) ([]byte, error) {
+	srcType := GetImageType(buf)
+	if err := checkSource(buf); err != nil {
+		return nil, err
+	}
+	if options.Frame > 0 {
+		if frames, _ := animation(buf); frames > 1 {
+			options.Page = minInt(options.Frame, frames) - 1
+		}
+	}
+	if options.Crop != nil || options.Trim || options.Denoise > 0 {
+		// animations are left as is
+		if frames, _ := animation(buf); frames <= 1 || options.Frame > 0 {
+			var err error
+			if buf, err = precrop(buf, options); err != nil {
+				return nil, err
+			}
+			options.Page = 0
+		}
+	}
+	var iWidth, iHeight, origOWidth, origOHeight int
+	if options.Rotate == 90 || options.Rotate == 270 {
+		options.Width, options.Height = options.Height, options.Width
+	}
+	if options.Width == 0 || options.Height == 0 {
+		var err error
+		if iWidth, iHeight, err = sourceSize(buf); err != nil {
+			return nil, err
+		}
+		if options.Scale > 0 {
+			options.Width = maxInt(1, int(math.Round(float64(iWidth)*options.Scale)))
+			options.Height = maxInt(1, int(math.Round(float64(iHeight)*options.Scale)))
+		} else if options.Width == 0 {
+			options.Width = maxInt(1, int(math.Round(float64(options.Height*iWidth)/float64(iHeight))))
+		} else {
+			options.Height = maxInt(1, int(math.Round(float64(options.Width*iHeight)/float64(iWidth))))
+		}
+		if options.MaxPixels > 0 && options.Width*options.Height > options.MaxPixels {
+			return nil, ErrTooLarge
+		}
+	}
+	if c := options.Canvas; c != nil && options.MaxPixels > 0 && c.Width*c.Height > options.MaxPixels {
+		return nil, ErrTooLarge
+	}
+	if options.ResizeOp == CROP && options.Gravity == FACE && options.Focal == nil {
+		options.Focal = findFaces(buf)
+		if options.Focal == nil {
+			options.Gravity = SMART
+		}
+	}
+	if options.NoEnlarge && (options.ResizeOp == CROP || options.ResizeOp == FILL) {
+		var err error
+		if iWidth, iHeight, err = sourceSize(buf); err != nil {
+			return nil, err
 		}
+		options.Width = minInt(options.Width, iWidth)
+		options.Height = minInt(options.Height, iHeight)
+	}
+	switch options.ResizeOp {
+	case FILL, INSIDE:
+		options.Gravity = noCrop
+	case OUTSIDE:
+		var err error
+		if options.Width, options.Height, err = coverSize(buf, options.Width, options.Height); err != nil {
+			return nil, err
+		}
+		options.Gravity = noCrop
+	}
+	if options.ResizeOp == FIT || options.ResizeOp == PAD {
+		var err error
+		if iWidth, iHeight, err = sourceSize(buf); err != nil {
+			return nil, err
+		}
+		origOWidth = options.Width
+		origOHeight = options.Height
+		if iWidth*options.Height > options.Width*iHeight {
+			// aspect ratio of original image is bigger than target aspect ratio
+			// shrink height
+			options.Height = options.Width * iHeight / iWidth
+		} else {
+			options.Width = iWidth * options.Height / iHeight
+		}
+		if options.NoEnlarge && options.Width > iWidth {
+			options.Width, options.Height = iWidth, iHeight
+		}
+	}
 
-		if len(options.ExtendBackground) > 0 {
-			prevImage := image
-			x := (origOWidth - options.Width) / 2
-			y := (origOHeight - options.Height) / 2
-			image, err = vipsEmbed(prevImage, x, y, origOWidth, origOHeight, options.ExtendBackground)
-			C.g_object_unref(C.gpointer(prevImage))
-			if err != nil {
-				req.out <- &ResizeResponse{buf: nil, err: err}
+	if options.Kernel != LANCZOS3 {
+		if frames, _ := animation(buf); frames <= 1 || options.Frame > 0 {
+			var err error
+			if buf, err = resample(buf, options); err != nil {
+				return nil, err
 			}
+			options.Page = 0
 		}
+	}
 
-		thumbBuf, err := vipsSave(GetImageType(buf), image)
-		C.g_object_unref(C.gpointer(image))
+	format := options.Format
+	if format == UNKNOWN {
+		format = srcType
+	}
+	if format == MP4 || format == WEBM {
+		format = GIF
+	}
+	if format == PDF || format == SVG {
+		// for sharp text and edges
+		format = PNG
+	}
+	if format == HEIC || format == TIFF {
+		format = JPEG
+	}
+	if format == JPEG && options.Mask != NOMASK {
+		// needs transparency
+		format = PNG
+	}
+	if (format == GIF || format == WEBP) && options.Frame == 0 {
+		// animations over the limits are served as still images
+		if frames, duration := animation(buf); frames > 1 &&
+			(maxFrames <= 0 || frames <= maxFrames) &&
+			(maxDuration <= 0 || duration <= maxDuration) {
+			return resizeAnimated(buf, options, format, origOWidth, origOHeight)
+		}
+	}
+
+	var (
+		image *C.VipsImage
+		err   error
+	)
+	if options.ResizeOp == CROP && (options.Focal != nil || len(options.Redact) > 0 || options.Zoom > 1) {
+		image, err = coverCrop(buf, options)
+	} else if image, err = vipsThumbnail(buf, options); err == nil && len(options.Redact) > 0 {
+		// the thumbnail shows the whole image
+		prevImage := image
+		image, err = vipsRedact(prevImage, options.Redact, options.RedactMode)
+		C.g_object_unref(C.gpointer(prevImage))
+	}
+	if err == nil && srcType == TIFF {
+		// scans keep their 300 or 600 dpi otherwise, making thumbnails tiny
+		// once printed or placed in documents
+		prevImage := image
+		image, err = vipsResolution(prevImage, screenDPI)
+		C.g_object_unref(C.gpointer(prevImage))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if image, err = postprocess(image, options, format, origOWidth, origOHeight); err != nil {
+		return nil, err
+	}
+	thumbBuf, err := saveWithin(format, image, options)
+	C.g_object_unref(C.gpointer(image))
+	return thumbBuf, err
+}
+
+// postprocess applies the options following the resize to image, taking
+// ownership of it
+func postprocess(image *C.VipsImage, options Options, format ImageType, origOWidth, origOHeight int) (*C.VipsImage, error) {
+	var err error
+	C.vips_reset_orientation_cgo(image)
+	if options.ICC == ICCKeep && !options.KeepMetadata {
+		C.vips_strip_cgo(image)
+	}
+	if options.Blur > 0 {
+		prevImage := image
+		image, err = vipsBlur(prevImage, options.Blur)
+		C.g_object_unref(C.gpointer(prevImage))
 		if err != nil {
-			req.out <- &ResizeResponse{buf: nil, err: err}
+			return nil, err
+		}
+	}
+	if len(options.ExtendBackground) > 0 {
+		bg := options.ExtendBackground
+		if format == JPEG {
+			bg = opaque(bg)
+		}
+		prevImage := image
+		x := (origOWidth - options.Width) / 2
+		y := (origOHeight - options.Height) / 2
+		image, err = vipsEmbed(prevImage, x, y, origOWidth, origOHeight, bg)
+		C.g_object_unref(C.gpointer(prevImage))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.Sharpen > 0 {
+		prevImage := image
+		image, err = vipsSharpen(prevImage, options.Sharpen)
+		C.g_object_unref(C.gpointer(prevImage))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.Brightness != 0 || options.Contrast != 0 || options.Gamma != 0 {
+		prevImage := image
+		image, err = vipsAdjust(prevImage, options.Brightness, options.Contrast, options.Gamma)
+		C.g_object_unref(C.gpointer(prevImage))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.Flip || options.Flop {
+		prevImage := image
+		image, err = vipsFlip(prevImage, options.Flip, options.Flop)
+		C.g_object_unref(C.gpointer(prevImage))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.Rotate != 0 {
+		bg := options.Background
+		if len(bg) == 0 {
+			bg = []float64{255, 255, 255}
+		}
+		if format == JPEG {
+			bg = opaque(bg)
+		}
+		prevImage := image
+		image, err = vipsRotate(prevImage, options.Rotate, bg)
+		C.g_object_unref(C.gpointer(prevImage))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.Grayscale {
+		prevImage := image
+		image, err = vipsGrayscale(prevImage)
+		C.g_object_unref(C.gpointer(prevImage))
+		if err != nil {
+			return nil, err
+		}
+	} else if options.Saturation > 0 && options.Saturation != 1 {
+		prevImage := image
+		image, err = vipsSaturation(prevImage, options.Saturation)
+		C.g_object_unref(C.gpointer(prevImage))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.Filter != NOFILTER {
+		prevImage := image
+		image, err = vipsFilter(prevImage, options.Filter, options.FilterColors)
+		C.g_object_unref(C.gpointer(prevImage))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if o := options.Overlay; o != nil && len(o.Image) > 0 {
+		prevImage := image
+		image, err = vipsComposite(prevImage, o.Image, o.Scale, 1, o.Position, 0, o.Blend)
+		C.g_object_unref(C.gpointer(prevImage))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.Text != nil && options.Text.Text != "" {
+		prevImage := image
+		image, err = vipsText(prevImage, options.Text)
+		C.g_object_unref(C.gpointer(prevImage))
+		if err != nil {
+			return nil, err
 		}
-		req.out <- &ResizeResponse{buf: thumbBuf, err: nil}
 	}
+	if wm := options.Watermark; wm != nil &&
+		int(C.vips_image_get_width(image)) >= wm.MinSize &&
+		int(C.vips_image_get_height(image)) >= wm.MinSize {
+		prevImage := image
+		image, err = vipsComposite(prevImage, wm.Image, wm.Scale, wm.Opacity, wm.Position, wm.Margin, OVER)
+		C.g_object_unref(C.gpointer(prevImage))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.Mask != NOMASK {
+		prevImage := image
+		image, err = vipsMask(prevImage, options.Mask, options.MaskRadius)
+		C.g_object_unref(C.gpointer(prevImage))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c := options.Canvas; c != nil {
+		bg := c.Background
+		if format == JPEG {
+			bg = opaque(bg)
+		}
+		x, y := c.Left, c.Top
+		if c.Centered {
+			x = (c.Width - int(C.vips_image_get_width(image))) / 2
+			y = (c.Height - int(C.vips_image_get_height(image))) / 2
+		}
+		prevImage := image
+		image, err = vipsEmbed(prevImage, x, y, c.Width, c.Height, bg)
+		C.g_object_unref(C.gpointer(prevImage))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return image, nil
 }
 
 func ShutdownVIPS() {
@@ -144,20 +742,254 @@ func GetImageType(buf []byte) ImageType {
 	if len(buf) < 12 {
 		return UNKNOWN
 	}
+	if isSVG(buf) {
+		return SVG
+	}
 	if buf[0] == 0xFF && buf[1] == 0xD8 && buf[2] == 0xFF {
 		return JPEG
 	}
 	if buf[0] == 0x89 && buf[1] == 0x50 && buf[2] == 0x4E && buf[3] == 0x47 {
 		return PNG
 	}
+	if string(buf[0:4]) == "RIFF" && string(buf[8:12]) == "WEBP" {
+		return WEBP
+	}
+	if string(buf[4:8]) == "ftyp" && (string(buf[8:12]) == "avif" || string(buf[8:12]) == "avis") {
+		return AVIF
+	}
+	if string(buf[0:6]) == "GIF87a" || string(buf[0:6]) == "GIF89a" {
+		return GIF
+	}
+	if string(buf[4:8]) == "ftyp" {
+		switch string(buf[8:12]) {
+		case "heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1":
+			return HEIC
+		case "isom", "iso2", "mp41", "mp42", "avc1", "M4V ", "qt  ":
+			return MP4
+		}
+	}
+	if buf[0] == 0x1A && buf[1] == 0x45 && buf[2] == 0xDF && buf[3] == 0xA3 {
+		return WEBM
+	}
+	if string(buf[0:5]) == "%PDF-" {
+		return PDF
+	}
+	if string(buf[0:4]) == "II*\x00" || string(buf[0:4]) == "MM\x00*" {
+		return TIFF
+	}
 	return UNKNOWN
 }
 
-func Resize(buf []byte, options Options) ([]byte, error) {
-	resizeReq := &ResizeRequest{in: buf, options: options, out: make(chan *ResizeResponse)}
-	reqChan <- resizeReq
-	res := <-resizeReq.out
-	return res.buf, res.err
+// Resize queues a resize of buf, giving up when ctx is done before a worker
+// is done with it
+func Resize(ctx context.Context, buf []byte, options Options) ([]byte, error) {
+	return run(ctx, func() ([]byte, error) {
+		return resize(buf, options)
+	})
+}
+
+// BlurHash queues the computation of the BlurHash of buf, a compact
+// representation of its colors for placeholders
+func BlurHash(ctx context.Context, buf []byte) (string, error) {
+	hash, err := run(ctx, func() ([]byte, error) {
+		pixels, width, height, err := rgbPixels(buf, blurHashSize)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(encodeBlurHash(pixels, width, height, 4, 3)), nil
+	})
+	return string(hash), err
+}
+
+// PerceptualHash queues the computation of the difference hash of buf,
+// near-duplicates have hashes a small Hamming distance apart
+func PerceptualHash(ctx context.Context, buf []byte) (uint64, error) {
+	var hash uint64
+	_, err := run(ctx, func() ([]byte, error) {
+		if err := checkSource(buf); err != nil {
+			return nil, err
+		}
+		var (
+			ptr           unsafe.Pointer
+			width, height C.int
+		)
+		if C.vips_gray_pixels_cgo(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), C.int(dHashSize),
+			&ptr, &width, &height) != 0 {
+			return nil, vipsError()
+		}
+		pixels := C.GoBytes(ptr, width*height)
+		C.g_free(C.gpointer(ptr))
+		hash = dHash(pixels, int(width), int(height))
+		return nil, nil
+	})
+	if err != nil {
+		// hash may still be written by the worker
+		return 0, err
+	}
+	return hash, nil
+}
+
+// ImageInfo describes an image, as read from its header
+type ImageInfo struct {
+	Format ImageType
+	// Width and Height are the dimensions once auto-oriented
+	Width       int
+	Height      int
+	Orientation int
+	Pages       int
+	// EXIF holds the fields of exifFields the image has
+	EXIF map[string]string
+}
+
+// exifFields are the EXIF fields reported by Info, by their libvips name.
+// GPS fields are left out on purpose.
+var exifFields = map[string]string{
+	"Make":             "exif-ifd0-Make",
+	"Model":            "exif-ifd0-Model",
+	"Software":         "exif-ifd0-Software",
+	"DateTimeOriginal": "exif-ifd2-DateTimeOriginal",
+	"ExposureTime":     "exif-ifd2-ExposureTime",
+	"FNumber":          "exif-ifd2-FNumber",
+	"ISOSpeedRatings":  "exif-ifd2-ISOSpeedRatings",
+	"FocalLength":      "exif-ifd2-FocalLength",
+	"LensModel":        "exif-ifd2-LensModel",
+}
+
+// Info queues the reading of the header of buf
+func Info(ctx context.Context, buf []byte) (*ImageInfo, error) {
+	var info *ImageInfo
+	_, err := run(ctx, func() ([]byte, error) {
+		var err error
+		info, err = imageInfo(buf)
+		return nil, err
+	})
+	if err != nil {
+		// info may still be written by the worker
+		return nil, err
+	}
+	return info, nil
+}
+
+// Enlargement queues the computation of the factor the thumbnail of options
+// enlarges buf by, 1 or less when it doesn't and for animations
+func Enlargement(ctx context.Context, buf []byte, options Options) (float64, error) {
+	var scale float64
+	_, err := run(ctx, func() ([]byte, error) {
+		if err := checkSource(buf); err != nil {
+			return nil, err
+		}
+		if frames, _ := animation(buf); frames > 1 {
+			scale = 1
+			return nil, nil
+		}
+		width, height, err := sourceSize(buf)
+		if err != nil {
+			return nil, err
+		}
+		if area := options.Crop; area != nil {
+			width, height = minInt(width, area.Width), minInt(height, area.Height)
+		}
+		scale = enlargement(width, height, options)
+		return nil, nil
+	})
+	if err != nil {
+		// scale may still be written by the worker
+		return 0, err
+	}
+	return scale, nil
+}
+
+func enlargement(width, height int, options Options) float64 {
+	if options.Scale > 0 {
+		return options.Scale
+	}
+	if options.Rotate == 90 || options.Rotate == 270 {
+		width, height = height, width
+	}
+	x := float64(options.Width) / float64(width)
+	y := float64(options.Height) / float64(height)
+	switch {
+	case options.Width == 0:
+		return y
+	case options.Height == 0:
+		return x
+	case options.ResizeOp == CROP || options.ResizeOp == FILL || options.ResizeOp == OUTSIDE:
+		return math.Max(x, y)
+	}
+	return math.Min(x, y)
+}
+
+func imageInfo(buf []byte) (*ImageInfo, error) {
+	format := GetImageType(buf)
+	if format == SVG {
+		if err := checkSVG(buf); err != nil {
+			return nil, err
+		}
+	}
+	image, err := vipsImageNew(buf)
+	if err != nil {
+		return nil, err
+	}
+	defer C.g_object_unref(C.gpointer(image))
+	info := &ImageInfo{
+		Format:      format,
+		Width:       int(C.vips_image_get_width(image)),
+		Height:      int(C.vips_image_get_height(image)),
+		Orientation: int(C.vips_orientation_cgo(image)),
+		Pages:       int(C.vips_image_get_n_pages(image)),
+		EXIF:        map[string]string{},
+	}
+	if info.Orientation >= 5 {
+		info.Width, info.Height = info.Height, info.Width
+	}
+	for name, field := range exifFields {
+		if value, ok := vipsString(image, field); ok {
+			info.EXIF[name] = value
+		}
+	}
+	return info, nil
+}
+
+// vipsString returns the metadata field of image, without the description
+// libvips appends to EXIF fields, e.g. "Apple (Apple, ASCII, 6 components,
+// 6 bytes)"
+func vipsString(image *C.VipsImage, field string) (string, bool) {
+	cField := C.CString(field)
+	defer C.free(unsafe.Pointer(cField))
+	if C.vips_image_get_typeof(image, cField) == 0 {
+		return "", false
+	}
+	var value *C.char
+	if C.vips_image_get_string(image, cField, &value) != 0 {
+		vipsError()
+		return "", false
+	}
+	s := C.GoString(value)
+	if i := strings.Index(s, " ("); i >= 0 {
+		s = s[:i]
+	}
+	return s, true
+}
+
+// run queues fn, giving up when ctx is done before a worker is done with it
+func run(ctx context.Context, fn func() ([]byte, error)) ([]byte, error) {
+	resizeReq := &ResizeRequest{
+		ctx: ctx,
+		fn:  fn,
+		// buffered so workers never block on abandoned requests
+		out: make(chan *ResizeResponse, 1),
+	}
+	select {
+	case reqChan <- resizeReq:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case res := <-resizeReq.out:
+		return res.buf, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 }
 
 func vipsEmbed(
@@ -176,13 +1008,263 @@ func vipsEmbed(
 		C.int(y),
 		C.int(width),
 		C.int(height),
-		(*C.double)(&bg[0]))
+		(*C.double)(&bg[0]),
+		C.int(len(bg)))
 	if err != 0 {
 		return nil, vipsError()
 	}
 	return image, nil
 }
 
+func vipsBlur(in *C.VipsImage, sigma float64) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	err := C.vips_gaussblur_cgo(in, &image, C.double(sigma))
+	if err != 0 {
+		return nil, vipsError()
+	}
+	return image, nil
+}
+
+func vipsSharpen(in *C.VipsImage, sigma float64) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	err := C.vips_sharpen_cgo(in, &image, C.double(sigma))
+	if err != 0 {
+		return nil, vipsError()
+	}
+	return image, nil
+}
+
+func vipsAdjust(in *C.VipsImage, brightness, contrast, gamma float64) (*C.VipsImage, error) {
+	if contrast == 0 {
+		contrast = 1
+	}
+	if gamma == 0 {
+		gamma = 1
+	}
+	var image *C.VipsImage
+	err := C.vips_adjust_cgo(in, &image, C.double(brightness), C.double(contrast), C.double(gamma))
+	if err != 0 {
+		return nil, vipsError()
+	}
+	return image, nil
+}
+
+var vipsBlendModes = map[BlendMode]C.VipsBlendMode{
+	OVER:       C.VIPS_BLEND_MODE_OVER,
+	MULTIPLY:   C.VIPS_BLEND_MODE_MULTIPLY,
+	SCREEN:     C.VIPS_BLEND_MODE_SCREEN,
+	OVERLAY:    C.VIPS_BLEND_MODE_OVERLAY,
+	DARKEN:     C.VIPS_BLEND_MODE_DARKEN,
+	LIGHTEN:    C.VIPS_BLEND_MODE_LIGHTEN,
+	DIFFERENCE: C.VIPS_BLEND_MODE_DIFFERENCE,
+}
+
+// vipsComposite composites buf onto in, scaled to scale times the width of
+// in unless scale is 0. buf may be any original, e.g. as an overlay, and is
+// checked like them.
+func vipsComposite(
+	in *C.VipsImage,
+	buf []byte,
+	scale float64,
+	opacity float64,
+	pos PositionType,
+	margin int,
+	blend BlendMode) (*C.VipsImage, error) {
+
+	width := int(C.vips_image_get_width(in))
+	height := int(C.vips_image_get_height(in))
+	oWidth := 0
+	if scale > 0 {
+		oWidth = maxInt(1, int(scale*float64(width)))
+	}
+	if err := checkSource(buf); err != nil {
+		return nil, err
+	}
+	var overlay *C.VipsImage
+	if C.vips_overlay_load_cgo(
+		unsafe.Pointer(&buf[0]),
+		C.size_t(len(buf)),
+		&overlay,
+		C.int(oWidth),
+		C.double(opacity)) != 0 {
+		return nil, vipsError()
+	}
+	defer C.g_object_unref(C.gpointer(overlay))
+	x, y := place(pos, width, height,
+		int(C.vips_image_get_width(overlay)), int(C.vips_image_get_height(overlay)), margin)
+	var image *C.VipsImage
+	if C.vips_composite_cgo(in, overlay, &image, C.int(x), C.int(y), C.int(vipsBlendModes[blend])) != 0 {
+		return nil, vipsError()
+	}
+	return image, nil
+}
+
+func vipsText(in *C.VipsImage, text *Text) (*C.VipsImage, error) {
+	width := int(C.vips_image_get_width(in))
+	height := int(C.vips_image_get_height(in))
+	color := text.Color
+	if len(color) < 4 {
+		color = []float64{color[0], color[1], color[2], 255}
+	}
+	// the text is Pango markup
+	cText := C.CString(html.EscapeString(text.Text))
+	defer C.free(unsafe.Pointer(cText))
+	cFont := C.CString(fmt.Sprintf("%s %d", text.Font, text.Size))
+	defer C.free(unsafe.Pointer(cFont))
+	var overlay *C.VipsImage
+	if C.vips_text_overlay_cgo(
+		&overlay,
+		cText,
+		cFont,
+		C.int(maxInt(1, width-2*textMargin)),
+		(*C.double)(&color[0])) != 0 {
+		return nil, vipsError()
+	}
+	defer C.g_object_unref(C.gpointer(overlay))
+	x, y := place(text.Position, width, height,
+		int(C.vips_image_get_width(overlay)), int(C.vips_image_get_height(overlay)), textMargin)
+	var image *C.VipsImage
+	if C.vips_composite_cgo(in, overlay, &image, C.int(x), C.int(y), C.VIPS_BLEND_MODE_OVER) != 0 {
+		return nil, vipsError()
+	}
+	return image, nil
+}
+
+// vipsRedact pixelates or blurs rects of in
+func vipsRedact(in *C.VipsImage, rects []Rect, mode RedactMode) (*C.VipsImage, error) {
+	width := int(C.vips_image_get_width(in))
+	height := int(C.vips_image_get_height(in))
+	image := in
+	C.g_object_ref(C.gpointer(image))
+	for _, r := range rects {
+		left := clampInt(int(r.X*float64(width)), 0, width-1)
+		top := clampInt(int(r.Y*float64(height)), 0, height-1)
+		w := clampInt(int(math.Ceil(r.W*float64(width))), 1, width-left)
+		h := clampInt(int(math.Ceil(r.H*float64(height))), 1, height-top)
+		// about 8 blocks across the longest side
+		block := clampInt(maxInt(w, h)/8, 1, minInt(w, h))
+		prevImage := image
+		e := C.vips_redact_cgo(prevImage, &image, C.int(left), C.int(top), C.int(w), C.int(h),
+			C.int(block), C.int(btoi(mode == BLUR)))
+		C.g_object_unref(C.gpointer(prevImage))
+		if e != 0 {
+			return nil, vipsError()
+		}
+	}
+	return image, nil
+}
+
+func vipsMask(in *C.VipsImage, mask MaskType, radius int) (*C.VipsImage, error) {
+	svg := maskSVG(mask, radius, int(C.vips_image_get_width(in)), int(C.vips_image_get_height(in)))
+	var image *C.VipsImage
+	if C.vips_mask_cgo(in, &image, unsafe.Pointer(&svg[0]), C.size_t(len(svg))) != 0 {
+		return nil, vipsError()
+	}
+	return image, nil
+}
+
+func vipsGrayscale(in *C.VipsImage) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	err := C.vips_grayscale_cgo(in, &image)
+	if err != 0 {
+		return nil, vipsError()
+	}
+	return image, nil
+}
+
+func vipsResolution(in *C.VipsImage, dpi float64) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	err := C.vips_resolution_cgo(in, &image, C.double(dpi))
+	if err != 0 {
+		return nil, vipsError()
+	}
+	return image, nil
+}
+
+func vipsSaturation(in *C.VipsImage, saturation float64) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	err := C.vips_saturation_cgo(in, &image, C.double(saturation))
+	if err != 0 {
+		return nil, vipsError()
+	}
+	return image, nil
+}
+
+func vipsFilter(in *C.VipsImage, filter FilterType, colors [][]float64) (*C.VipsImage, error) {
+	var (
+		image *C.VipsImage
+		err   C.int
+	)
+	switch filter {
+	case SEPIA:
+		err = C.vips_sepia_cgo(in, &image)
+	case DUOTONE:
+		if len(colors) < 2 {
+			colors = [][]float64{duotoneShadows, duotoneHighlights}
+		}
+		err = C.vips_duotone_cgo(
+			in,
+			&image,
+			(*C.double)(&colors[0][0]),
+			(*C.double)(&colors[1][0]))
+	case TINT:
+		if len(colors) < 1 {
+			return nil, errors.New("tint without color")
+		}
+		err = C.vips_tint_cgo(in, &image, (*C.double)(&colors[0][0]))
+	case NEGATE:
+		err = C.vips_negate_cgo(in, &image)
+	}
+	if err != 0 {
+		return nil, vipsError()
+	}
+	return image, nil
+}
+
+func vipsFlip(in *C.VipsImage, flip bool, flop bool) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	err := C.vips_flip_cgo(in, &image, C.int(btoi(flip)), C.int(btoi(flop)))
+	if err != 0 {
+		return nil, vipsError()
+	}
+	return image, nil
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func vipsRotate(in *C.VipsImage, angle float64, bg []float64) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	err := C.vips_rotate_cgo(
+		in,
+		&image,
+		C.double(angle),
+		(*C.double)(&bg[0]),
+		C.int(len(bg)))
+	if err != 0 {
+		return nil, vipsError()
+	}
+	return image, nil
+}
+
+// opaque blends a translucent rgba color over white, for formats without
+// an alpha channel
+func opaque(rgba []float64) []float64 {
+	if len(rgba) < 4 {
+		return rgba
+	}
+	alpha := rgba[3] / 255
+	rgb := make([]float64, 3)
+	for i := range rgb {
+		rgb[i] = rgba[i]*alpha + 255*(1-alpha)
+	}
+	return rgb
+}
+
 func vipsImageNew(buf []byte) (*C.VipsImage, error) {
 	var image *C.VipsImage
 	err := C.vips_image_new_cgo(
@@ -196,11 +1278,36 @@ func vipsImageNew(buf []byte) (*C.VipsImage, error) {
 	return image, nil
 }
 
-func vipsThumbnail(buf []byte, width int, height int, gravity GravityType) (*C.VipsImage, error) {
-	smart := gravity == SMART
-	cSmart := C.int(0)
-	if smart {
-		cSmart = C.int(1)
+func vipsInteresting(gravity GravityType) int {
+	switch gravity {
+	case SMART:
+		return C.VIPS_INTERESTING_ATTENTION
+	case ENTROPY:
+		return C.VIPS_INTERESTING_ENTROPY
+	case noCrop:
+		return C.VIPS_INTERESTING_NONE
+	}
+	return C.VIPS_INTERESTING_CENTRE
+}
+
+func vipsThumbnail(buf []byte, options Options) (*C.VipsImage, error) {
+	return vipsThumbnailPages(buf, options, 1)
+}
+
+// vipsThumbnailPages loads pages frames of buf, -1 for all of them, stacked
+// vertically
+func vipsThumbnailPages(buf []byte, options Options, pages int) (*C.VipsImage, error) {
+	interesting := vipsInteresting(options.Gravity)
+	size := C.VIPS_SIZE_BOTH
+	switch {
+	case options.ResizeOp == FILL:
+		size = C.VIPS_SIZE_FORCE
+	case options.ResizeOp == INSIDE || options.NoEnlarge:
+		size = C.VIPS_SIZE_DOWN
+	}
+	cSRGB := C.int(0)
+	if options.ICC == ICCSRGB {
+		cSRGB = C.int(1)
 	}
 
 	var image *C.VipsImage
@@ -209,19 +1316,480 @@ func vipsThumbnail(buf []byte, width int, height int, gravity GravityType) (*C.V
 		unsafe.Pointer(&buf[0]),
 		C.size_t(len(buf)),
 		&image,
-		C.int(width),
-		C.int(height),
-		cSmart)
+		C.int(options.Width),
+		C.int(options.Height),
+		C.int(interesting),
+		C.int(size),
+		C.int(options.Page),
+		C.int(pages),
+		cSRGB)
+	if err != 0 {
+		return nil, vipsError()
+	}
+	return image, nil
+}
+
+// vipsMaxCoord is the largest dimension libvips handles, thumbnails of it
+// keep the size of the source
+const vipsMaxCoord = 10000000
+
+// precrop returns the auto-oriented page of buf cropped to options.Crop,
+// then trimmed and denoised, as a PNG the resize goes on from
+func precrop(buf []byte, options Options) ([]byte, error) {
+	full := options
+	full.Width, full.Height = vipsMaxCoord, vipsMaxCoord
+	full.ResizeOp, full.Gravity = INSIDE, noCrop
+	image, err := vipsThumbnail(buf, full)
+	if err != nil {
+		return nil, err
+	}
+	if area := options.Crop; area != nil {
+		left := clampInt(area.Left, 0, int(C.vips_image_get_width(image)))
+		top := clampInt(area.Top, 0, int(C.vips_image_get_height(image)))
+		right := clampInt(area.Left+area.Width, left, int(C.vips_image_get_width(image)))
+		bottom := clampInt(area.Top+area.Height, top, int(C.vips_image_get_height(image)))
+		if right == left || bottom == top {
+			C.g_object_unref(C.gpointer(image))
+			return nil, errors.New("crop outside of the image")
+		}
+		prevImage := image
+		if C.vips_extract_area_cgo(prevImage, &image, C.int(left), C.int(top), C.int(right-left), C.int(bottom-top)) != 0 {
+			err = vipsError()
+		}
+		C.g_object_unref(C.gpointer(prevImage))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.Trim {
+		prevImage := image
+		image, err = vipsTrim(prevImage, options.TrimThreshold)
+		C.g_object_unref(C.gpointer(prevImage))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.Denoise > 0 {
+		prevImage := image
+		image, err = vipsDenoise(prevImage, options.Denoise)
+		C.g_object_unref(C.gpointer(prevImage))
+		if err != nil {
+			return nil, err
+		}
+	}
+	C.vips_reset_orientation_cgo(image)
+	// metadata and profile are left to the final save
+	buf, err = vipsSave(PNG, image, Options{KeepMetadata: true})
+	C.g_object_unref(C.gpointer(image))
+	return buf, err
+}
+
+func vipsDenoise(in *C.VipsImage, size int) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	err := C.vips_denoise_cgo(in, &image, C.int(size))
 	if err != 0 {
 		return nil, vipsError()
 	}
 	return image, nil
 }
 
-func vipsSave(imageType ImageType, image *C.VipsImage) ([]byte, error) {
+// resample returns the auto-oriented page of buf resized with
+// options.Kernel to the size the thumbnail is made at, as a PNG the
+// thumbnail is then made of without resampling it again
+func resample(buf []byte, options Options) ([]byte, error) {
+	full := options
+	full.Width, full.Height = vipsMaxCoord, vipsMaxCoord
+	full.ResizeOp, full.Gravity = INSIDE, noCrop
+	image, err := vipsThumbnail(buf, full)
+	if err != nil {
+		return nil, err
+	}
+	width := float64(C.vips_image_get_width(image))
+	height := float64(C.vips_image_get_height(image))
+	hscale, vscale := float64(options.Width)/width, float64(options.Height)/height
+	switch options.ResizeOp {
+	case FILL:
+	case CROP:
+		hscale = math.Max(hscale, vscale) * math.Max(options.Zoom, 1)
+		vscale = hscale
+	default:
+		hscale = math.Min(hscale, vscale)
+		vscale = hscale
+	}
+	if options.NoEnlarge || options.ResizeOp == INSIDE {
+		hscale, vscale = math.Min(hscale, 1), math.Min(vscale, 1)
+	}
+	prevImage := image
+	if C.vips_resize_cgo(prevImage, &image, C.double(hscale), C.double(vscale), C.int(vipsKernels[options.Kernel])) != 0 {
+		err = vipsError()
+	}
+	C.g_object_unref(C.gpointer(prevImage))
+	if err != nil {
+		return nil, err
+	}
+	C.vips_reset_orientation_cgo(image)
+	// metadata and profile are left to the final save
+	buf, err = vipsSave(PNG, image, Options{KeepMetadata: true})
+	C.g_object_unref(C.gpointer(image))
+	return buf, err
+}
+
+func vipsTrim(in *C.VipsImage, threshold float64) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	err := C.vips_trim_cgo(in, &image, C.double(threshold))
+	if err != 0 {
+		return nil, vipsError()
+	}
+	return image, nil
+}
+
+// coverSize returns the smallest dimensions of buf, keeping its aspect
+// ratio, covering width x height
+func coverSize(buf []byte, width, height int) (int, int, error) {
+	iWidth, iHeight, err := sourceSize(buf)
+	if err != nil {
+		return 0, 0, err
+	}
+	scale := math.Max(float64(width)/float64(iWidth), float64(height)/float64(iHeight))
+	return int(math.Ceil(float64(iWidth) * scale)), int(math.Ceil(float64(iHeight) * scale)), nil
+}
+
+// zoomedCoverSize returns the dimensions crops are taken from, covering
+// the requested dimensions enlarged by options.Zoom
+func zoomedCoverSize(buf []byte, options Options) (int, int, error) {
+	zoom := math.Max(options.Zoom, 1)
+	width := int(math.Ceil(float64(options.Width) * zoom))
+	height := int(math.Ceil(float64(options.Height) * zoom))
+	return coverSize(buf, width, height)
+}
+
+// coverCrop resizes buf to cover the requested dimensions, zoomed, redacts
+// it, then crops it around options.Focal, or according to options.Gravity
+func coverCrop(buf []byte, options Options) (*C.VipsImage, error) {
+	cover := options
+	var err error
+	if cover.Width, cover.Height, err = zoomedCoverSize(buf, options); err != nil {
+		return nil, err
+	}
+	cover.Gravity = noCrop
+	image, err := vipsThumbnail(buf, cover)
+	if err != nil {
+		return nil, err
+	}
+	if len(options.Redact) > 0 {
+		prevImage := image
+		image, err = vipsRedact(prevImage, options.Redact, options.RedactMode)
+		C.g_object_unref(C.gpointer(prevImage))
+		if err != nil {
+			return nil, err
+		}
+	}
+	width := int(C.vips_image_get_width(image))
+	height := int(C.vips_image_get_height(image))
+	cropWidth, cropHeight := minInt(options.Width, width), minInt(options.Height, height)
+	if options.Focal == nil {
+		var cropped *C.VipsImage
+		e := C.vips_smartcrop_cgo(image, &cropped, C.int(cropWidth), C.int(cropHeight), C.int(vipsInteresting(options.Gravity)))
+		C.g_object_unref(C.gpointer(image))
+		if e != 0 {
+			return nil, vipsError()
+		}
+		return cropped, nil
+	}
+	cropped, err := focalExtract(image, options.Focal, cropWidth, cropHeight)
+	C.g_object_unref(C.gpointer(image))
+	return cropped, err
+}
+
+// focalExtract extracts width x height pixels of in centered on focal, as
+// far as its edges allow
+func focalExtract(in *C.VipsImage, focal *Point, width, height int) (*C.VipsImage, error) {
+	iWidth := int(C.vips_image_get_width(in))
+	iHeight := int(C.vips_image_get_height(in))
+	width, height = minInt(width, iWidth), minInt(height, iHeight)
+	left := clampInt(int(focal.X*float64(iWidth))-width/2, 0, iWidth-width)
+	top := clampInt(int(focal.Y*float64(iHeight))-height/2, 0, iHeight-height)
+	var image *C.VipsImage
+	if C.vips_extract_area_cgo(in, &image, C.int(left), C.int(top), C.int(width), C.int(height)) != 0 {
+		return nil, vipsError()
+	}
+	return image, nil
+}
+
+var (
+	maxFrames   int
+	maxDuration time.Duration
+)
+
+// SetAnimationLimits bounds the animations resized frame by frame, 0 for no
+// limit. Longer ones are resized to still images.
+func SetAnimationLimits(frames int, duration time.Duration) {
+	maxFrames = frames
+	maxDuration = duration
+}
+
+// animation returns the number of frames and the duration of animated GIFs
+// and WebPs, 1 frame for other images
+func animation(buf []byte) (int, time.Duration) {
+	t := GetImageType(buf)
+	if t != GIF && t != WEBP {
+		return 1, 0
+	}
+	var frames, duration C.int
+	if C.vips_animation_cgo(C.int(t), unsafe.Pointer(&buf[0]), C.size_t(len(buf)), &frames, &duration) != 0 {
+		vipsError()
+		return 1, 0
+	}
+	return int(frames), time.Duration(duration) * time.Millisecond
+}
+
+var (
+	maxSourcePixels int
+	maxSourceFrames int
+)
+
+// ErrSourceTooLarge is returned for sources over the limits of
+// SetSourceLimits
+var ErrSourceTooLarge = errors.New("source too large")
+
+// SetSourceLimits bounds the pixels of a page and the number of frames or
+// pages of sources, 0 for no limit. Compressed files may decode to far more
+// memory than their size suggests.
+func SetSourceLimits(pixels, frames int) {
+	maxSourcePixels = pixels
+	maxSourceFrames = frames
+}
+
+// checkSource refuses buf with ErrSourceTooLarge when its header declares
+// more pixels or frames than the limits, before anything is decoded, and
+// SVGs failing checkSVG. Every decode of an original goes through it.
+func checkSource(buf []byte) error {
+	if GetImageType(buf) == SVG {
+		if err := checkSVG(buf); err != nil {
+			return err
+		}
+	}
+	if maxSourcePixels <= 0 && maxSourceFrames <= 0 {
+		return nil
+	}
+	image, err := vipsImageNew(buf)
+	if err != nil {
+		return err
+	}
+	defer C.g_object_unref(C.gpointer(image))
+	width := int64(C.vips_image_get_width(image))
+	height := int64(C.vips_image_get_height(image))
+	if maxSourcePixels > 0 && width*height > int64(maxSourcePixels) {
+		return ErrSourceTooLarge
+	}
+	if maxSourceFrames > 0 && int(C.vips_image_get_n_pages(image)) > maxSourceFrames {
+		return ErrSourceTooLarge
+	}
+	return nil
+}
+
+// resizeAnimated resizes every frame of an animated image. Crops keep the
+// same area in all frames, centered or around options.Focal since content
+// aware crops would jitter.
+func resizeAnimated(buf []byte, options Options, format ImageType, origOWidth, origOHeight int) ([]byte, error) {
+	thumb := options
+	if options.ResizeOp == CROP {
+		var err error
+		if thumb.Width, thumb.Height, err = zoomedCoverSize(buf, options); err != nil {
+			return nil, err
+		}
+		thumb.Gravity = noCrop
+		if options.Focal == nil {
+			options.Focal = &Point{X: 0.5, Y: 0.5}
+		}
+	}
+	image, err := vipsThumbnailPages(buf, thumb, -1)
+	if err != nil {
+		return nil, err
+	}
+	width := int(C.vips_image_get_width(image))
+	pageHeight := int(C.vips_image_get_page_height(image))
+	frames := make([]*C.VipsImage, 0, int(C.vips_image_get_height(image))/pageHeight)
+	defer func() {
+		for _, frame := range frames {
+			C.g_object_unref(C.gpointer(frame))
+		}
+	}()
+	for top := 0; top+pageHeight <= int(C.vips_image_get_height(image)); top += pageHeight {
+		var frame *C.VipsImage
+		if C.vips_extract_area_cgo(image, &frame, 0, C.int(top), C.int(width), C.int(pageHeight)) != 0 {
+			C.g_object_unref(C.gpointer(image))
+			return nil, vipsError()
+		}
+		if len(options.Redact) > 0 {
+			prevFrame := frame
+			frame, err = vipsRedact(prevFrame, options.Redact, options.RedactMode)
+			C.g_object_unref(C.gpointer(prevFrame))
+		}
+		if err == nil && options.ResizeOp == CROP {
+			prevFrame := frame
+			frame, err = focalExtract(prevFrame, options.Focal, options.Width, options.Height)
+			C.g_object_unref(C.gpointer(prevFrame))
+		}
+		if err == nil {
+			frame, err = postprocess(frame, options, format, origOWidth, origOHeight)
+		}
+		if err != nil {
+			C.g_object_unref(C.gpointer(image))
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+	C.g_object_unref(C.gpointer(image))
+	if len(frames) == 0 {
+		return nil, errors.New("animation without frames")
+	}
+
+	var animated *C.VipsImage
+	// frames holds C pointers only, which cgo allows passing
+	if C.vips_join_pages_cgo((**C.VipsImage)(unsafe.Pointer(&frames[0])), C.int(len(frames)), &animated) != 0 {
+		return nil, vipsError()
+	}
+	thumbBuf, err := saveWithin(format, animated, options)
+	C.g_object_unref(C.gpointer(animated))
+	return thumbBuf, err
+}
+
+// findFaces returns the center of the faces in buf, or nil when there are
+// none or face detection isn't set up
+func findFaces(buf []byte) *Point {
+	if faceClassifier == nil {
+		return nil
+	}
+	var (
+		ptr           unsafe.Pointer
+		width, height C.int
+	)
+	if C.vips_gray_pixels_cgo(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), C.int(faceDetectionSize),
+		&ptr, &width, &height) != 0 {
+		vipsError()
+		return nil
+	}
+	pixels := C.GoBytes(ptr, width*height)
+	C.g_free(C.gpointer(ptr))
+	return detectFaces(pixels, int(width), int(height))
+}
+
+// rgbPixels decodes buf downsized to fit in size x size, as 8 bit sRGB
+// pixels, transparent areas turned white
+func rgbPixels(buf []byte, size int) ([]byte, int, int, error) {
+	if err := checkSource(buf); err != nil {
+		return nil, 0, 0, err
+	}
+	var (
+		ptr           unsafe.Pointer
+		width, height C.int
+	)
+	if C.vips_rgb_pixels_cgo(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), C.int(size),
+		&ptr, &width, &height) != 0 {
+		return nil, 0, 0, vipsError()
+	}
+	pixels := C.GoBytes(ptr, 3*width*height)
+	C.g_free(C.gpointer(ptr))
+	return pixels, int(width), int(height), nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// minBudgetQuality is the lowest quality Options.MaxBytes lowers
+// thumbnails to, the smallest result is served when even it doesn't fit
+const minBudgetQuality = 10
+
+// saveWithin saves image, then searches for the highest quality fitting in
+// options.MaxBytes when it doesn't fit
+func saveWithin(format ImageType, image *C.VipsImage, options Options) ([]byte, error) {
+	if options.MaxBytes <= 0 || options.Lossless || (format != JPEG && format != WEBP && format != AVIF) {
+		return vipsSave(format, image, options)
+	}
+	// computed once rather than for every attempt
+	image = C.vips_image_copy_memory(image)
+	if image == nil {
+		return nil, vipsError()
+	}
+	defer C.g_object_unref(C.gpointer(image))
+	buf, err := vipsSave(format, image, options)
+	if err != nil || len(buf) <= options.MaxBytes {
+		return buf, err
+	}
+	high := options.Quality
+	if high == 0 {
+		// the defaults of vips_save_buffer_cgo
+		high = 75
+		if format == AVIF {
+			high = 50
+		}
+	}
+	smallest, fits := buf, false
+	for low := minBudgetQuality; low < high; {
+		options.Quality = (low + high) / 2
+		attempt, err := vipsSave(format, image, options)
+		if err != nil {
+			return nil, err
+		}
+		if len(attempt) <= options.MaxBytes {
+			buf, fits = attempt, true
+			low = options.Quality + 1
+		} else {
+			high = options.Quality
+		}
+		if len(attempt) < len(smallest) {
+			smallest = attempt
+		}
+	}
+	if !fits {
+		return smallest, nil
+	}
+	return buf, nil
+}
+
+func vipsSave(imageType ImageType, image *C.VipsImage, options Options) ([]byte, error) {
 	var ptr unsafe.Pointer
 	length := C.size_t(0)
-	err := C.vips_save_buffer_cgo(C.int(imageType), image, &ptr, &length)
+	saveOptions := C.SaveOptions{
+		quality: C.int(options.Quality),
+		effort:  C.int(options.Effort),
+	}
+	if options.Progressive {
+		saveOptions.progressive = 1
+	}
+	if options.Lossless {
+		saveOptions.lossless = 1
+	}
+	if options.NearLossless {
+		saveOptions.near_lossless = 1
+	}
+	if !options.KeepMetadata && options.ICC != ICCKeep {
+		// embedded profiles were either converted or stripped already
+		saveOptions.strip = 1
+	}
+	err := C.vips_save_buffer_cgo(C.int(imageType), image, &ptr, &length, &saveOptions)
 	if err != 0 {
 		return nil, vipsError()
 	}
@@ -1,31 +1,102 @@
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
 #include "vips/vips.h"
 
 enum imageTypes {
     UNKNOWN = 0,
     JPEG,
-    PNG
+    PNG,
+    WEBP,
+    AVIF,
+    GIF,
+    MP4,
+    WEBM,
+    PDF,
+    SVG,
+    HEIC,
+    TIFF
 };
 
-int vips_save_buffer_cgo(int imageType, VipsImage *in, void **buf, size_t *len) {
+// encoder settings, zero values leave the libvips defaults
+typedef struct {
+    int quality;
+    int effort;
+    int progressive;
+    int strip;
+    int lossless;
+    int near_lossless;
+} SaveOptions;
+
+int vips_save_buffer_cgo(int imageType, VipsImage *in, void **buf, size_t *len, SaveOptions *opts) {
     int err = 1;
     switch (imageType) {
     case JPEG:
         err = vips_jpegsave_buffer(in, buf, len,
+            "Q", opts->quality > 0 ? opts->quality : 75,
             "optimize_coding", TRUE,
-            "strip", TRUE,
+            "interlace", opts->progressive,
+            "strip", opts->strip,
             NULL);
         break;
     case PNG:
-         err = vips_pngsave_buffer(in, buf, len, NULL);
+         err = vips_pngsave_buffer(in, buf, len,
+             "compression", opts->effort > 0 ? opts->effort : 6,
+             "interlace", opts->progressive,
+             "strip", opts->strip,
+             NULL);
          break;
+    case WEBP:
+        err = vips_webpsave_buffer(in, buf, len,
+            "Q", opts->quality > 0 ? opts->quality : 75,
+            "effort", opts->effort > 0 ? opts->effort : 4,
+            "lossless", opts->lossless,
+            "near_lossless", opts->near_lossless,
+            "strip", opts->strip,
+            NULL);
+        break;
+    case AVIF:
+        err = vips_heifsave_buffer(in, buf, len,
+            "compression", VIPS_FOREIGN_HEIF_COMPRESSION_AV1,
+            "Q", opts->quality > 0 ? opts->quality : 50,
+            "effort", opts->effort,
+            "lossless", opts->lossless,
+            "strip", opts->strip,
+            NULL);
+        break;
+    case GIF:
+        err = vips_gifsave_buffer(in, buf, len, "strip", opts->strip, NULL);
+        break;
     }
     return err;
 }
 
-int vips_thumbnail_cgo(void *buf, size_t len, VipsImage **out, int width, int height, int smart) {
-    VipsInteresting crop = VIPS_INTERESTING_CENTRE;
-    if (smart > 0) {
-        crop = VIPS_INTERESTING_ATTENTION;
+// vips_thumbnail_cgo loads the given page of buf, or all of them stacked
+// vertically when pages is -1
+int vips_thumbnail_cgo(void *buf, size_t len, VipsImage **out, int width, int height, int interesting, int size, int page, int pages, int srgb) {
+    VipsInteresting crop = interesting;
+    VipsSize vsize = size;
+    // loaders without pages reject these options
+    char option_string[32] = "";
+    if (pages < 0) {
+        strcpy(option_string, "n=-1");
+    } else if (page > 0) {
+        snprintf(option_string, sizeof(option_string), "page=%d", page);
+    }
+    if (srgb > 0) {
+        // images with an embedded profile are converted to sRGB
+        return vips_thumbnail_buffer(
+            buf,
+            len,
+            out,
+            width,
+            "height", height,
+            "crop", crop,
+            "size", vsize,
+            "option_string", option_string,
+            "intent", VIPS_INTENT_PERCEPTUAL,
+            "export_profile", "srgb",
+            NULL);
     }
     return vips_thumbnail_buffer(
         buf,
@@ -34,10 +105,26 @@ int vips_thumbnail_cgo(void *buf, size_t len, VipsImage **out, int width, int he
         width,
         "height", height,
         "crop", crop,
+        "size", vsize,
+        "option_string", option_string,
         "intent", VIPS_INTENT_PERCEPTUAL,
         NULL);
 }
 
+// vips_strip_cgo removes the EXIF, XMP and IPTC metadata of in but keeps
+// its ICC profile, which the "strip" save option would drop
+void vips_strip_cgo(VipsImage *in) {
+    gchar **fields = vips_image_get_fields(in);
+    for (int i = 0; fields[i] != NULL; i++) {
+        if (strncmp(fields[i], "exif-", 5) == 0 ||
+            strcmp(fields[i], "xmp-data") == 0 ||
+            strcmp(fields[i], "iptc-data") == 0) {
+            vips_image_remove(in, fields[i]);
+        }
+    }
+    g_strfreev(fields);
+}
+
 int vips_image_new_cgo(int imageType, void *buf, size_t len, VipsImage **out) {
     int err = 1;
     switch (imageType) {
@@ -47,14 +134,581 @@ int vips_image_new_cgo(int imageType, void *buf, size_t len, VipsImage **out) {
     case PNG:
          err = vips_pngload_buffer(buf, len, out, "access", VIPS_ACCESS_SEQUENTIAL, NULL);
          break;
+    case WEBP:
+        err = vips_webpload_buffer(buf, len, out, "access", VIPS_ACCESS_SEQUENTIAL, NULL);
+        break;
+    case AVIF:
+    case HEIC:
+        err = vips_heifload_buffer(buf, len, out, "access", VIPS_ACCESS_SEQUENTIAL, NULL);
+        break;
+    case GIF:
+        err = vips_gifload_buffer(buf, len, out, "access", VIPS_ACCESS_SEQUENTIAL, NULL);
+        break;
+    case PDF:
+        err = vips_pdfload_buffer(buf, len, out, "access", VIPS_ACCESS_SEQUENTIAL, NULL);
+        break;
+    case SVG:
+        err = vips_svgload_buffer(buf, len, out, "access", VIPS_ACCESS_SEQUENTIAL, NULL);
+        break;
+    case TIFF:
+        err = vips_tiffload_buffer(buf, len, out, "access", VIPS_ACCESS_SEQUENTIAL, NULL);
+        break;
+    }
+    return err;
+}
+
+// vips_orientation_cgo returns the EXIF orientation of in, 1 when unset
+int vips_orientation_cgo(VipsImage *in) {
+    int orientation = 1;
+    if (vips_image_get_typeof(in, VIPS_META_ORIENTATION)) {
+        vips_image_get_int(in, VIPS_META_ORIENTATION, &orientation);
+    }
+    return orientation;
+}
+
+// vips_thumbnail_buffer rotates upright already, the tag must go so viewers
+// don't rotate again
+void vips_reset_orientation_cgo(VipsImage *in) {
+    vips_image_remove(in, VIPS_META_ORIENTATION);
+}
+
+int vips_extract_area_cgo(VipsImage *in, VipsImage **out, int left, int top, int width, int height) {
+    return vips_extract_area(in, out, left, top, width, height, NULL);
+}
+
+// vips_animation_cgo reads the number of frames and the total duration in
+// milliseconds of the animation in buf from its header
+int vips_animation_cgo(int imageType, void *buf, size_t len, int *frames, int *duration) {
+    VipsImage *image;
+    int err = 1;
+    if (imageType == GIF) {
+        err = vips_gifload_buffer(buf, len, &image, "n", -1, NULL);
+    } else if (imageType == WEBP) {
+        err = vips_webpload_buffer(buf, len, &image, "n", -1, NULL);
+    }
+    if (err) {
+        return err;
+    }
+    *frames = vips_image_get_n_pages(image);
+    *duration = 0;
+    int *delays, n;
+    if (vips_image_get_typeof(image, "delay") &&
+        !vips_image_get_array_int(image, "delay", &delays, &n)) {
+        for (int i = 0; i < n; i++) {
+            *duration += delays[i];
+        }
+    }
+    g_object_unref(image);
+    return 0;
+}
+
+// vips_join_pages_cgo stacks the n frames of an animation vertically
+int vips_join_pages_cgo(VipsImage **frames, int n, VipsImage **out) {
+    VipsImage *joined;
+    if (vips_arrayjoin(frames, &joined, n, "across", 1, NULL)) {
+        return 1;
+    }
+    int err = vips_copy(joined, out, NULL);
+    g_object_unref(joined);
+    if (!err) {
+        vips_image_set_int(*out, "page-height", vips_image_get_height(frames[0]));
+    }
+    return err;
+}
+
+int vips_smartcrop_cgo(VipsImage *in, VipsImage **out, int width, int height, int interesting) {
+    return vips_smartcrop(in, out, width, height, "interesting", interesting, NULL);
+}
+
+// vips_redact_cgo pixelates, in blocks of block pixels, or blurs an area of in
+int vips_redact_cgo(VipsImage *in, VipsImage **out, int left, int top, int width, int height, int block, int blur) {
+    VipsImage *region, *redacted;
+    if (vips_extract_area(in, &region, left, top, width, height, NULL)) {
+        return 1;
+    }
+    int err;
+    if (blur) {
+        err = vips_gaussblur(region, &redacted, block, NULL);
+    } else {
+        VipsImage *small, *zoomed;
+        err = vips_shrink(region, &small, block, block, NULL);
+        if (!err) {
+            // zoomed back to at least the size of the area
+            int xfac = (width + vips_image_get_width(small) - 1) / vips_image_get_width(small);
+            int yfac = (height + vips_image_get_height(small) - 1) / vips_image_get_height(small);
+            err = vips_zoom(small, &zoomed, xfac, yfac, NULL);
+            g_object_unref(small);
+        }
+        if (!err) {
+            err = vips_extract_area(zoomed, &redacted, 0, 0, width, height, NULL);
+            g_object_unref(zoomed);
+        }
+    }
+    g_object_unref(region);
+    if (err) {
+        return err;
+    }
+    err = vips_insert(in, redacted, out, left, top, NULL);
+    g_object_unref(redacted);
+    return err;
+}
+
+// vips_gray_pixels_cgo decodes buf downsized to fit in size x size, as 8 bit
+// grayscale pixels to be freed with g_free
+int vips_gray_pixels_cgo(void *buf, size_t len, int size, void **pixels, int *width, int *height) {
+    VipsImage *thumb, *gray, *band;
+    if (vips_thumbnail_buffer(buf, len, &thumb, size, "height", size, NULL)) {
+        return 1;
+    }
+    int err = vips_colourspace(thumb, &gray, VIPS_INTERPRETATION_B_W, NULL);
+    g_object_unref(thumb);
+    if (err) {
+        return err;
+    }
+    err = vips_extract_band(gray, &band, 0, NULL);
+    g_object_unref(gray);
+    if (err) {
+        return err;
+    }
+    VipsImage *uchar;
+    err = vips_cast_uchar(band, &uchar, NULL);
+    g_object_unref(band);
+    if (err) {
+        return err;
+    }
+    size_t n;
+    *pixels = vips_image_write_to_memory(uchar, &n);
+    *width = vips_image_get_width(uchar);
+    *height = vips_image_get_height(uchar);
+    g_object_unref(uchar);
+    return *pixels == NULL;
+}
+
+// vips_rgb_pixels_cgo decodes buf downsized to fit in size x size, as 8 bit
+// sRGB pixels flattened against white, to be freed with g_free
+int vips_rgb_pixels_cgo(void *buf, size_t len, int size, void **pixels, int *width, int *height) {
+    VipsImage *thumb, *srgb, *flat, *uchar;
+    if (vips_thumbnail_buffer(buf, len, &thumb, size, "height", size, NULL)) {
+        return 1;
+    }
+    int err = vips_colourspace(thumb, &srgb, VIPS_INTERPRETATION_sRGB, NULL);
+    g_object_unref(thumb);
+    if (err) {
+        return err;
+    }
+    if (vips_image_hasalpha(srgb)) {
+        double white[3] = {255, 255, 255};
+        VipsArrayDouble *background = vips_array_double_new(white, 3);
+        err = vips_flatten(srgb, &flat, "background", background, NULL);
+        vips_area_unref(VIPS_AREA(background));
+    } else {
+        err = vips_extract_band(srgb, &flat, 0, "n", 3, NULL);
+    }
+    g_object_unref(srgb);
+    if (err) {
+        return err;
+    }
+    err = vips_cast_uchar(flat, &uchar, NULL);
+    g_object_unref(flat);
+    if (err) {
+        return err;
+    }
+    size_t n;
+    *pixels = vips_image_write_to_memory(uchar, &n);
+    *width = vips_image_get_width(uchar);
+    *height = vips_image_get_height(uchar);
+    g_object_unref(uchar);
+    return *pixels == NULL;
+}
+
+int vips_gaussblur_cgo(VipsImage *in, VipsImage **out, double sigma) {
+    return vips_gaussblur(in, out, sigma, NULL);
+}
+
+int vips_sharpen_cgo(VipsImage *in, VipsImage **out, double sigma) {
+    return vips_sharpen(in, out, "sigma", sigma, NULL);
+}
+
+// vips_adjust_cgo applies brightness, contrast and gamma to the 8 bit in,
+// leaving its alpha channel alone
+int vips_adjust_cgo(VipsImage *in, VipsImage **out, double brightness, double contrast, double gamma) {
+    int bands = vips_image_get_bands(in);
+    if (bands > 4) {
+        vips_error("vips_adjust_cgo", "too many bands");
+        return 1;
+    }
+    double a[4], b[4], e[4];
+    for (int i = 0; i < 4; i++) {
+        a[i] = contrast;
+        b[i] = 128 * (1 - contrast) + brightness;
+        e[i] = 1 / gamma;
+    }
+    if (vips_image_hasalpha(in)) {
+        a[bands - 1] = 1;
+        b[bands - 1] = 0;
+        e[bands - 1] = 1;
+    }
+    if (gamma == 1) {
+        return vips_linear(in, out, a, b, bands, "uchar", TRUE, NULL);
+    }
+    VipsImage *linear, *norm, *corrected;
+    if (vips_linear(in, &linear, a, b, bands, "uchar", TRUE, NULL)) {
+        return 1;
+    }
+    int err = vips_linear1(linear, &norm, 1.0 / 255, 0, NULL);
+    g_object_unref(linear);
+    if (err) {
+        return err;
+    }
+    err = vips_math2_const(norm, &corrected, VIPS_OPERATION_MATH2_POW, e, bands, NULL);
+    g_object_unref(norm);
+    if (err) {
+        return err;
+    }
+    err = vips_linear1(corrected, out, 255, 0, "uchar", TRUE, NULL);
+    g_object_unref(corrected);
+    return err;
+}
+
+// vips_resize_cgo resamples in by hscale horizontally and vscale
+// vertically with kernel
+int vips_resize_cgo(VipsImage *in, VipsImage **out, double hscale, double vscale, int kernel) {
+    return vips_resize(in, out, hscale, "vscale", vscale, "kernel", kernel, NULL);
+}
+
+// vips_denoise_cgo replaces the pixels of in by the median of the size x
+// size window around them, removing speckles while keeping edges
+int vips_denoise_cgo(VipsImage *in, VipsImage **out, int size) {
+    return vips_median(in, out, size, NULL);
+}
+
+// vips_trim_cgo crops the borders of in of the color of its top left pixel,
+// or the transparent ones of images with an alpha channel
+int vips_trim_cgo(VipsImage *in, VipsImage **out, double threshold) {
+    VipsImage *search = in;
+    if (vips_image_hasalpha(in) &&
+        vips_extract_band(in, &search, vips_image_get_bands(in) - 1, NULL)) {
+        return 1;
+    }
+    double *pixel;
+    int n;
+    if (vips_getpoint(search, &pixel, &n, 0, 0, NULL)) {
+        if (search != in) {
+            g_object_unref(search);
+        }
+        return 1;
+    }
+    VipsArrayDouble *background = vips_array_double_new(pixel, n);
+    g_free(pixel);
+    int left, top, width, height;
+    int err = vips_find_trim(search, &left, &top, &width, &height,
+        "threshold", threshold,
+        "background", background,
+        NULL);
+    vips_area_unref(VIPS_AREA(background));
+    if (search != in) {
+        g_object_unref(search);
+    }
+    if (err) {
+        return err;
+    }
+    if (width == 0 || height == 0) {
+        // nothing but border
+        return vips_copy(in, out, NULL);
+    }
+    return vips_extract_area(in, out, left, top, width, height, NULL);
+}
+
+// vips_overlay_load_cgo loads buf resized to width, or at its own size
+// when width is 0, with an alpha channel multiplied by opacity
+int vips_overlay_load_cgo(void *buf, size_t len, VipsImage **out, int width, double opacity) {
+    VipsImage *thumb, *alpha;
+    VipsSize size = width > 0 ? VIPS_SIZE_BOTH : VIPS_SIZE_DOWN;
+    if (vips_thumbnail_buffer(buf, len, &thumb, width > 0 ? width : 10000000,
+            "height", 10000000, "size", size, NULL)) {
+        return 1;
+    }
+    if (!vips_image_hasalpha(thumb)) {
+        int err = vips_addalpha(thumb, &alpha, NULL);
+        g_object_unref(thumb);
+        if (err) {
+            return err;
+        }
+        thumb = alpha;
+    }
+    int bands = vips_image_get_bands(thumb);
+    if (opacity >= 1 || bands > 4) {
+        *out = thumb;
+        return 0;
+    }
+    double a[4] = {1, 1, 1, 1};
+    double b[4] = {0, 0, 0, 0};
+    a[bands - 1] = opacity;
+    int err = vips_linear(thumb, out, a, b, bands, "uchar", TRUE, NULL);
+    g_object_unref(thumb);
+    return err;
+}
+
+// vips_text_overlay_cgo renders text wrapped at width, in the rgba color
+int vips_text_overlay_cgo(VipsImage **out, const char *text, const char *font, int width, double *rgba) {
+    VipsImage *mask, *color, *alpha, *joined;
+    double zero[3] = {0, 0, 0};
+    if (vips_text(&mask, text, "font", font, "width", width, "dpi", 72, NULL)) {
+        return 1;
+    }
+    // the mask is the coverage of the glyphs
+    int err = vips_linear(mask, &color, zero, rgba, 3, "uchar", TRUE, NULL);
+    if (err) {
+        g_object_unref(mask);
+        return err;
+    }
+    err = vips_linear1(mask, &alpha, rgba[3] / 255, 0, "uchar", TRUE, NULL);
+    g_object_unref(mask);
+    if (err) {
+        g_object_unref(color);
+        return err;
+    }
+    err = vips_bandjoin2(color, alpha, &joined, NULL);
+    g_object_unref(color);
+    g_object_unref(alpha);
+    if (err) {
+        return err;
+    }
+    err = vips_copy(joined, out, "interpretation", VIPS_INTERPRETATION_sRGB, NULL);
+    g_object_unref(joined);
+    return err;
+}
+
+int vips_composite_cgo(VipsImage *base, VipsImage *overlay, VipsImage **out, int x, int y, int mode) {
+    return vips_composite2(base, overlay, out, mode, "x", x, "y", y, NULL);
+}
+
+// vips_mask_cgo keeps in where the svg is opaque
+int vips_mask_cgo(VipsImage *in, VipsImage **out, void *svg, size_t len) {
+    VipsImage *mask;
+    if (vips_svgload_buffer(svg, len, &mask, NULL)) {
+        return 1;
+    }
+    int err = vips_composite2(in, mask, out, VIPS_BLEND_MODE_DEST_IN, NULL);
+    g_object_unref(mask);
+    return err;
+}
+
+int vips_grayscale_cgo(VipsImage *in, VipsImage **out) {
+    return vips_colourspace(in, out, VIPS_INTERPRETATION_B_W, NULL);
+}
+
+// vips_resolution_cgo sets the resolution of in, libvips wants pixels per
+// millimeter
+int vips_resolution_cgo(VipsImage *in, VipsImage **out, double dpi) {
+    return vips_copy(in, out, "xres", dpi / 25.4, "yres", dpi / 25.4, NULL);
+}
+
+// vips_saturation_cgo multiplies the chroma of in, going through LCh
+int vips_saturation_cgo(VipsImage *in, VipsImage **out, double saturation) {
+    VipsImage *lch, *scaled;
+    if (vips_colourspace(in, &lch, VIPS_INTERPRETATION_LCH, NULL)) {
+        return 1;
+    }
+    // L, C, h and alpha, if any
+    double a[4] = {1, saturation, 1, 1};
+    double b[4] = {0, 0, 0, 0};
+    int bands = vips_image_get_bands(lch);
+    int err = vips_linear(lch, &scaled, a, b, bands < 4 ? bands : 4, NULL);
+    g_object_unref(lch);
+    if (err) {
+        return err;
+    }
+    err = vips_colourspace(scaled, out, VIPS_INTERPRETATION_sRGB, NULL);
+    g_object_unref(scaled);
+    return err;
+}
+
+// vips_split_alpha_cgo converts in to 8 bit sRGB, returning its color bands
+// and, if any, its alpha band
+int vips_split_alpha_cgo(VipsImage *in, VipsImage **color, VipsImage **alpha) {
+    VipsImage *srgb;
+    *alpha = NULL;
+    if (vips_colourspace(in, &srgb, VIPS_INTERPRETATION_sRGB, NULL)) {
+        return 1;
+    }
+    int err = vips_extract_band(srgb, color, 0, "n", 3, NULL);
+    if (!err && vips_image_hasalpha(srgb)) {
+        err = vips_extract_band(srgb, alpha, 3, NULL);
+        if (err) {
+            g_object_unref(*color);
+        }
+    }
+    g_object_unref(srgb);
+    return err;
+}
+
+// vips_join_alpha_cgo casts the color bands of a filter back to 8 bit sRGB
+// and joins them with alpha, if any, taking ownership of both
+int vips_join_alpha_cgo(VipsImage *color, VipsImage *alpha, VipsImage **out) {
+    VipsImage *uchar, *srgb;
+    int err = vips_cast_uchar(color, &uchar, NULL);
+    g_object_unref(color);
+    if (!err) {
+        err = vips_copy(uchar, &srgb, "interpretation", VIPS_INTERPRETATION_sRGB, NULL);
+        g_object_unref(uchar);
+    }
+    if (!err && alpha) {
+        err = vips_bandjoin2(srgb, alpha, out, NULL);
+        g_object_unref(srgb);
+    } else if (!err) {
+        *out = srgb;
+    }
+    if (alpha) {
+        g_object_unref(alpha);
     }
     return err;
 }
 
-int vips_embed_background_cgo(VipsImage *in, VipsImage **out, int x, int y, int width, int height, double *bg) {
+// vips_sepia_cgo tones in brown, the way old photographs faded
+int vips_sepia_cgo(VipsImage *in, VipsImage **out) {
+    VipsImage *color, *alpha, *toned;
+    if (vips_split_alpha_cgo(in, &color, &alpha)) {
+        return 1;
+    }
+    VipsImage *matrix = vips_image_new_matrix_from_array(3, 3, (double[]){
+        0.393, 0.769, 0.189,
+        0.349, 0.686, 0.168,
+        0.272, 0.534, 0.131,
+    }, 9);
+    int err = vips_recomb(color, &toned, matrix, NULL);
+    g_object_unref(matrix);
+    g_object_unref(color);
+    if (err) {
+        if (alpha) {
+            g_object_unref(alpha);
+        }
+        return err;
+    }
+    return vips_join_alpha_cgo(toned, alpha, out);
+}
+
+// vips_duotone_cgo maps the luminance of in from the rgb shadows color to
+// the rgb highlights one
+int vips_duotone_cgo(VipsImage *in, VipsImage **out, double *shadows, double *highlights) {
+    VipsImage *color, *alpha, *gray, *toned;
+    if (vips_split_alpha_cgo(in, &color, &alpha)) {
+        return 1;
+    }
+    int err = vips_colourspace(color, &gray, VIPS_INTERPRETATION_B_W, NULL);
+    g_object_unref(color);
+    if (!err) {
+        double a[3], b[3];
+        for (int i = 0; i < 3; i++) {
+            a[i] = (highlights[i] - shadows[i]) / 255;
+            b[i] = shadows[i];
+        }
+        // a single band image gets a band per constant
+        err = vips_linear(gray, &toned, a, b, 3, NULL);
+        g_object_unref(gray);
+    }
+    if (err) {
+        if (alpha) {
+            g_object_unref(alpha);
+        }
+        return err;
+    }
+    return vips_join_alpha_cgo(toned, alpha, out);
+}
+
+// vips_tint_cgo multiplies in by the rgb color, white turning into it
+int vips_tint_cgo(VipsImage *in, VipsImage **out, double *rgb) {
+    VipsImage *color, *alpha, *tinted;
+    if (vips_split_alpha_cgo(in, &color, &alpha)) {
+        return 1;
+    }
+    double a[3], b[3] = {0, 0, 0};
+    for (int i = 0; i < 3; i++) {
+        a[i] = rgb[i] / 255;
+    }
+    int err = vips_linear(color, &tinted, a, b, 3, NULL);
+    g_object_unref(color);
+    if (err) {
+        if (alpha) {
+            g_object_unref(alpha);
+        }
+        return err;
+    }
+    return vips_join_alpha_cgo(tinted, alpha, out);
+}
+
+// vips_negate_cgo inverts the colors of in
+int vips_negate_cgo(VipsImage *in, VipsImage **out) {
+    VipsImage *color, *alpha, *inverted;
+    if (vips_split_alpha_cgo(in, &color, &alpha)) {
+        return 1;
+    }
+    int err = vips_invert(color, &inverted, NULL);
+    g_object_unref(color);
+    if (err) {
+        if (alpha) {
+            g_object_unref(alpha);
+        }
+        return err;
+    }
+    return vips_join_alpha_cgo(inverted, alpha, out);
+}
+
+// vips_flip_cgo mirrors in vertically when flip is set and horizontally
+// when flop is set
+int vips_flip_cgo(VipsImage *in, VipsImage **out, int flip, int flop) {
+    if (flip && flop) {
+        // mirroring both ways is a half turn
+        return vips_rot(in, out, VIPS_ANGLE_D180, NULL);
+    }
+    if (flip) {
+        return vips_flip(in, out, VIPS_DIRECTION_VERTICAL, NULL);
+    }
+    return vips_flip(in, out, VIPS_DIRECTION_HORIZONTAL, NULL);
+}
+
+// vips_rotate_cgo rotates in clockwise, losslessly for multiples of 90
+// degrees. The corners uncovered by other angles are filled with the rgb or
+// rgba bg.
+int vips_rotate_cgo(VipsImage *in, VipsImage **out, double angle, double *bg, int n) {
     int err = 1;
-    VipsArrayDouble *background = vips_array_double_new(bg, 3);
-    err = vips_embed(in, out, x, y, width, height, "extend", VIPS_EXTEND_BACKGROUND, "background", background, NULL);
+    if (angle == 90) {
+        return vips_rot(in, out, VIPS_ANGLE_D90, NULL);
+    }
+    if (angle == 180) {
+        return vips_rot(in, out, VIPS_ANGLE_D180, NULL);
+    }
+    if (angle == 270) {
+        return vips_rot(in, out, VIPS_ANGLE_D270, NULL);
+    }
+    VipsImage *base = in;
+    double color[4] = {bg[0], bg[1], bg[2], n > 3 ? bg[3] : 255};
+    if (n > 3 && !vips_image_hasalpha(in) && vips_addalpha(in, &base, NULL)) {
+        return err;
+    }
+    VipsArrayDouble *background = vips_array_double_new(color, vips_image_hasalpha(base) ? 4 : 3);
+    err = vips_rotate(base, out, angle, "background", background, NULL);
     vips_area_unref(VIPS_AREA(background));
+    if (base != in) {
+        g_object_unref(base);
+    }
+    return err;
+}
+
+// vips_embed_background_cgo takes an rgb or rgba background, an alpha
+// channel is added to in for the latter
+int vips_embed_background_cgo(VipsImage *in, VipsImage **out, int x, int y, int width, int height, double *bg, int n) {
+    int err = 1;
+    VipsImage *base = in;
+    double color[4] = {bg[0], bg[1], bg[2], n > 3 ? bg[3] : 255};
+    if (n > 3 && !vips_image_hasalpha(in) && vips_addalpha(in, &base, NULL)) {
+        return err;
+    }
+    VipsArrayDouble *background = vips_array_double_new(color, vips_image_hasalpha(base) ? 4 : 3);
+    err = vips_embed(base, out, x, y, width, height, "extend", VIPS_EXTEND_BACKGROUND, "background", background, NULL);
+    vips_area_unref(VIPS_AREA(background));
+    if (base != in) {
+        g_object_unref(base);
+    }
     return err;
 }
\ No newline at end of file
@@ -32,6 +32,10 @@ func main() {
 	}
 	config.RefreshConfig()
 
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args()))
+	}
+
 	upg, err := tableflip.New(tableflip.Options{})
 	if err != nil {
 		log.Fatalln(err)
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"strings"
+)
+
+// Export writes the files of s starting with prefix to tw, under dir. It
+// returns the number of files written.
+func Export(ctx context.Context, s Store, prefix string, tw *tar.Writer, dir string) (int, error) {
+	names, err := s.List(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+		info, err := s.Stat(ctx, name)
+		if err != nil {
+			// removed since listed
+			continue
+		}
+		r, err := GetReader(ctx, s, name)
+		if err != nil {
+			continue
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Name:     dir + "/" + cleanKey(name),
+			Mode:     0644,
+			Size:     info.Size,
+			ModTime:  info.ModTime,
+			Typeflag: tar.TypeReg,
+		})
+		if err == nil {
+			_, err = io.Copy(tw, r)
+		}
+		r.Close()
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Import restores the files read from tr. Entries are written to the store
+// named after their top level directory, entries without a matching store
+// are skipped. It returns the number of files restored.
+func Import(ctx context.Context, tr *tar.Reader, stores map[string]Store) (int, error) {
+	count := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		parts := strings.SplitN(cleanKey(hdr.Name), "/", 2)
+		s, ok := stores[parts[0]]
+		if !ok || len(parts) < 2 {
+			continue
+		}
+		if err := PutReader(ctx, s, parts[1], tr); err != nil {
+			return count, err
+		}
+		count++
+	}
+}
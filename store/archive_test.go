@@ -0,0 +1,39 @@
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestExportImport")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	src := NewFileStore(tmpdir + "/src")
+	src.Put(ctx, "a.jpg", []byte("a"))
+	src.Put(ctx, "b/c.jpg", []byte("c"))
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if n, err := Export(ctx, src, "", tw, "originals"); err != nil || n != 2 {
+		t.Fatalf("Export returned %d, %v", n, err)
+	}
+	tw.Close()
+
+	dst := NewFileStore(tmpdir + "/dst")
+	n, err := Import(ctx, tar.NewReader(&buf), map[string]Store{"originals": dst})
+	if err != nil || n != 2 {
+		t.Fatalf("Import returned %d, %v", n, err)
+	}
+	if out, _ := dst.Get(ctx, "b/c.jpg"); string(out) != "c" {
+		t.Errorf("File was not restored")
+	}
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+type AzureStore struct {
+	container azblob.ContainerURL
+	prefix    string
+}
+
+// AzureConfig holds the settings of an AzureStore. Either AccountKey or
+// SASToken must be set, the account key takes precedence.
+type AzureConfig struct {
+	Account    string
+	AccountKey string
+	SASToken   string
+	Container  string
+	Prefix     string
+}
+
+func NewAzureStore(config *AzureConfig) (*AzureStore, error) {
+	var credential azblob.Credential
+	switch {
+	case config.AccountKey != "":
+		var err error
+		credential, err = azblob.NewSharedKeyCredential(config.Account, config.AccountKey)
+		if err != nil {
+			return nil, err
+		}
+	case config.SASToken != "":
+		credential = azblob.NewAnonymousCredential()
+	default:
+		return nil, errors.New("azure store requires an account key or a SAS token")
+	}
+
+	rawURL := fmt.Sprintf("https://%s.blob.core.windows.net/%s", config.Account, config.Container)
+	if config.SASToken != "" && config.AccountKey == "" {
+		rawURL += "?" + strings.TrimPrefix(config.SASToken, "?")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AzureStore{
+		container: azblob.NewContainerURL(*u, azblob.NewPipeline(credential, azblob.PipelineOptions{})),
+		prefix:    config.Prefix,
+	}, nil
+}
+
+func (s *AzureStore) Get(ctx context.Context, filename string) ([]byte, error) {
+	res, err := s.blob(filename).Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		return nil, azureError(err)
+	}
+	body := res.Body(azblob.RetryReaderOptions{MaxRetryRequests: 3})
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
+
+func (s *AzureStore) Put(ctx context.Context, filename string, buf []byte) error {
+	_, err := azblob.UploadBufferToBlockBlob(ctx, buf, s.blob(filename),
+		azblob.UploadToBlockBlobOptions{})
+	return err
+}
+
+func (s *AzureStore) GetReader(ctx context.Context, filename string) (io.ReadCloser, error) {
+	res, err := s.blob(filename).Download(ctx, 0, azblob.CountToEnd,
+		azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		return nil, azureError(err)
+	}
+	return res.Body(azblob.RetryReaderOptions{MaxRetryRequests: 3}), nil
+}
+
+func (s *AzureStore) PutReader(ctx context.Context, filename string, r io.Reader) error {
+	_, err := azblob.UploadStreamToBlockBlob(ctx, r, s.blob(filename),
+		azblob.UploadStreamToBlockBlobOptions{BufferSize: 4 * 1024 * 1024, MaxBuffers: 4})
+	return err
+}
+
+func (s *AzureStore) Remove(ctx context.Context, filename string) error {
+	_, err := s.blob(filename).Delete(ctx,
+		azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+	return azureError(err)
+}
+
+func (s *AzureStore) Stat(ctx context.Context, filename string) (Info, error) {
+	props, err := s.blob(filename).GetProperties(ctx, azblob.BlobAccessConditions{})
+	if err != nil {
+		return Info{}, azureError(err)
+	}
+	return Info{
+		Size:        props.ContentLength(),
+		ModTime:     props.LastModified(),
+		ContentType: props.ContentType(),
+	}, nil
+}
+
+func (s *AzureStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		res, err := s.container.ListBlobsFlatSegment(ctx, marker,
+			azblob.ListBlobsSegmentOptions{Prefix: joinPrefix(s.prefix, prefix)})
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range res.Segment.BlobItems {
+			names = append(names, trimPrefix(s.prefix, blob.Name))
+		}
+		marker = res.NextMarker
+	}
+	return names, nil
+}
+
+func (s *AzureStore) blob(filename string) azblob.BlockBlobURL {
+	return s.container.NewBlockBlobURL(path.Join(s.prefix, filename))
+}
+
+// azureError maps missing blobs to os.ErrNotExist, the API relies on it to
+// answer with 404.
+func azureError(err error) error {
+	if serr, ok := err.(azblob.StorageError); ok &&
+		serr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+		return os.ErrNotExist
+	}
+	return err
+}
+
+func (s *AzureStore) Healthy(ctx context.Context) error {
+	_, err := s.container.GetProperties(ctx, azblob.LeaseAccessConditions{})
+	return err
+}
@@ -0,0 +1,212 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/kxlt/imageresizer/collections"
+	bbolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var boltBucket = []byte("images")
+
+// BoltCache keeps files in a single bbolt database instead of one file per
+// image, which avoids running out of inodes with millions of small
+// thumbnails. Eviction works like FileCache's, access times are only
+// tracked in memory.
+type BoltCache struct {
+	db       *bbolt.DB
+	metadata collections.Map
+	size     int64
+	maxSize  int64
+}
+
+func NewBoltCache(path string, maxSize int64, nShards int) (*BoltCache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := bbolt.Open(path, 0644, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltCache{
+		db:       db,
+		metadata: collections.NewShardedMap(nShards),
+		maxSize:  maxSize,
+	}, nil
+}
+
+// values are stored prefixed with their modification time
+func encodeBoltValue(modTime time.Time, buf []byte) []byte {
+	v := make([]byte, 8+len(buf))
+	binary.BigEndian.PutUint64(v, uint64(modTime.UnixNano()))
+	copy(v[8:], buf)
+	return v
+}
+
+func decodeBoltValue(v []byte) (time.Time, []byte) {
+	if len(v) < 8 {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(v))), v[8:]
+}
+
+func (bc *BoltCache) Get(ctx context.Context, filename string) ([]byte, error) {
+	filename = cleanKey(filename)
+	var buf []byte
+	err := bc.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get([]byte(filename))
+		if v == nil {
+			return os.ErrNotExist
+		}
+		_, data := decodeBoltValue(v)
+		// v is only valid during the transaction
+		buf = append([]byte(nil), data...)
+		return nil
+	})
+	if err != nil {
+		if bc.metadata.HasKey(filename) {
+			bc.metadata.Remove(filename)
+		}
+		return nil, err
+	}
+	bc.touch(filename, int64(len(buf)))
+	return buf, nil
+}
+
+func (bc *BoltCache) Put(ctx context.Context, filename string, buf []byte) error {
+	filename = cleanKey(filename)
+	size := int64(len(buf))
+	err := bc.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		if old := b.Get([]byte(filename)); old != nil {
+			// overwritten, only account for the difference
+			_, data := decodeBoltValue(old)
+			size -= int64(len(data))
+		}
+		return b.Put([]byte(filename), encodeBoltValue(time.Now(), buf))
+	})
+	if err != nil {
+		return err
+	}
+	bc.metadata.Put(filename, file{filename: filename, size: int64(len(buf)), atime: time.Now()})
+	atomic.AddInt64(&bc.size, size)
+	return nil
+}
+
+// touch updates the access time of filename, registering it if needed
+func (bc *BoltCache) touch(filename string, size int64) {
+	f := file{filename: filename, size: size}
+	if m := bc.metadata.Get(filename); m != nil {
+		f = m.(file)
+	}
+	f.atime = time.Now()
+	bc.metadata.Put(filename, f)
+}
+
+func (bc *BoltCache) Remove(ctx context.Context, filename string) error {
+	filename = cleanKey(filename)
+	err := bc.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		if b.Get([]byte(filename)) == nil {
+			return os.ErrNotExist
+		}
+		return b.Delete([]byte(filename))
+	})
+	if err != nil {
+		return err
+	}
+	p := bc.metadata.Get(filename)
+	if p != nil {
+		m := p.(file)
+		atomic.AddInt64(&bc.size, -m.size)
+		bc.metadata.Remove(filename)
+	}
+	return nil
+}
+
+func (bc *BoltCache) Stat(ctx context.Context, filename string) (Info, error) {
+	filename = cleanKey(filename)
+	var info Info
+	err := bc.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get([]byte(filename))
+		if v == nil {
+			return os.ErrNotExist
+		}
+		modTime, data := decodeBoltValue(v)
+		info = Info{
+			Size:        int64(len(data)),
+			ModTime:     modTime,
+			ContentType: contentType(filename),
+		}
+		return nil
+	})
+	return info, err
+}
+
+func (bc *BoltCache) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	err := bc.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		p := []byte(strings.TrimPrefix(prefix, "/"))
+		for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Next() {
+			names = append(names, string(k))
+		}
+		return nil
+	})
+	return names, err
+}
+
+func (bc *BoltCache) PruneCache() error {
+	var oldest *file
+	if bc.maxSize <= 0 || atomic.LoadInt64(&bc.size) <= bc.maxSize {
+		return nil
+	}
+	for i := 0; i < 10; i++ {
+		f := bc.metadata.GetRand().(file)
+		if oldest == nil || f.atime.Before(oldest.atime) {
+			oldest = &f
+		}
+	}
+	return bc.Remove(context.Background(), oldest.filename)
+}
+
+// LoadCache registers every stored file, their access time is set to their
+// modification time since the former is not persisted.
+func (bc *BoltCache) LoadCache(walkFn func(item interface{}) error) error {
+	return bc.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			filename := string(k)
+			modTime, data := decodeBoltValue(v)
+			size := int64(len(data))
+			bc.metadata.Put(filename, file{filename: filename, size: size, atime: modTime})
+			atomic.AddInt64(&bc.size, size)
+			if walkFn != nil {
+				walkFn(filename)
+			}
+			return nil
+		})
+	})
+}
+
+func (bc *BoltCache) Healthy(ctx context.Context) error {
+	return bc.db.View(func(tx *bbolt.Tx) error {
+		if tx.Bucket(boltBucket) == nil {
+			return bbolt.ErrBucketNotFound
+		}
+		return nil
+	})
+}
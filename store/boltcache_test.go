@@ -0,0 +1,37 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestBoltCache(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestBoltCache")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	bc, err := NewBoltCache(tmpdir+"/thumbs.db", 0, 1)
+	if err != nil {
+		t.Fatalf("NewBoltCache failed: %v", err)
+	}
+
+	bc.Put(ctx, "/300x300/crop/s/a.jpg", []byte("thumb"))
+	if buf, err := bc.Get(ctx, "300x300/crop/s//a.jpg"); err != nil || string(buf) != "thumb" {
+		t.Errorf("Get returned %q, %v", buf, err)
+	}
+	if info, err := bc.Stat(ctx, "/300x300/crop/s/a.jpg"); err != nil || info.Size != 5 {
+		t.Errorf("Stat returned %v, %v", info, err)
+	}
+	if names, _ := bc.List(ctx, "/300x300/"); len(names) != 1 || names[0] != "300x300/crop/s/a.jpg" {
+		t.Errorf("List returned %v", names)
+	}
+	bc.Remove(ctx, "/300x300/crop/s/a.jpg")
+	if _, err := bc.Get(ctx, "/300x300/crop/s/a.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Get should return a not exist error for removed files, got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"github.com/klauspost/compress/zstd"
+)
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// Compressed zstd-compresses the files written to a store of metadata such
+// as indexes and tombstones, whose files are keyed by the filenames of
+// images. Files which don't shrink, e.g. entries of a few dozen bytes that
+// the zstd frame outweighs, are stored as is. Files are recognized by the
+// zstd magic number on read, so plain files written before compression was
+// enabled stay readable. Stat reports the stored size.
+type Compressed struct {
+	Store Store
+	// MinSize is the size under which files are not worth compressing
+	MinSize int
+
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+func NewCompressed(s Store, minSize int) *Compressed {
+	// without a writer/reader, only the stateless EncodeAll and DecodeAll
+	// are used, which are safe for concurrent use
+	enc, _ := zstd.NewWriter(nil)
+	dec, _ := zstd.NewReader(nil)
+	return &Compressed{Store: s, MinSize: minSize, enc: enc, dec: dec}
+}
+
+func (c *Compressed) Get(ctx context.Context, filename string) ([]byte, error) {
+	buf, err := c.Store.Get(ctx, filename)
+	if err != nil || !bytes.HasPrefix(buf, zstdMagic) {
+		return buf, err
+	}
+	return c.dec.DecodeAll(buf, nil)
+}
+
+// Put compresses buf unless it is under MinSize or doesn't shrink
+func (c *Compressed) Put(ctx context.Context, filename string, buf []byte) error {
+	if len(buf) < c.MinSize {
+		return c.Store.Put(ctx, filename, buf)
+	}
+	if z := c.enc.EncodeAll(buf, nil); len(z) < len(buf) {
+		buf = z
+	}
+	return c.Store.Put(ctx, filename, buf)
+}
+
+func (c *Compressed) Remove(ctx context.Context, filename string) error {
+	return c.Store.Remove(ctx, filename)
+}
+
+func (c *Compressed) Stat(ctx context.Context, filename string) (Info, error) {
+	return c.Store.Stat(ctx, filename)
+}
+
+func (c *Compressed) List(ctx context.Context, prefix string) ([]string, error) {
+	return c.Store.List(ctx, prefix)
+}
+
+func (c *Compressed) Healthy(ctx context.Context) error {
+	return Healthy(ctx, c.Store)
+}
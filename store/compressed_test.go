@@ -0,0 +1,54 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCompressed_Put(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemStore(0)
+	c := NewCompressed(mem, 64)
+	index := []byte(strings.Repeat("bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi 1234\n", 20))
+
+	// metadata is keyed by the filenames of images
+	c.Put(ctx, "albums/a.jpg", index)
+	if stored, _ := mem.Get(ctx, "albums/a.jpg"); !bytes.HasPrefix(stored, zstdMagic) || len(stored) >= len(index) {
+		t.Errorf("Metadata should be stored compressed")
+	}
+	if buf, err := c.Get(ctx, "albums/a.jpg"); err != nil || !bytes.Equal(buf, index) {
+		t.Errorf("Get should return the original content, got %v", err)
+	}
+	c.Put(ctx, "small", []byte("small"))
+	if stored, _ := mem.Get(ctx, "small"); string(stored) != "small" {
+		t.Errorf("Files under MinSize should be stored as is")
+	}
+	mem.Put(ctx, "legacy", []byte("legacy"))
+	if buf, err := c.Get(ctx, "legacy"); err != nil || string(buf) != "legacy" {
+		t.Errorf("Plain files should stay readable, got %q, %v", buf, err)
+	}
+}
+
+func TestCompressed_Tombstones(t *testing.T) {
+	ctx := context.Background()
+	primary := NewMemStore(0)
+	mem := NewMemStore(0)
+	r := NewReplicated(primary, &failingStore{}, false, 0, 0)
+	r.Tombstones = NewCompressed(mem, 0)
+	primary.Put(ctx, "albums/a.jpg", []byte("image"))
+	r.Remove(ctx, "albums/a.jpg")
+
+	stored, err := mem.Get(ctx, "albums/a.jpg")
+	if err != nil {
+		t.Fatalf("The tombstone should be written through Compressed, got %v", err)
+	}
+	// a timestamp is outweighed by the zstd frame
+	if bytes.HasPrefix(stored, zstdMagic) {
+		t.Errorf("Tombstones which don't shrink should be stored as is, got %d bytes", len(stored))
+	}
+	if n, err := r.SweepTombstones(ctx); err != nil || n != 1 {
+		t.Errorf("The deletion should still be pending, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"github.com/cespare/xxhash"
+	"io"
+	"os"
+	"sync"
+)
+
+// ErrExists is returned by conditional writes when the file already exists
+var ErrExists = errors.New("file already exists")
+
+// ErrPreconditionFailed is returned by conditional writes when the current
+// file doesn't match the expected one
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// Precondition restricts a conditional write
+type Precondition struct {
+	// IfNotExists fails the write with ErrExists when the file exists
+	IfNotExists bool
+	// IfMatch, when set, fails the write with ErrPreconditionFailed unless
+	// the file exists and its Info satisfies it
+	IfMatch func(info Info) bool
+}
+
+// ConditionalStore is implemented by stores supporting conditional writes
+// natively
+type ConditionalStore interface {
+	PutReaderIf(ctx context.Context, filename string, r io.Reader, cond Precondition) error
+}
+
+// writes of the same file are serialized while their precondition is
+// checked, stripes avoid keeping a lock per file
+var fileLocks [256]sync.Mutex
+
+// PutReaderIf writes r to filename if cond holds. Stores without native
+// support are checked with Stat, writes are only guaranteed not to clobber
+// each other within this process.
+func PutReaderIf(ctx context.Context, s Store, filename string, r io.Reader, cond Precondition) error {
+	if cs, ok := s.(ConditionalStore); ok {
+		return cs.PutReaderIf(ctx, filename, r, cond)
+	}
+	if !cond.IfNotExists && cond.IfMatch == nil {
+		return PutReader(ctx, s, filename, r)
+	}
+	mu := &fileLocks[xxhash.Sum64String(cleanKey(filename))%uint64(len(fileLocks))]
+	mu.Lock()
+	defer mu.Unlock()
+	info, err := s.Stat(ctx, filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	exists := err == nil
+	if cond.IfNotExists && exists {
+		return ErrExists
+	}
+	if cond.IfMatch != nil && (!exists || !cond.IfMatch(info)) {
+		return ErrPreconditionFailed
+	}
+	return PutReader(ctx, s, filename, r)
+}
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPutReaderIf(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestPutReaderIf")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	fs := NewFileStore(tmpdir)
+	put := func(content string, cond Precondition) error {
+		return PutReaderIf(ctx, fs, "a.jpg", bytes.NewReader([]byte(content)), cond)
+	}
+
+	if err := put("v1", Precondition{IfNotExists: true}); err != nil {
+		t.Errorf("Put of a new file failed: %v", err)
+	}
+	if err := put("v2", Precondition{IfNotExists: true}); err != ErrExists {
+		t.Errorf("Put should not clobber existing files, got %v", err)
+	}
+	sizeIs := func(n int64) func(Info) bool {
+		return func(info Info) bool { return info.Size == n }
+	}
+	if err := put("v22", Precondition{IfMatch: sizeIs(3)}); err != ErrPreconditionFailed {
+		t.Errorf("Put should fail when the file doesn't match, got %v", err)
+	}
+	if err := put("v22", Precondition{IfMatch: sizeIs(2)}); err != nil {
+		t.Errorf("Put should succeed when the file matches, got %v", err)
+	}
+	if buf, _ := fs.Get(ctx, "a.jpg"); string(buf) != "v22" {
+		t.Errorf("Unexpected content %q", buf)
+	}
+}
@@ -0,0 +1,204 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	dedupRefs  = "refs/"
+	dedupBlobs = "blobs/"
+)
+
+// ErrReservedKey is returned by Dedup for the filenames under refs/ and
+// blobs/, which it keeps for itself
+var ErrReservedKey = errors.New("key reserved by the store")
+
+// Dedup stores files under the sha256 of their content, identical uploads
+// share a single blob. Each filename maps to its blob through a small ref
+// file holding the hash:
+//
+//	refs/<filename>        -> hex hash
+//	blobs/<hash[:2]>/<hash>
+//
+// Files stored before dedup was enabled are still read from, listed and
+// removed at their plain path. Blobs are kept when their refs are removed
+// since others may point to them, they are never reclaimed.
+// Filenames under refs/ and blobs/ are reserved.
+type Dedup struct {
+	Store Store
+}
+
+func NewDedup(s Store) *Dedup {
+	return &Dedup{Store: s}
+}
+
+// reserved reports whether filename is one of the refs or blobs, which
+// clients can't reach
+func reserved(filename string) bool {
+	key := cleanKey(filename)
+	return strings.HasPrefix(key, dedupRefs) || strings.HasPrefix(key, dedupBlobs)
+}
+
+func blobPath(hash string) string {
+	return dedupBlobs + hash[:2] + "/" + hash
+}
+
+// ref returns the hash filename points to
+func (d *Dedup) ref(ctx context.Context, filename string) (string, error) {
+	buf, err := d.Store.Get(ctx, dedupRefs+cleanKey(filename))
+	if err != nil {
+		return "", err
+	}
+	if buf == nil {
+		return "", os.ErrNotExist
+	}
+	hash := strings.TrimSpace(string(buf))
+	if len(hash) != sha256.Size*2 {
+		return "", os.ErrNotExist
+	}
+	return hash, nil
+}
+
+func (d *Dedup) Get(ctx context.Context, filename string) ([]byte, error) {
+	if reserved(filename) {
+		return nil, os.ErrNotExist
+	}
+	hash, err := d.ref(ctx, filename)
+	if os.IsNotExist(err) {
+		return d.Store.Get(ctx, filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d.Store.Get(ctx, blobPath(hash))
+}
+
+func (d *Dedup) GetReader(ctx context.Context, filename string) (io.ReadCloser, error) {
+	if reserved(filename) {
+		return nil, os.ErrNotExist
+	}
+	hash, err := d.ref(ctx, filename)
+	if os.IsNotExist(err) {
+		return GetReader(ctx, d.Store, filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return GetReader(ctx, d.Store, blobPath(hash))
+}
+
+// Stat returns the Info of the blob filename points to, with its Hash set
+func (d *Dedup) Stat(ctx context.Context, filename string) (Info, error) {
+	if reserved(filename) {
+		return Info{}, os.ErrNotExist
+	}
+	hash, err := d.ref(ctx, filename)
+	if os.IsNotExist(err) {
+		return d.Store.Stat(ctx, filename)
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	info, err := d.Store.Stat(ctx, blobPath(hash))
+	if err != nil {
+		return Info{}, err
+	}
+	info.Hash = hash
+	info.ContentType = contentType(filename)
+	return info, nil
+}
+
+func (d *Dedup) Put(ctx context.Context, filename string, buf []byte) error {
+	if reserved(filename) {
+		return ErrReservedKey
+	}
+	sum := sha256.Sum256(buf)
+	hash := hex.EncodeToString(sum[:])
+	if _, err := d.Store.Stat(ctx, blobPath(hash)); os.IsNotExist(err) {
+		if err := d.Store.Put(ctx, blobPath(hash), buf); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	return d.Store.Put(ctx, dedupRefs+cleanKey(filename), []byte(hash))
+}
+
+// PutReader buffers r in memory since the blob path depends on the hash of
+// the whole content. Uploads are size limited by the API.
+func (d *Dedup) PutReader(ctx context.Context, filename string, r io.Reader) error {
+	if reserved(filename) {
+		return ErrReservedKey
+	}
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		return err
+	}
+	return d.Put(ctx, filename, buf.Bytes())
+}
+
+// Remove removes the ref of filename along with the plain file it may
+// have replaced
+func (d *Dedup) Remove(ctx context.Context, filename string) error {
+	if reserved(filename) {
+		return os.ErrNotExist
+	}
+	err := d.Store.Remove(ctx, dedupRefs+cleanKey(filename))
+	if plainErr := d.Store.Remove(ctx, filename); os.IsNotExist(err) {
+		return plainErr
+	}
+	return err
+}
+
+// List lists the refs starting with prefix and the plain files stored
+// before dedup was enabled
+func (d *Dedup) List(ctx context.Context, prefix string) ([]string, error) {
+	prefix = strings.TrimPrefix(prefix, "/")
+	refs, err := d.Store.List(ctx, dedupRefs+prefix)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := d.Store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	listed := map[string]bool{}
+	var names []string
+	for _, name := range refs {
+		name = strings.TrimPrefix(name, dedupRefs)
+		listed[name] = true
+		names = append(names, name)
+	}
+	for _, name := range plain {
+		if !listed[name] && !strings.HasPrefix(name, dedupRefs) &&
+			!strings.HasPrefix(name, dedupBlobs) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (d *Dedup) PruneCache() error {
+	if c, ok := d.Store.(Cache); ok {
+		return c.PruneCache()
+	}
+	return nil
+}
+
+func (d *Dedup) LoadCache(walkFn func(item interface{}) error) error {
+	if c, ok := d.Store.(Cache); ok {
+		return c.LoadCache(walkFn)
+	}
+	return nil
+}
+
+func (d *Dedup) Healthy(ctx context.Context) error {
+	return Healthy(ctx, d.Store)
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDedup_Put(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestDedup_Put")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	fs := NewFileStore(tmpdir)
+	fs.Put(ctx, "legacy.jpg", []byte("legacy"))
+	d := NewDedup(fs)
+
+	d.Put(ctx, "a.jpg", []byte("image"))
+	d.Put(ctx, "b/c.jpg", []byte("image"))
+	if blobs, _ := fs.List(ctx, dedupBlobs); len(blobs) != 1 {
+		t.Errorf("Identical uploads should share a blob, got %v", blobs)
+	}
+	if buf, err := d.Get(ctx, "/b/c.jpg"); err != nil || string(buf) != "image" {
+		t.Errorf("Get returned %q, %v", buf, err)
+	}
+	if info, err := d.Stat(ctx, "a.jpg"); err != nil || len(info.Hash) != 64 {
+		t.Errorf("Stat should return the content hash, got %v, %v", info, err)
+	}
+	if buf, err := d.Get(ctx, "legacy.jpg"); err != nil || string(buf) != "legacy" {
+		t.Errorf("Files without a ref should be read from their path, got %q, %v", buf, err)
+	}
+
+	d.Remove(ctx, "a.jpg")
+	if _, err := d.Get(ctx, "a.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Get should return a not exist error for removed files, got %v", err)
+	}
+	if buf, _ := d.Get(ctx, "b/c.jpg"); string(buf) != "image" {
+		t.Errorf("Removing a file should keep the blob shared with others")
+	}
+}
+
+func TestDedup_List(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestDedup_List")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	fs := NewFileStore(tmpdir)
+	fs.Put(ctx, "albums/legacy.jpg", []byte("legacy"))
+	fs.Put(ctx, "albums/replaced.jpg", []byte("legacy"))
+	d := NewDedup(fs)
+	d.Put(ctx, "albums/new.jpg", []byte("image"))
+	d.Put(ctx, "albums/replaced.jpg", []byte("image"))
+
+	for _, prefix := range []string{"", "albums/", "/albums/"} {
+		names, err := d.List(ctx, prefix)
+		sort.Strings(names)
+		want := []string{"albums/legacy.jpg", "albums/new.jpg", "albums/replaced.jpg"}
+		if err != nil || !reflect.DeepEqual(names, want) {
+			t.Errorf("List(%q) returned %v, %v, want %v", prefix, names, err, want)
+		}
+	}
+
+	if err := d.Remove(ctx, "albums/replaced.jpg"); err != nil {
+		t.Errorf("Remove failed: %v", err)
+	}
+	if err := d.Remove(ctx, "albums/legacy.jpg"); err != nil {
+		t.Errorf("Remove of a plain file failed: %v", err)
+	}
+	if err := d.Remove(ctx, "albums/missing.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Remove of a missing file should return a not exist error, got %v", err)
+	}
+	if names, _ := d.List(ctx, ""); !reflect.DeepEqual(names, []string{"albums/new.jpg"}) {
+		t.Errorf("Removed files should not be listed, got %v", names)
+	}
+}
+
+func TestDedup_Reserved(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestDedup_Reserved")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	fs := NewFileStore(tmpdir)
+	d := NewDedup(fs)
+	d.Put(ctx, "x.jpg", []byte("image"))
+	info, _ := d.Stat(ctx, "x.jpg")
+	reservedKeys := []string{
+		"refs/x.jpg",
+		"/refs/x.jpg",
+		"blobs/" + info.Hash[:2] + "/" + info.Hash,
+		"a/../blobs/" + info.Hash[:2] + "/" + info.Hash,
+	}
+	for _, key := range reservedKeys {
+		if err := d.Remove(ctx, key); !os.IsNotExist(err) {
+			t.Errorf("Remove(%q) should return a not exist error, got %v", key, err)
+		}
+		if _, err := d.Get(ctx, key); !os.IsNotExist(err) {
+			t.Errorf("Get(%q) should return a not exist error, got %v", key, err)
+		}
+		if _, err := d.Stat(ctx, key); !os.IsNotExist(err) {
+			t.Errorf("Stat(%q) should return a not exist error, got %v", key, err)
+		}
+		if err := d.Put(ctx, key, []byte("other")); err != ErrReservedKey {
+			t.Errorf("Put(%q) should return ErrReservedKey, got %v", key, err)
+		}
+	}
+	if buf, err := d.Get(ctx, "x.jpg"); err != nil || string(buf) != "image" {
+		t.Errorf("The ref and blob of x.jpg should be kept, got %q, %v", buf, err)
+	}
+}
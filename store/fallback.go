@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"github.com/rcrowley/go-metrics"
+	"io"
+	"os"
+)
+
+// Fallback reads from a primary store and fails over to its secondaries, in
+// order, when the file is missing or the store returns an error. Writes only
+// go to the primary, secondaries are expected to be replicas of it.
+type Fallback struct {
+	Stores []Store
+
+	requests  metrics.Meter
+	failovers metrics.Meter
+	misses    metrics.Meter
+}
+
+// NewFallback returns a Fallback store, the first non nil store is the
+// primary one. Failover rates are reported under the store.fallback metrics.
+func NewFallback(stores ...Store) *Fallback {
+	f := &Fallback{
+		requests:  metrics.GetOrRegisterMeter("store.fallback.requests", nil),
+		failovers: metrics.GetOrRegisterMeter("store.fallback.failovers", nil),
+		misses:    metrics.GetOrRegisterMeter("store.fallback.misses", nil),
+	}
+	for _, s := range stores {
+		if s != nil {
+			f.Stores = append(f.Stores, s)
+		}
+	}
+	return f
+}
+
+func (f *Fallback) Get(ctx context.Context, filename string) ([]byte, error) {
+	var buf []byte
+	err := f.read(ctx, func(s Store) (err error) {
+		buf, err = s.Get(ctx, filename)
+		if err == nil && buf == nil {
+			err = os.ErrNotExist
+		}
+		return err
+	})
+	return buf, err
+}
+
+func (f *Fallback) GetReader(ctx context.Context, filename string) (io.ReadCloser, error) {
+	var r io.ReadCloser
+	err := f.read(ctx, func(s Store) (err error) {
+		r, err = GetReader(ctx, s, filename)
+		return err
+	})
+	return r, err
+}
+
+func (f *Fallback) Stat(ctx context.Context, filename string) (Info, error) {
+	var info Info
+	err := f.read(ctx, func(s Store) (err error) {
+		info, err = s.Stat(ctx, filename)
+		return err
+	})
+	return info, err
+}
+
+// read calls fn on each store until it succeeds. Errors other than a missing
+// file take precedence in the result, so an unavailable replica is not
+// reported as a 404.
+func (f *Fallback) read(ctx context.Context, fn func(s Store) error) error {
+	f.requests.Mark(1)
+	result := os.ErrNotExist
+	for i, s := range f.Stores {
+		err := fn(s)
+		if err == nil {
+			if i > 0 {
+				f.failovers.Mark(1)
+			}
+			return nil
+		}
+		if !os.IsNotExist(err) {
+			result = err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	f.misses.Mark(1)
+	return result
+}
+
+func (f *Fallback) Put(ctx context.Context, filename string, buf []byte) error {
+	if len(f.Stores) == 0 {
+		return ErrReadOnly
+	}
+	return f.Stores[0].Put(ctx, filename, buf)
+}
+
+func (f *Fallback) PutReader(ctx context.Context, filename string, r io.Reader) error {
+	if len(f.Stores) == 0 {
+		return ErrReadOnly
+	}
+	return PutReader(ctx, f.Stores[0], filename, r)
+}
+
+func (f *Fallback) Remove(ctx context.Context, filename string) error {
+	if len(f.Stores) == 0 {
+		return ErrReadOnly
+	}
+	return f.Stores[0].Remove(ctx, filename)
+}
+
+// List lists the primary store
+func (f *Fallback) List(ctx context.Context, prefix string) ([]string, error) {
+	if len(f.Stores) == 0 {
+		return nil, nil
+	}
+	return f.Stores[0].List(ctx, prefix)
+}
+
+// Healthy succeeds as long as one of the stores is healthy
+func (f *Fallback) Healthy(ctx context.Context) error {
+	var err error
+	for _, s := range f.Stores {
+		if err = Healthy(ctx, s); err == nil {
+			return nil
+		}
+	}
+	return err
+}
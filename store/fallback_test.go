@@ -0,0 +1,55 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type failingStore struct {
+	NoopCache
+}
+
+func (*failingStore) Get(ctx context.Context, filename string) ([]byte, error) {
+	return nil, errors.New("unavailable")
+}
+
+func (*failingStore) Remove(ctx context.Context, filename string) error {
+	return errors.New("unavailable")
+}
+
+func TestFallback_Get(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestFallback_Get")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	primary := NewFileStore(tmpdir + "/primary")
+	secondary := NewFileStore(tmpdir + "/secondary")
+	primary.Put(ctx, "a.jpg", []byte("primary"))
+	secondary.Put(ctx, "a.jpg", []byte("secondary"))
+	secondary.Put(ctx, "b.jpg", []byte("secondary"))
+
+	f := NewFallback(primary, nil, secondary)
+	if buf, err := f.Get(ctx, "a.jpg"); err != nil || string(buf) != "primary" {
+		t.Errorf("Get should read from the primary store, got %q, %v", buf, err)
+	}
+	if buf, err := f.Get(ctx, "b.jpg"); err != nil || string(buf) != "secondary" {
+		t.Errorf("Get should fail over on misses, got %q, %v", buf, err)
+	}
+	if _, err := f.Get(ctx, "c.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Get should return a not exist error for missing files, got %v", err)
+	}
+
+	f = NewFallback(&failingStore{}, secondary)
+	if buf, err := f.Get(ctx, "b.jpg"); err != nil || string(buf) != "secondary" {
+		t.Errorf("Get should fail over on errors, got %q, %v", buf, err)
+	}
+	if _, err := f.Get(ctx, "c.jpg"); err == nil || os.IsNotExist(err) {
+		t.Errorf("Get should report store errors over misses, got %v", err)
+	}
+}
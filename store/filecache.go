@@ -1,9 +1,12 @@
 package store
 
 import (
+	"bytes"
+	"context"
 	"github.com/djherbis/atime"
 	"github.com/kxlt/imageresizer/collections"
 	"github.com/kxlt/imageresizer/config"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -11,13 +14,14 @@ import (
 	"sync/atomic"
 	"time"
 )
-import "path"
 
 type FileCache struct {
 	root     string
 	metadata collections.Map
 	size     int64
 	maxSize  int64
+	// ShardDepth spreads files over hashed directories, see FileStore
+	ShardDepth int
 }
 
 type file struct {
@@ -37,45 +41,69 @@ func NewFileCache(root string, maxSize int64, nShards int) *FileCache {
 	}
 }
 
-func (fc *FileCache) Get(filename string) ([]byte, error) {
-	buf, err := ioutil.ReadFile(path.Join(fc.root, filename))
+func (fc *FileCache) Get(ctx context.Context, filename string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(shardJoin(fc.root, filename, fc.ShardDepth))
 	if err != nil {
 		if fc.metadata.HasKey(filename) {
 			fc.metadata.Remove(filename)
 		}
 		return nil, err
 	}
+	fc.touch(filename, int64(len(buf)))
+	return buf, nil
+}
+
+func (fc *FileCache) GetReader(ctx context.Context, filename string) (io.ReadCloser, error) {
+	f, err := os.Open(shardJoin(fc.root, filename, fc.ShardDepth))
+	if err != nil {
+		if fc.metadata.HasKey(filename) {
+			fc.metadata.Remove(filename)
+		}
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	fc.touch(filename, info.Size())
+	return f, nil
+}
+
+func (fc *FileCache) PutReader(ctx context.Context, filename string, r io.Reader) error {
+	size, err := writeFile(shardJoin(fc.root, filename, fc.ShardDepth), r, false)
+	if err != nil {
+		return err
+	}
+	fc.metadata.Put(filename, file{filename: filename, size: size, atime: time.Now()})
+	atomic.AddInt64(&fc.size, size)
+	return nil
+}
 
+// touch updates the access time of filename, registering it if needed
+func (fc *FileCache) touch(filename string, size int64) {
 	if !fc.metadata.HasKey(filename) {
-		fc.metadata.Put(filename, file{filename: filename, size: int64(len(buf)), atime: time.Now()})
+		fc.metadata.Put(filename, file{filename: filename, size: size, atime: time.Now()})
 	} else {
 		// update timestamp
 		file := fc.metadata.Get(filename).(file)
 		file.atime = time.Now()
 		fc.metadata.Put(filename, file)
 	}
-
-	return buf, nil
 }
 
-func (fc *FileCache) Put(filename string, buf []byte) error {
-	fullpath := path.Join(fc.root, filename)
-	err := os.MkdirAll(path.Dir(fullpath), 0755)
-	if err != nil {
-		return err
-	}
-	err = ioutil.WriteFile(fullpath, buf, 0644)
+func (fc *FileCache) Put(ctx context.Context, filename string, buf []byte) error {
+	size, err := writeFile(shardJoin(fc.root, filename, fc.ShardDepth), bytes.NewReader(buf), false)
 	if err != nil {
 		return err
 	}
-	size := int64(len(buf))
 	fc.metadata.Put(filename, file{filename: filename, size: size, atime: time.Now()})
 	atomic.AddInt64(&fc.size, size)
 	return nil
 }
 
-func (fc *FileCache) Remove(filename string) error {
-	err := os.Remove(path.Join(fc.root, filename))
+func (fc *FileCache) Remove(ctx context.Context, filename string) error {
+	err := os.Remove(shardJoin(fc.root, filename, fc.ShardDepth))
 	if err != nil {
 		return err
 	}
@@ -88,6 +116,14 @@ func (fc *FileCache) Remove(filename string) error {
 	return nil
 }
 
+func (fc *FileCache) Stat(ctx context.Context, filename string) (Info, error) {
+	return statFile(shardJoin(fc.root, filename, fc.ShardDepth))
+}
+
+func (fc *FileCache) List(ctx context.Context, prefix string) ([]string, error) {
+	return listFiles(fc.root, prefix, fc.ShardDepth)
+}
+
 func (fc *FileCache) PruneCache() error {
 	var oldest *file
 	if fc.maxSize <= 0 || atomic.LoadInt64(&fc.size) <= fc.maxSize {
@@ -99,7 +135,7 @@ func (fc *FileCache) PruneCache() error {
 			oldest = &f
 		}
 	}
-	return fc.Remove(oldest.filename)
+	return fc.Remove(context.Background(), oldest.filename)
 }
 
 func (fc *FileCache) LoadCache(walkFn func(item interface{}) error) error {
@@ -113,7 +149,12 @@ func (fc *FileCache) LoadCache(walkFn func(item interface{}) error) error {
 		if info.IsDir() {
 			return nil
 		}
-		filename := strings.Split(path, fc.root+"/")[1]
+		if isTempFile(path) {
+			// leftover of an interrupted write
+			os.Remove(path)
+			return nil
+		}
+		filename := unshard(strings.Split(path, fc.root+"/")[1], fc.ShardDepth)
 		fc.metadata.Put(filename, file{filename: filename, size: info.Size(), atime: atime.Get(info)})
 		atomic.AddInt64(&fc.size, info.Size())
 		if walkFn != nil {
@@ -128,3 +169,7 @@ func (fc *FileCache) LoadCache(walkFn func(item interface{}) error) error {
 		return nil
 	})
 }
+
+func (fc *FileCache) Healthy(ctx context.Context) error {
+	return checkWritable(fc.root)
+}
@@ -1,13 +1,25 @@
 package store
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/cespare/xxhash"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 )
 import "path"
 
 type FileStore struct {
 	root string
+	// Sync makes writes fsync files before renaming them in place
+	Sync bool
+	// ShardDepth spreads files over 256^ShardDepth directories named after
+	// the hash of their path, e.g. ab/cd/300x200/crop/s/image.jpg
+	ShardDepth int
 }
 
 func NewFileStore(root string) *FileStore {
@@ -19,23 +31,157 @@ func NewFileStore(root string) *FileStore {
 	}
 }
 
-func (s *FileStore) Get(filename string) ([]byte, error) {
-	buf, err := ioutil.ReadFile(path.Join(s.root, filename))
+func (s *FileStore) Get(ctx context.Context, filename string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(shardJoin(s.root, filename, s.ShardDepth))
 	if err != nil {
 		return nil, err
 	}
 	return buf, nil
 }
 
-func (s *FileStore) Put(filename string, buf []byte) error {
-	fullpath := path.Join(s.root, filename)
-	err := os.MkdirAll(path.Dir(fullpath), 0755)
+func (s *FileStore) Put(ctx context.Context, filename string, buf []byte) error {
+	_, err := writeFile(shardJoin(s.root, filename, s.ShardDepth), bytes.NewReader(buf), s.Sync)
+	return err
+}
+
+func (s *FileStore) GetReader(ctx context.Context, filename string) (io.ReadCloser, error) {
+	return os.Open(shardJoin(s.root, filename, s.ShardDepth))
+}
+
+func (s *FileStore) PutReader(ctx context.Context, filename string, r io.Reader) error {
+	_, err := writeFile(shardJoin(s.root, filename, s.ShardDepth), r, s.Sync)
+	return err
+}
+
+func (s *FileStore) Remove(ctx context.Context, filename string) error {
+	return os.Remove(shardJoin(s.root, filename, s.ShardDepth))
+}
+
+func (s *FileStore) Stat(ctx context.Context, filename string) (Info, error) {
+	return statFile(shardJoin(s.root, filename, s.ShardDepth))
+}
+
+func (s *FileStore) List(ctx context.Context, prefix string) ([]string, error) {
+	return listFiles(s.root, prefix, s.ShardDepth)
+}
+
+// listFiles walks root and returns the slash separated paths, relative to
+// root, of the files starting with prefix
+func listFiles(root string, prefix string, shardDepth int) ([]string, error) {
+	// only walk the deepest directory that can contain matches, sharded
+	// layouts have to be walked entirely
+	dir := root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 && shardDepth == 0 {
+		dir = filepath.Join(root, filepath.FromSlash(prefix[:i]))
+	}
+	var names []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || isTempFile(p) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		name := unshard(filepath.ToSlash(rel), shardDepth)
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	return names, err
+}
+
+// safeJoin joins filename to root, ".." elements can't climb above root
+func safeJoin(root string, filename string) string {
+	return path.Join(root, path.Clean("/"+filename))
+}
+
+// shardJoin joins filename to root like safeJoin, under shardDepth levels of
+// directories derived from the hash of filename.
+func shardJoin(root string, filename string, shardDepth int) string {
+	if shardDepth <= 0 {
+		return safeJoin(root, filename)
+	}
+	key := cleanKey(filename)
+	hash := fmt.Sprintf("%016x", xxhash.Sum64String(key))
+	dirs := make([]string, 0, shardDepth+2)
+	dirs = append(dirs, root)
+	for i := 0; i < shardDepth && i < len(hash)/2; i++ {
+		dirs = append(dirs, hash[2*i:2*i+2])
+	}
+	return path.Join(append(dirs, key)...)
+}
+
+// unshard strips the shard directories from a path relative to the root
+func unshard(rel string, shardDepth int) string {
+	for i := 0; i < shardDepth; i++ {
+		j := strings.Index(rel, "/")
+		if j < 0 {
+			break
+		}
+		rel = rel[j+1:]
+	}
+	return rel
+}
+
+// writeFile atomically writes the content of r to fullpath, creating parent
+// directories as needed. Data goes to a temporary file renamed in place once
+// complete, so readers never see a truncated file.
+func writeFile(fullpath string, r io.Reader, sync bool) (int64, error) {
+	dir := path.Dir(fullpath)
+	err := os.MkdirAll(dir, 0755)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	f, err := ioutil.TempFile(dir, "."+path.Base(fullpath)+tempSuffix)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if err == nil && sync {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), fullpath)
 	}
-	return ioutil.WriteFile(fullpath, buf, 0644)
+	if err != nil {
+		os.Remove(f.Name())
+		return 0, err
+	}
+	return n, nil
+}
+
+const tempSuffix = ".tmp-"
+
+// isTempFile reports whether p is a leftover of an interrupted writeFile
+func isTempFile(p string) bool {
+	base := filepath.Base(p)
+	return strings.HasPrefix(base, ".") && strings.Contains(base, tempSuffix)
 }
 
-func (s *FileStore) Remove(filename string) error {
-	return os.Remove(path.Join(s.root, filename))
+func (s *FileStore) Healthy(ctx context.Context) error {
+	return checkWritable(s.root)
+}
+
+// checkWritable creates and removes a temporary file in dir
+func checkWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".healthz"+tempSuffix)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
 }
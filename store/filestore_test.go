@@ -0,0 +1,145 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFileStore_List(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestFileStore_List")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	fs := NewFileStore(tmpdir)
+	for _, name := range []string{"a.jpg", "albums/2018/b.jpg", "albums/2018/c.jpg", "albums2/d.jpg"} {
+		fs.Put(ctx, name, []byte("x"))
+	}
+
+	for prefix, expected := range map[string]string{
+		"":              "a.jpg,albums/2018/b.jpg,albums/2018/c.jpg,albums2/d.jpg",
+		"albums/":       "albums/2018/b.jpg,albums/2018/c.jpg",
+		"albums":        "albums/2018/b.jpg,albums/2018/c.jpg,albums2/d.jpg",
+		"albums/2018/c": "albums/2018/c.jpg",
+		"missing/":      "",
+	} {
+		names, err := fs.List(ctx, prefix)
+		if err != nil {
+			t.Errorf("List(%q) failed: %v", prefix, err)
+		}
+		sort.Strings(names)
+		if strings.Join(names, ",") != expected {
+			t.Errorf("List(%q) returned %v", prefix, names)
+		}
+	}
+}
+
+func TestFileStore_Stat(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestFileStore_Stat")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	fs := NewFileStore(tmpdir)
+	fs.Put(ctx, "a/b.png", []byte("12345"))
+
+	info, err := fs.Stat(ctx, "a/b.png")
+	if err != nil || info.Size != 5 || info.ModTime.IsZero() || info.ContentType != "image/png" {
+		t.Errorf("Stat returned %+v, %v", info, err)
+	}
+	if _, err := fs.Stat(ctx, "a"); !os.IsNotExist(err) {
+		t.Errorf("Stat should not report directories")
+	}
+	if _, err := fs.Stat(ctx, "missing.png"); !os.IsNotExist(err) {
+		t.Errorf("Stat should return a not exist error for missing files")
+	}
+}
+
+func TestFileStore_Put(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestFileStore_Put")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	fs := NewFileStore(tmpdir + "/root")
+	fs.Sync = true
+
+	if err := fs.Put(ctx, "../../escape.jpg", []byte("x")); err != nil {
+		t.Errorf("Put failed: %v", err)
+	}
+	if _, err := os.Stat(tmpdir + "/root/escape.jpg"); err != nil {
+		t.Errorf("Put should not write outside of the store root")
+	}
+
+	err = fs.PutReader(ctx, "a.jpg", LimitReader(bytes.NewReader([]byte("123456")), 3))
+	if err != ErrTooLarge {
+		t.Errorf("PutReader should fail with the reader's error, got %v", err)
+	}
+	if _, err := os.Stat(tmpdir + "/root/a.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Failed writes should not leave a file behind")
+	}
+	names, _ := fs.List(ctx, "")
+	if strings.Join(names, ",") != "escape.jpg" {
+		t.Errorf("Temporary files should not be left behind, got %v", names)
+	}
+}
+
+func TestFileStore_ShardDepth(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestFileStore_ShardDepth")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	fs := NewFileStore(tmpdir)
+	fs.ShardDepth = 2
+
+	fs.Put(ctx, "/300x300/crop/s/a.jpg", []byte("image"))
+	fullpath := shardJoin(tmpdir, "300x300/crop/s/a.jpg", 2)
+	if rel := strings.TrimPrefix(fullpath, tmpdir+"/"); len(strings.Split(rel, "/")) != 6 {
+		t.Errorf("File should be stored under two shard directories, got %s", rel)
+	}
+	if _, err := os.Stat(fullpath); err != nil {
+		t.Errorf("File not found at its sharded path")
+	}
+	if buf, err := fs.Get(ctx, "300x300/crop/s/a.jpg"); err != nil || string(buf) != "image" {
+		t.Errorf("Get returned %q, %v", buf, err)
+	}
+	names, err := fs.List(ctx, "300x300/")
+	if err != nil || len(names) != 1 || names[0] != "300x300/crop/s/a.jpg" {
+		t.Errorf("List should strip shard directories, got %v, %v", names, err)
+	}
+}
+
+func TestFileStore_Healthy(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestFileStore_Healthy")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	fs := NewFileStore(tmpdir)
+	if err := Healthy(ctx, fs); err != nil {
+		t.Errorf("Writable store should be healthy, got %v", err)
+	}
+	if names, _ := fs.List(ctx, ""); len(names) != 0 {
+		t.Errorf("Health checks should not leave files behind, got %v", names)
+	}
+	os.RemoveAll(tmpdir)
+	if err := Healthy(ctx, NewTiered(&NoopCache{}, fs)); err == nil {
+		t.Errorf("Store with a missing root should be unhealthy")
+	}
+}
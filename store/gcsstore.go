@@ -0,0 +1,146 @@
+package store
+
+import (
+	"cloud.google.com/go/storage"
+	"context"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+type GCSStore struct {
+	bucket *storage.BucketHandle
+	prefix string
+}
+
+// GCSConfig holds the settings of a GCSStore. CredentialsFile is the path to
+// a service account JSON key, when empty the application default credentials
+// are used.
+type GCSConfig struct {
+	Bucket          string
+	Prefix          string
+	CredentialsFile string
+}
+
+func NewGCSStore(config *GCSConfig) (*GCSStore, error) {
+	var opts []option.ClientOption
+	if config.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(config.CredentialsFile))
+	}
+	client, err := storage.NewClient(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GCSStore{
+		bucket: client.Bucket(config.Bucket),
+		prefix: config.Prefix,
+	}, nil
+}
+
+func (s *GCSStore) Get(ctx context.Context, filename string) ([]byte, error) {
+	r, err := s.object(filename).NewReader(ctx)
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (s *GCSStore) Put(ctx context.Context, filename string, buf []byte) error {
+	w := s.object(filename).NewWriter(ctx)
+	if _, err := w.Write(buf); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (s *GCSStore) GetReader(ctx context.Context, filename string) (io.ReadCloser, error) {
+	r, err := s.object(filename).NewReader(ctx)
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *GCSStore) PutReader(ctx context.Context, filename string, r io.Reader) error {
+	w := s.object(filename).NewWriter(ctx)
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (s *GCSStore) Remove(ctx context.Context, filename string) error {
+	err := s.object(filename).Delete(ctx)
+	if err == storage.ErrObjectNotExist {
+		return os.ErrNotExist
+	}
+	return err
+}
+
+func (s *GCSStore) Stat(ctx context.Context, filename string) (Info, error) {
+	attrs, err := s.object(filename).Attrs(ctx)
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return Info{}, os.ErrNotExist
+		}
+		return Info{}, err
+	}
+	return Info{
+		Size:        attrs.Size,
+		ModTime:     attrs.Updated,
+		ContentType: attrs.ContentType,
+	}, nil
+}
+
+func (s *GCSStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	it := s.bucket.Objects(ctx, &storage.Query{
+		Prefix: joinPrefix(s.prefix, prefix),
+	})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, trimPrefix(s.prefix, attrs.Name))
+	}
+}
+
+func (s *GCSStore) object(filename string) *storage.ObjectHandle {
+	return s.bucket.Object(path.Join(s.prefix, filename))
+}
+
+// joinPrefix joins a listing prefix to the root prefix of a bucket
+func joinPrefix(root string, prefix string) string {
+	if root == "" {
+		return prefix
+	}
+	return strings.TrimSuffix(root, "/") + "/" + prefix
+}
+
+// trimPrefix strips the root prefix of a bucket from an object name
+func trimPrefix(root string, name string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(name, root), "/")
+}
+
+func (s *GCSStore) Healthy(ctx context.Context) error {
+	_, err := s.bucket.Attrs(ctx)
+	return err
+}
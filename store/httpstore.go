@@ -0,0 +1,194 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// HTTPStore fetches objects from an upstream web server, turning
+// imageresizer into a resizing proxy. It is read-only.
+type HTTPStore struct {
+	baseURL string
+	retries int
+	maxSize int64
+	client  *http.Client
+}
+
+type HTTPConfig struct {
+	BaseURL string
+	Timeout time.Duration
+	Retries int
+	MaxSize int64
+}
+
+func NewHTTPStore(config *HTTPConfig) (*HTTPStore, error) {
+	if _, err := url.Parse(config.BaseURL); err != nil {
+		return nil, err
+	}
+	return &HTTPStore{
+		baseURL: strings.TrimSuffix(config.BaseURL, "/"),
+		retries: config.Retries,
+		maxSize: config.MaxSize,
+		client:  &http.Client{Timeout: config.Timeout},
+	}, nil
+}
+
+func (s *HTTPStore) Get(ctx context.Context, filename string) ([]byte, error) {
+	var (
+		buf []byte
+		err error
+	)
+	for i := 0; i <= s.retries; i++ {
+		if err = s.backoff(ctx, i); err != nil {
+			break
+		}
+		var body io.ReadCloser
+		body, err = s.open(ctx, filename)
+		if err == nil {
+			buf, err = ioutil.ReadAll(body)
+			body.Close()
+		}
+		if err == nil || err == os.ErrNotExist || err == ErrTooLarge {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (s *HTTPStore) GetReader(ctx context.Context, filename string) (io.ReadCloser, error) {
+	var (
+		body io.ReadCloser
+		err  error
+	)
+	for i := 0; i <= s.retries; i++ {
+		if err = s.backoff(ctx, i); err != nil {
+			break
+		}
+		body, err = s.open(ctx, filename)
+		if err == nil || err == os.ErrNotExist || err == ErrTooLarge {
+			break
+		}
+	}
+	return body, err
+}
+
+func (s *HTTPStore) PutReader(ctx context.Context, filename string, r io.Reader) error {
+	return ErrReadOnly
+}
+
+// url escapes every segment of filename, so names containing ?, # or %
+// are not read as a query, a fragment or escapes
+func (s *HTTPStore) url(filename string) string {
+	segments := strings.Split(strings.TrimPrefix(filename, "/"), "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	return s.baseURL + "/" + strings.Join(segments, "/")
+}
+
+// backoff waits before retrying, returning early if ctx is done
+func (s *HTTPStore) backoff(ctx context.Context, attempt int) error {
+	if attempt == 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-time.After(time.Duration(attempt*attempt) * 100 * time.Millisecond):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *HTTPStore) do(ctx context.Context, method string, filename string) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.url(filename), nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.client.Do(req.WithContext(ctx))
+}
+
+// open requests filename from upstream. The returned body fails with
+// ErrTooLarge once more than maxSize bytes have been read.
+func (s *HTTPStore) open(ctx context.Context, filename string) (io.ReadCloser, error) {
+	res, err := s.do(ctx, http.MethodGet, filename)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		res.Body.Close()
+		return nil, os.ErrNotExist
+	case res.StatusCode != http.StatusOK:
+		res.Body.Close()
+		return nil, fmt.Errorf("upstream responded with %s", res.Status)
+	}
+	if s.maxSize <= 0 {
+		return res.Body, nil
+	}
+	if res.ContentLength > s.maxSize {
+		res.Body.Close()
+		return nil, ErrTooLarge
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{LimitReader(res.Body, s.maxSize), res.Body}, nil
+}
+
+func (s *HTTPStore) Put(ctx context.Context, filename string, buf []byte) error {
+	return ErrReadOnly
+}
+
+func (s *HTTPStore) Remove(ctx context.Context, filename string) error {
+	return ErrReadOnly
+}
+
+func (s *HTTPStore) Stat(ctx context.Context, filename string) (Info, error) {
+	res, err := s.do(ctx, http.MethodHead, filename)
+	if err != nil {
+		return Info{}, err
+	}
+	res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		return Info{}, os.ErrNotExist
+	case res.StatusCode != http.StatusOK:
+		return Info{}, fmt.Errorf("upstream responded with %s", res.Status)
+	}
+	info := Info{
+		Size:        res.ContentLength,
+		ContentType: res.Header.Get("Content-Type"),
+	}
+	if lastModified, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = lastModified
+	}
+	return info, nil
+}
+
+// List is not supported, a web server can't be enumerated
+func (s *HTTPStore) List(ctx context.Context, prefix string) ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+// Healthy checks the upstream server answers, whatever the status code
+func (s *HTTPStore) Healthy(ctx context.Context) error {
+	res, err := s.do(ctx, http.MethodHead, "")
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 500 {
+		return fmt.Errorf("upstream returned %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestHTTPStore_Get(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Path {
+		case "/img/a.jpg":
+			w.Write([]byte("image"))
+		case "/img/flaky.jpg":
+			if calls < 2 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte("image"))
+		case "/img/big.jpg":
+			w.Write(bytes.Repeat([]byte("x"), 100))
+		case "/img/dir/a b?#%.jpg":
+			if r.URL.RawQuery == "" && r.URL.EscapedPath() == "/img/dir/a%20b%3F%23%25.jpg" {
+				w.Write([]byte("escaped"))
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	s, err := NewHTTPStore(&HTTPConfig{BaseURL: srv.URL + "/img/", Retries: 2, MaxSize: 50})
+	if err != nil {
+		t.Fatalf("NewHTTPStore failed: %v", err)
+	}
+
+	buf, err := s.Get(ctx, "a.jpg")
+	if err != nil || string(buf) != "image" {
+		t.Errorf("Get returned %q, %v", buf, err)
+	}
+	buf, err = s.Get(ctx, "dir/a b?#%.jpg")
+	if err != nil || string(buf) != "escaped" {
+		t.Errorf("Get should escape filenames, got %q, %v", buf, err)
+	}
+	if _, err := s.Get(ctx, "missing.jpg"); err != os.ErrNotExist {
+		t.Errorf("Get should return os.ErrNotExist for missing files, got %v", err)
+	}
+	if _, err := s.Get(ctx, "big.jpg"); err != ErrTooLarge {
+		t.Errorf("Get should reject objects above MaxSize, got %v", err)
+	}
+	calls = 0
+	if buf, err := s.Get(ctx, "flaky.jpg"); err != nil || string(buf) != "image" {
+		t.Errorf("Get should retry on upstream errors, got %q, %v", buf, err)
+	}
+	if err := s.Put(ctx, "a.jpg", []byte("x")); err != ErrReadOnly {
+		t.Errorf("Put should fail with ErrReadOnly")
+	}
+}
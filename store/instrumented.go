@@ -0,0 +1,136 @@
+package store
+
+import (
+	"context"
+	"github.com/rcrowley/go-metrics"
+	"io"
+	"os"
+	"time"
+)
+
+// Instrumented records the latency, throughput, errors and misses of each
+// operation of a store under store.<name>.<operation> metrics, telling slow
+// storage apart from slow resizes.
+type Instrumented struct {
+	Store Store
+	name  string
+}
+
+func NewInstrumented(s Store, name string) *Instrumented {
+	return &Instrumented{Store: s, name: name}
+}
+
+// observe records an operation started at start
+func (in *Instrumented) observe(op string, start time.Time, bytes int64, err error) {
+	prefix := "store." + in.name + "." + op
+	metrics.GetOrRegisterTimer(prefix+".latency", nil).UpdateSince(start)
+	switch {
+	case err == nil:
+		if bytes > 0 {
+			metrics.GetOrRegisterMeter(prefix+".bytes", nil).Mark(bytes)
+		}
+	case os.IsNotExist(err):
+		metrics.GetOrRegisterMeter(prefix+".notfound", nil).Mark(1)
+	default:
+		metrics.GetOrRegisterMeter(prefix+".errors", nil).Mark(1)
+	}
+}
+
+func (in *Instrumented) Get(ctx context.Context, filename string) ([]byte, error) {
+	start := time.Now()
+	buf, err := in.Store.Get(ctx, filename)
+	if err == nil && buf == nil {
+		// caches report misses with a nil buffer
+		in.observe("get", start, 0, os.ErrNotExist)
+	} else {
+		in.observe("get", start, int64(len(buf)), err)
+	}
+	return buf, err
+}
+
+// GetReader records the latency until the reader is returned, bytes are
+// counted as they are read
+func (in *Instrumented) GetReader(ctx context.Context, filename string) (io.ReadCloser, error) {
+	start := time.Now()
+	r, err := GetReader(ctx, in.Store, filename)
+	in.observe("getreader", start, 0, err)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := r.(*os.File); ok {
+		// files are passed through for range requests, count them whole
+		if fi, err := f.Stat(); err == nil {
+			metrics.GetOrRegisterMeter("store."+in.name+".getreader.bytes", nil).Mark(fi.Size())
+		}
+		return f, nil
+	}
+	return &meteredReader{
+		ReadCloser: r,
+		meter:      metrics.GetOrRegisterMeter("store."+in.name+".getreader.bytes", nil),
+	}, nil
+}
+
+func (in *Instrumented) Put(ctx context.Context, filename string, buf []byte) error {
+	start := time.Now()
+	err := in.Store.Put(ctx, filename, buf)
+	in.observe("put", start, int64(len(buf)), err)
+	return err
+}
+
+func (in *Instrumented) PutReader(ctx context.Context, filename string, r io.Reader) error {
+	start := time.Now()
+	cr := &countingReader{r: r}
+	err := PutReader(ctx, in.Store, filename, cr)
+	in.observe("putreader", start, cr.n, err)
+	return err
+}
+
+func (in *Instrumented) Remove(ctx context.Context, filename string) error {
+	start := time.Now()
+	err := in.Store.Remove(ctx, filename)
+	in.observe("remove", start, 0, err)
+	return err
+}
+
+func (in *Instrumented) Stat(ctx context.Context, filename string) (Info, error) {
+	start := time.Now()
+	info, err := in.Store.Stat(ctx, filename)
+	in.observe("stat", start, 0, err)
+	return info, err
+}
+
+func (in *Instrumented) List(ctx context.Context, prefix string) ([]string, error) {
+	start := time.Now()
+	names, err := in.Store.List(ctx, prefix)
+	in.observe("list", start, 0, err)
+	return names, err
+}
+
+func (in *Instrumented) Healthy(ctx context.Context) error {
+	return Healthy(ctx, in.Store)
+}
+
+func (in *Instrumented) PruneCache() error {
+	if c, ok := in.Store.(Cache); ok {
+		return c.PruneCache()
+	}
+	return nil
+}
+
+func (in *Instrumented) LoadCache(walkFn func(item interface{}) error) error {
+	if c, ok := in.Store.(Cache); ok {
+		return c.LoadCache(walkFn)
+	}
+	return nil
+}
+
+type meteredReader struct {
+	io.ReadCloser
+	meter metrics.Meter
+}
+
+func (r *meteredReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.meter.Mark(int64(n))
+	return n, err
+}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IPFSStore pins files to an IPFS node through its HTTP API. IPFS addresses
+// content by CID only, so each filename maps to its CID through a small
+// index entry kept in Index:
+//
+//	<filename> -> "<cid> <size>"
+//
+// Content stays pinned when files are removed since identical uploads share
+// a CID, unused pins can be cleaned up with `ipfs pin ls` and `ipfs pin rm`.
+type IPFSStore struct {
+	api    *url.URL
+	client *http.Client
+	Index  Store
+}
+
+type IPFSConfig struct {
+	API     string
+	Timeout time.Duration
+	Index   Store
+}
+
+func NewIPFSStore(config *IPFSConfig) (*IPFSStore, error) {
+	u, err := url.Parse(strings.TrimSuffix(config.API, "/") + "/api/v0/")
+	if err != nil {
+		return nil, err
+	}
+	return &IPFSStore{
+		api:    u,
+		client: &http.Client{Timeout: config.Timeout},
+		Index:  config.Index,
+	}, nil
+}
+
+// call posts to the given API command, the caller closes the body
+func (s *IPFSStore) call(ctx context.Context, cmd string, args url.Values,
+	body io.Reader, contentType string) (io.ReadCloser, error) {
+	u := *s.api
+	u.Path += cmd
+	u.RawQuery = args.Encode()
+	req, err := http.NewRequest(http.MethodPost, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		var e struct{ Message string }
+		json.NewDecoder(res.Body).Decode(&e)
+		return nil, fmt.Errorf("ipfs %s: %s %s", cmd, res.Status, e.Message)
+	}
+	return res.Body, nil
+}
+
+// ref returns the CID and size filename points to
+func (s *IPFSStore) ref(ctx context.Context, filename string) (string, int64, error) {
+	buf, err := s.Index.Get(ctx, filename)
+	if err != nil {
+		return "", 0, err
+	}
+	fields := strings.Fields(string(buf))
+	if len(fields) != 2 {
+		return "", 0, os.ErrNotExist
+	}
+	size, _ := strconv.ParseInt(fields[1], 10, 64)
+	return fields[0], size, nil
+}
+
+func (s *IPFSStore) GetReader(ctx context.Context, filename string) (io.ReadCloser, error) {
+	cid, _, err := s.ref(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	return s.call(ctx, "cat", url.Values{"arg": {cid}}, nil, "")
+}
+
+func (s *IPFSStore) Get(ctx context.Context, filename string) ([]byte, error) {
+	r, err := s.GetReader(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (s *IPFSStore) Put(ctx context.Context, filename string, buf []byte) error {
+	return s.PutReader(ctx, filename, bytes.NewReader(buf))
+}
+
+// PutReader adds and pins r, then points the index entry of filename to it
+func (s *IPFSStore) PutReader(ctx context.Context, filename string, r io.Reader) error {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("file", "file")
+		if err == nil {
+			_, err = io.Copy(part, r)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	body, err := s.call(ctx, "add", url.Values{"pin": {"true"}, "cid-version": {"1"}},
+		pr, mw.FormDataContentType())
+	if err != nil {
+		pr.CloseWithError(err)
+		return err
+	}
+	defer body.Close()
+	var added struct {
+		Hash string
+		Size string
+	}
+	if err := json.NewDecoder(body).Decode(&added); err != nil {
+		return err
+	}
+	if added.Hash == "" {
+		return fmt.Errorf("ipfs add: no CID returned")
+	}
+	// Size includes the UnixFS overhead, the file size comes from stat
+	size, err := s.size(ctx, added.Hash)
+	if err != nil {
+		return err
+	}
+	return s.Index.Put(ctx, filename, []byte(fmt.Sprintf("%s %d", added.Hash, size)))
+}
+
+func (s *IPFSStore) size(ctx context.Context, cid string) (int64, error) {
+	body, err := s.call(ctx, "files/stat", url.Values{"arg": {"/ipfs/" + cid}}, nil, "")
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	var stat struct{ Size int64 }
+	err = json.NewDecoder(body).Decode(&stat)
+	return stat.Size, err
+}
+
+// Remove only removes the index entry, see IPFSStore
+func (s *IPFSStore) Remove(ctx context.Context, filename string) error {
+	return s.Index.Remove(ctx, filename)
+}
+
+// Stat returns the size and CID recorded in the index, the modification
+// time is the one of the index entry
+func (s *IPFSStore) Stat(ctx context.Context, filename string) (Info, error) {
+	cid, size, err := s.ref(ctx, filename)
+	if err != nil {
+		return Info{}, err
+	}
+	info, err := s.Index.Stat(ctx, filename)
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{
+		Size:        size,
+		ModTime:     info.ModTime,
+		ContentType: contentType(filename),
+		Hash:        cid,
+	}, nil
+}
+
+func (s *IPFSStore) List(ctx context.Context, prefix string) ([]string, error) {
+	return s.Index.List(ctx, prefix)
+}
+
+// Healthy checks that both the node and the index are reachable
+func (s *IPFSStore) Healthy(ctx context.Context) error {
+	body, err := s.call(ctx, "version", nil, nil, "")
+	if err != nil {
+		return err
+	}
+	body.Close()
+	return Healthy(ctx, s.Index)
+}
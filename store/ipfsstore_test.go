@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// fakeIPFS implements the add, cat and files/stat commands of the IPFS API,
+// using the sha256 of the content as CID
+func fakeIPFS() *httptest.Server {
+	blobs := map[string][]byte{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v0/add":
+			f, _, err := r.FormFile("file")
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			buf, _ := ioutil.ReadAll(f)
+			sum := sha256.Sum256(buf)
+			cid := hex.EncodeToString(sum[:])
+			blobs[cid] = buf
+			json.NewEncoder(w).Encode(map[string]string{"Hash": cid, "Size": "42"})
+		case "/api/v0/cat":
+			buf, ok := blobs[r.URL.Query().Get("arg")]
+			if !ok {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Write(buf)
+		case "/api/v0/files/stat":
+			buf := blobs[strings.TrimPrefix(r.URL.Query().Get("arg"), "/ipfs/")]
+			json.NewEncoder(w).Encode(map[string]int{"Size": len(buf)})
+		case "/api/v0/version":
+			w.Write([]byte(`{"Version":"0.4.18"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestIPFSStore_Put(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestIPFSStore_Put")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	srv := fakeIPFS()
+	defer srv.Close()
+	s, err := NewIPFSStore(&IPFSConfig{API: srv.URL, Index: NewFileStore(tmpdir)})
+	if err != nil {
+		t.Fatalf("NewIPFSStore failed: %v", err)
+	}
+
+	if err := s.Put(ctx, "/a/b.jpg", []byte("image")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if buf, err := s.Get(ctx, "a/b.jpg"); err != nil || string(buf) != "image" {
+		t.Errorf("Get returned %q, %v", buf, err)
+	}
+	info, err := s.Stat(ctx, "a/b.jpg")
+	if err != nil || info.Size != 5 || info.Hash == "" {
+		t.Errorf("Stat returned %v, %v", info, err)
+	}
+	if names, _ := s.List(ctx, "a/"); len(names) != 1 || names[0] != "a/b.jpg" {
+		t.Errorf("List returned %v", names)
+	}
+	if err := s.Healthy(ctx); err != nil {
+		t.Errorf("Healthy returned %v", err)
+	}
+
+	s.Remove(ctx, "a/b.jpg")
+	if _, err := s.Get(ctx, "a/b.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Get should return a not exist error for removed files, got %v", err)
+	}
+	if _, err := s.Stat(ctx, "missing.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Stat should return a not exist error for missing files, got %v", err)
+	}
+}
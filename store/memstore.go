@@ -0,0 +1,147 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemStore keeps files in memory, evicting the least recently used ones
+// once MaxSize bytes are exceeded. It serves as a hot tier in front of the
+// disk caches and as a lightweight store in tests. Buffers returned by Get
+// are shared and must not be modified.
+type MemStore struct {
+	MaxSize int64
+
+	mu    sync.Mutex
+	size  int64
+	items map[string]*list.Element
+	lru   *list.List
+}
+
+type memItem struct {
+	filename string
+	buf      []byte
+	modTime  time.Time
+}
+
+// NewMemStore returns an empty MemStore, a maxSize of 0 means unlimited
+func NewMemStore(maxSize int64) *MemStore {
+	return &MemStore{
+		MaxSize: maxSize,
+		items:   make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (m *MemStore) Get(ctx context.Context, filename string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.items[cleanKey(filename)]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	m.lru.MoveToFront(e)
+	return e.Value.(*memItem).buf, nil
+}
+
+// Put copies buf, files larger than MaxSize are rejected with ErrTooLarge
+func (m *MemStore) Put(ctx context.Context, filename string, buf []byte) error {
+	size := int64(len(buf))
+	if m.MaxSize > 0 && size > m.MaxSize {
+		return ErrTooLarge
+	}
+	key := cleanKey(filename)
+	item := &memItem{filename: key, buf: append([]byte(nil), buf...), modTime: time.Now()}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.items[key]; ok {
+		m.size -= int64(len(e.Value.(*memItem).buf))
+		e.Value = item
+		m.lru.MoveToFront(e)
+	} else {
+		m.items[key] = m.lru.PushFront(item)
+	}
+	m.size += size
+	m.evict()
+	return nil
+}
+
+// evict removes the least recently used files until the store fits in
+// MaxSize, m.mu must be held
+func (m *MemStore) evict() {
+	for m.MaxSize > 0 && m.size > m.MaxSize {
+		m.remove(m.lru.Back())
+	}
+}
+
+func (m *MemStore) remove(e *list.Element) {
+	item := m.lru.Remove(e).(*memItem)
+	delete(m.items, item.filename)
+	m.size -= int64(len(item.buf))
+}
+
+func (m *MemStore) Remove(ctx context.Context, filename string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.items[cleanKey(filename)]
+	if !ok {
+		return os.ErrNotExist
+	}
+	m.remove(e)
+	return nil
+}
+
+func (m *MemStore) Stat(ctx context.Context, filename string) (Info, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.items[cleanKey(filename)]
+	if !ok {
+		return Info{}, os.ErrNotExist
+	}
+	item := e.Value.(*memItem)
+	return Info{
+		Size:        int64(len(item.buf)),
+		ModTime:     item.modTime,
+		ContentType: contentType(filename),
+	}, nil
+}
+
+func (m *MemStore) List(ctx context.Context, prefix string) ([]string, error) {
+	prefix = strings.TrimPrefix(prefix, "/")
+	m.mu.Lock()
+	var names []string
+	for name := range m.items {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	m.mu.Unlock()
+	sort.Strings(names)
+	return names, nil
+}
+
+// Size returns the number of bytes currently stored
+func (m *MemStore) Size() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.size
+}
+
+// PruneCache evicts down to MaxSize, which only matters after MaxSize was
+// lowered since Put evicts as it goes
+func (m *MemStore) PruneCache() error {
+	m.mu.Lock()
+	m.evict()
+	m.mu.Unlock()
+	return nil
+}
+
+// LoadCache does nothing, a MemStore starts empty
+func (m *MemStore) LoadCache(walkFn func(item interface{}) error) error {
+	return nil
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestMemStore_Put(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemStore(10)
+
+	m.Put(ctx, "/a.jpg", []byte("aaaa"))
+	m.Put(ctx, "b.jpg", []byte("bbbb"))
+	if buf, err := m.Get(ctx, "a.jpg"); err != nil || string(buf) != "aaaa" {
+		t.Errorf("Get returned %q, %v", buf, err)
+	}
+	// b.jpg is now the least recently used
+	m.Put(ctx, "c.jpg", []byte("cccc"))
+	if _, err := m.Get(ctx, "b.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Least recently used file should be evicted, got %v", err)
+	}
+	if _, err := m.Stat(ctx, "a.jpg"); err != nil {
+		t.Errorf("Recently used file should be kept, got %v", err)
+	}
+	if m.Size() != 8 {
+		t.Errorf("Size should be 8, got %d", m.Size())
+	}
+
+	m.Put(ctx, "a.jpg", []byte("aa"))
+	if m.Size() != 6 {
+		t.Errorf("Overwrites should only account for the difference, got %d", m.Size())
+	}
+	if err := m.Put(ctx, "big.jpg", make([]byte, 11)); err != ErrTooLarge {
+		t.Errorf("Files larger than the store should be rejected, got %v", err)
+	}
+
+	if names, _ := m.List(ctx, "/"); len(names) != 2 || names[0] != "a.jpg" {
+		t.Errorf("List returned %v", names)
+	}
+	if err := m.Remove(ctx, "a.jpg"); err != nil || m.Size() != 4 {
+		t.Errorf("Remove returned %v, size %d", err, m.Size())
+	}
+	if err := m.Remove(ctx, "a.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Removing a missing file should return a not exist error, got %v", err)
+	}
+}
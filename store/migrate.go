@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// MigrateStats reports the progress of a migration
+type MigrateStats struct {
+	Total   int64
+	Copied  int64
+	Skipped int64
+	Failed  int64
+	Bytes   int64
+}
+
+// Migrate copies the files starting with prefix from src to dst using
+// workers concurrent copies. Files already in dst with the same size are
+// skipped, so an interrupted migration resumes where it stopped. report, if
+// not nil, is called after each file with the current stats.
+func Migrate(ctx context.Context, src, dst Store, prefix string, workers int,
+	report func(MigrateStats)) (MigrateStats, error) {
+	names, err := src.List(ctx, prefix)
+	if err != nil {
+		return MigrateStats{}, err
+	}
+	stats := MigrateStats{Total: int64(len(names))}
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	queue := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range queue {
+				copied, size, err := migrateFile(ctx, src, dst, name)
+				switch {
+				case err != nil:
+					atomic.AddInt64(&stats.Failed, 1)
+				case copied:
+					atomic.AddInt64(&stats.Copied, 1)
+					atomic.AddInt64(&stats.Bytes, size)
+				default:
+					atomic.AddInt64(&stats.Skipped, 1)
+				}
+				if report != nil {
+					mu.Lock()
+					report(MigrateStats{
+						Total:   stats.Total,
+						Copied:  atomic.LoadInt64(&stats.Copied),
+						Skipped: atomic.LoadInt64(&stats.Skipped),
+						Failed:  atomic.LoadInt64(&stats.Failed),
+						Bytes:   atomic.LoadInt64(&stats.Bytes),
+					})
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, name := range names {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- name
+	}
+	close(queue)
+	wg.Wait()
+	return stats, ctx.Err()
+}
+
+// migrateFile copies name unless dst already holds a file of the same size
+func migrateFile(ctx context.Context, src, dst Store, name string) (bool, int64, error) {
+	info, err := src.Stat(ctx, name)
+	if err != nil {
+		return false, 0, err
+	}
+	if existing, err := dst.Stat(ctx, name); err == nil && existing.Size == info.Size {
+		return false, 0, nil
+	}
+	r, err := GetReader(ctx, src, name)
+	if err != nil {
+		return false, 0, err
+	}
+	defer r.Close()
+	if err := PutReader(ctx, dst, name, r); err != nil {
+		return false, 0, err
+	}
+	return true, info.Size, nil
+}
@@ -0,0 +1,35 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestMigrate")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	src := NewFileStore(tmpdir + "/src")
+	dst := NewFileStore(tmpdir + "/dst")
+	src.Put(ctx, "a.jpg", []byte("a"))
+	src.Put(ctx, "b/c.jpg", []byte("c"))
+	src.Put(ctx, "b/d.jpg", []byte("d"))
+	dst.Put(ctx, "b/c.jpg", []byte("c"))
+
+	stats, err := Migrate(ctx, src, dst, "", 2, nil)
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if stats.Total != 3 || stats.Copied != 2 || stats.Skipped != 1 || stats.Failed != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if buf, _ := dst.Get(ctx, "b/d.jpg"); string(buf) != "d" {
+		t.Errorf("File was not copied")
+	}
+}
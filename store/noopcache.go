@@ -1,16 +1,27 @@
 package store
 
+import (
+	"context"
+	"os"
+)
+
 type NoopCache struct{}
 
-func (c *NoopCache) Get(filename string) ([]byte, error) {
+func (c *NoopCache) Get(ctx context.Context, filename string) ([]byte, error) {
 	return nil, nil
 }
-func (c *NoopCache) Put(filename string, buf []byte) error {
+func (c *NoopCache) Put(ctx context.Context, filename string, buf []byte) error {
 	return nil
 }
-func (c *NoopCache) Remove(filename string) error {
+func (c *NoopCache) Remove(ctx context.Context, filename string) error {
 	return nil
 }
+func (c *NoopCache) List(ctx context.Context, prefix string) ([]string, error) {
+	return nil, nil
+}
+func (c *NoopCache) Stat(ctx context.Context, filename string) (Info, error) {
+	return Info{}, os.ErrNotExist
+}
 func (c *NoopCache) LoadCache(walkFn func(item interface{}) error) error {
 	return nil
 }
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"os"
+	"strings"
+	"time"
+)
+
+// PostgresStore keeps files as bytea rows of a single table, created on
+// startup if needed.
+type PostgresStore struct {
+	db    *sql.DB
+	table string
+}
+
+type PostgresConfig struct {
+	DSN   string
+	Table string
+}
+
+func NewPostgresStore(config *PostgresConfig) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", config.DSN)
+	if err != nil {
+		return nil, err
+	}
+	s := &PostgresStore{db: db, table: pq.QuoteIdentifier(config.Table)}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS ` + s.table + ` (
+		name text PRIMARY KEY,
+		data bytea NOT NULL,
+		modified timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *PostgresStore) Get(ctx context.Context, filename string) ([]byte, error) {
+	var buf []byte
+	err := s.db.QueryRowContext(ctx,
+		`SELECT data FROM `+s.table+` WHERE name = $1`, cleanKey(filename)).Scan(&buf)
+	if err == sql.ErrNoRows {
+		return nil, os.ErrNotExist
+	}
+	return buf, err
+}
+
+func (s *PostgresStore) Put(ctx context.Context, filename string, buf []byte) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO `+s.table+` (name, data, modified)
+		VALUES ($1, $2, now())
+		ON CONFLICT (name) DO UPDATE SET data = EXCLUDED.data, modified = EXCLUDED.modified`,
+		cleanKey(filename), buf)
+	return err
+}
+
+func (s *PostgresStore) Remove(ctx context.Context, filename string) error {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM `+s.table+` WHERE name = $1`, cleanKey(filename))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return os.ErrNotExist
+	}
+	return nil
+}
+
+func (s *PostgresStore) Stat(ctx context.Context, filename string) (Info, error) {
+	var size int64
+	var modTime time.Time
+	err := s.db.QueryRowContext(ctx,
+		`SELECT octet_length(data), modified FROM `+s.table+` WHERE name = $1`,
+		cleanKey(filename)).Scan(&size, &modTime)
+	if err == sql.ErrNoRows {
+		return Info{}, os.ErrNotExist
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{Size: size, ModTime: modTime, ContentType: contentType(filename)}, nil
+}
+
+func (s *PostgresStore) List(ctx context.Context, prefix string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT name FROM `+s.table+
+		` WHERE name LIKE $1 ESCAPE '\' ORDER BY name`,
+		likeEscaper.Replace(strings.TrimPrefix(prefix, "/"))+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (s *PostgresStore) Healthy(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
@@ -0,0 +1,224 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ErrQuotaExceeded is returned when a write would exceed the global quota
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// ErrPrefixQuotaExceeded is returned when a write would exceed the quota of
+// a path prefix
+var ErrPrefixQuotaExceeded = errors.New("prefix quota exceeded")
+
+// QuotaLimit caps the bytes and number of files stored under Prefix, the
+// empty prefix being the whole store. Zero values mean unlimited.
+type QuotaLimit struct {
+	Prefix     string
+	MaxBytes   int64
+	MaxObjects int64
+}
+
+type quotaUsage struct {
+	bytes   int64
+	objects int64
+}
+
+// Quota rejects writes going over the configured limits. Usage is tracked
+// in memory, Load initializes it from the content of the store.
+type Quota struct {
+	Store  Store
+	Limits []QuotaLimit
+
+	mu    sync.Mutex
+	usage []quotaUsage
+}
+
+func NewQuota(s Store, limits ...QuotaLimit) *Quota {
+	for i := range limits {
+		limits[i].Prefix = strings.TrimPrefix(limits[i].Prefix, "/")
+	}
+	return &Quota{Store: s, Limits: limits, usage: make([]quotaUsage, len(limits))}
+}
+
+// Load computes the current usage by listing and stating every file
+func (q *Quota) Load(ctx context.Context) error {
+	names, err := q.Store.List(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		info, err := q.Store.Stat(ctx, name)
+		if err != nil {
+			continue
+		}
+		q.reserve(name, info.Size, 1, true)
+	}
+	return nil
+}
+
+// reserve adds bytes and objects to the usage of every limit matching
+// filename. Unless force is set nothing is added when a limit would be
+// exceeded.
+func (q *Quota) reserve(filename string, bytes, objects int64, force bool) error {
+	key := cleanKey(filename)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !force {
+		for i, l := range q.Limits {
+			if !strings.HasPrefix(key, l.Prefix) {
+				continue
+			}
+			u := q.usage[i]
+			if (l.MaxBytes > 0 && bytes > 0 && u.bytes+bytes > l.MaxBytes) ||
+				(l.MaxObjects > 0 && objects > 0 && u.objects+objects > l.MaxObjects) {
+				if l.Prefix == "" {
+					return ErrQuotaExceeded
+				}
+				return ErrPrefixQuotaExceeded
+			}
+		}
+	}
+	for i, l := range q.Limits {
+		if strings.HasPrefix(key, l.Prefix) {
+			q.usage[i].bytes += bytes
+			q.usage[i].objects += objects
+		}
+	}
+	return nil
+}
+
+// remaining returns the number of bytes that can still be written under
+// filename, or -1 when unlimited
+func (q *Quota) remaining(filename string) int64 {
+	key := cleanKey(filename)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := int64(-1)
+	for i, l := range q.Limits {
+		if l.MaxBytes > 0 && strings.HasPrefix(key, l.Prefix) {
+			r := l.MaxBytes - q.usage[i].bytes
+			if r < 0 {
+				r = 0
+			}
+			if n < 0 || r < n {
+				n = r
+			}
+		}
+	}
+	return n
+}
+
+// current returns the size of filename and whether it exists
+func (q *Quota) current(ctx context.Context, filename string) (int64, int64) {
+	info, err := q.Store.Stat(ctx, filename)
+	if err != nil {
+		return 0, 0
+	}
+	return info.Size, 1
+}
+
+func (q *Quota) Put(ctx context.Context, filename string, buf []byte) error {
+	size, exists := q.current(ctx, filename)
+	bytes, objects := int64(len(buf))-size, 1-exists
+	if err := q.reserve(filename, bytes, objects, false); err != nil {
+		return err
+	}
+	if err := q.Store.Put(ctx, filename, buf); err != nil {
+		q.reserve(filename, -bytes, -objects, true)
+		return err
+	}
+	return nil
+}
+
+// PutReader limits r to the remaining quota, concurrent writes may exceed
+// it slightly since the size is only known once r is read.
+func (q *Quota) PutReader(ctx context.Context, filename string, r io.Reader) error {
+	size, exists := q.current(ctx, filename)
+	objects := 1 - exists
+	if err := q.reserve(filename, 0, objects, false); err != nil {
+		return err
+	}
+	cr := &countingReader{r: r}
+	var rd io.Reader = cr
+	limit := q.remaining(filename)
+	if limit >= 0 {
+		// overwritten files give their space back
+		limit += size
+		rd = LimitReader(cr, limit)
+	}
+	err := PutReader(ctx, q.Store, filename, rd)
+	if err == ErrTooLarge && limit >= 0 && cr.n > limit {
+		// find out which limit was hit
+		if err = q.reserve(filename, cr.n-size, 0, false); err == nil {
+			q.reserve(filename, size-cr.n, 0, true)
+			err = ErrQuotaExceeded
+		}
+	}
+	if err != nil {
+		q.reserve(filename, 0, -objects, true)
+		return err
+	}
+	q.reserve(filename, cr.n-size, 0, true)
+	return nil
+}
+
+func (q *Quota) Remove(ctx context.Context, filename string) error {
+	size, exists := q.current(ctx, filename)
+	if err := q.Store.Remove(ctx, filename); err != nil {
+		return err
+	}
+	q.reserve(filename, -size, -exists, true)
+	return nil
+}
+
+func (q *Quota) Get(ctx context.Context, filename string) ([]byte, error) {
+	return q.Store.Get(ctx, filename)
+}
+
+func (q *Quota) GetReader(ctx context.Context, filename string) (io.ReadCloser, error) {
+	return GetReader(ctx, q.Store, filename)
+}
+
+func (q *Quota) Stat(ctx context.Context, filename string) (Info, error) {
+	return q.Store.Stat(ctx, filename)
+}
+
+func (q *Quota) List(ctx context.Context, prefix string) ([]string, error) {
+	return q.Store.List(ctx, prefix)
+}
+
+func (q *Quota) Healthy(ctx context.Context) error {
+	return Healthy(ctx, q.Store)
+}
+
+// PruneCache and LoadCache are delegated so caches behind the quota keep
+// being managed
+func (q *Quota) PruneCache() error {
+	if c, ok := q.Store.(Cache); ok {
+		return c.PruneCache()
+	}
+	return nil
+}
+
+func (q *Quota) LoadCache(walkFn func(item interface{}) error) error {
+	if c, ok := q.Store.(Cache); ok {
+		return c.LoadCache(walkFn)
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
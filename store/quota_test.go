@@ -0,0 +1,81 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestQuota_Put(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestQuota_Put")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	fs := NewFileStore(tmpdir)
+	fs.Put(ctx, "a.jpg", []byte("12345"))
+	q := NewQuota(fs,
+		QuotaLimit{MaxBytes: 20, MaxObjects: 3},
+		QuotaLimit{Prefix: "/users/", MaxBytes: 8})
+	if err := q.Load(ctx); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if err := q.Put(ctx, "users/b.jpg", []byte("1234567")); err != nil {
+		t.Errorf("Put within quota failed: %v", err)
+	}
+	if err := q.Put(ctx, "users/c.jpg", []byte("12")); err != ErrPrefixQuotaExceeded {
+		t.Errorf("Put should exceed the prefix quota, got %v", err)
+	}
+	if err := q.PutReader(ctx, "d.jpg", bytes.NewReader(make([]byte, 9))); err != ErrQuotaExceeded {
+		t.Errorf("PutReader should exceed the global quota, got %v", err)
+	}
+	if _, err := fs.Stat(ctx, "d.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Rejected uploads should not be stored")
+	}
+	if err := q.PutReader(ctx, "a.jpg", bytes.NewReader(make([]byte, 13))); err != nil {
+		t.Errorf("Overwrites should be accounted for their size difference, got %v", err)
+	}
+	if err := q.Put(ctx, "e.jpg", nil); err != nil {
+		t.Errorf("Put within quota failed: %v", err)
+	}
+	if err := q.Put(ctx, "f.jpg", nil); err != ErrQuotaExceeded {
+		t.Errorf("Put should exceed the object quota, got %v", err)
+	}
+	q.Remove(ctx, "e.jpg")
+	if err := q.Put(ctx, "f.jpg", nil); err != nil {
+		t.Errorf("Removals should free quota, got %v", err)
+	}
+}
+
+func TestQuota_Dedup(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestQuota_Dedup")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	d := NewDedup(NewFileStore(tmpdir))
+	d.Put(ctx, "users/a.jpg", []byte("12345"))
+	q := NewQuota(d, QuotaLimit{Prefix: "users/", MaxBytes: 12, MaxObjects: 2})
+	if err := q.Load(ctx); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	// the same content is counted under every name
+	if err := q.Put(ctx, "users/b.jpg", []byte("12345")); err != nil {
+		t.Errorf("Put within quota failed: %v", err)
+	}
+	if err := q.Put(ctx, "users/c.jpg", []byte("12")); err != ErrPrefixQuotaExceeded {
+		t.Errorf("Put should exceed the prefix quota, got %v", err)
+	}
+	q.Remove(ctx, "users/a.jpg")
+	if err := q.Put(ctx, "users/c.jpg", []byte("12")); err != nil {
+		t.Errorf("Removals should free quota, got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"io"
+)
+
+// ReadOnly rejects every write to the wrapped store with ErrReadOnly
+type ReadOnly struct {
+	Store Store
+}
+
+func NewReadOnly(s Store) *ReadOnly {
+	return &ReadOnly{Store: s}
+}
+
+func (ro *ReadOnly) Get(ctx context.Context, filename string) ([]byte, error) {
+	return ro.Store.Get(ctx, filename)
+}
+
+func (ro *ReadOnly) GetReader(ctx context.Context, filename string) (io.ReadCloser, error) {
+	return GetReader(ctx, ro.Store, filename)
+}
+
+func (ro *ReadOnly) Stat(ctx context.Context, filename string) (Info, error) {
+	return ro.Store.Stat(ctx, filename)
+}
+
+func (ro *ReadOnly) List(ctx context.Context, prefix string) ([]string, error) {
+	return ro.Store.List(ctx, prefix)
+}
+
+func (ro *ReadOnly) Put(ctx context.Context, filename string, buf []byte) error {
+	return ErrReadOnly
+}
+
+func (ro *ReadOnly) PutReader(ctx context.Context, filename string, r io.Reader) error {
+	return ErrReadOnly
+}
+
+func (ro *ReadOnly) PutReaderIf(ctx context.Context, filename string, r io.Reader, cond Precondition) error {
+	return ErrReadOnly
+}
+
+func (ro *ReadOnly) Remove(ctx context.Context, filename string) error {
+	return ErrReadOnly
+}
+
+func (ro *ReadOnly) Healthy(ctx context.Context) error {
+	return Healthy(ctx, ro.Store)
+}
+
+// PruneCache and LoadCache are delegated so caches behind a read-only
+// wrapper keep being managed
+func (ro *ReadOnly) PruneCache() error {
+	if c, ok := ro.Store.(Cache); ok {
+		return c.PruneCache()
+	}
+	return nil
+}
+
+func (ro *ReadOnly) LoadCache(walkFn func(item interface{}) error) error {
+	if c, ok := ro.Store.(Cache); ok {
+		return c.LoadCache(walkFn)
+	}
+	return nil
+}
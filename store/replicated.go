@@ -0,0 +1,220 @@
+package store
+
+import (
+	"context"
+	"github.com/rcrowley/go-metrics"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// Replicated writes Put and Remove operations to a primary and a replica
+// store, reads are served by the primary only. It allows moving between
+// backends without downtime: new writes land in both while existing files
+// are copied over.
+//
+// In async mode replica writes are queued and retried in the background,
+// queued operations are lost on restart. Deletions can be made durable by
+// setting Tombstones: each deletion is recorded there until the replica
+// has applied it, and SweepTombstones retries the pending ones so a failed
+// delete doesn't leave the file behind on the replica. A later write of the
+// same file wins over its tombstone.
+type Replicated struct {
+	Primary    Store
+	Replica    Store
+	Async      bool
+	Retries    int
+	Tombstones Store
+
+	queue    chan replicaOp
+	failures metrics.Meter
+	dropped  metrics.Meter
+}
+
+type replicaOp struct {
+	filename string
+	remove   bool
+}
+
+// NewReplicated returns a Replicated store. In async mode up to queueSize
+// operations are buffered, further ones are dropped and logged.
+func NewReplicated(primary, replica Store, async bool, retries, queueSize int) *Replicated {
+	r := &Replicated{
+		Primary:  primary,
+		Replica:  replica,
+		Async:    async,
+		Retries:  retries,
+		failures: metrics.GetOrRegisterMeter("store.replica.failures", nil),
+		dropped:  metrics.GetOrRegisterMeter("store.replica.dropped", nil),
+	}
+	if async {
+		r.queue = make(chan replicaOp, queueSize)
+		go r.worker()
+	}
+	return r
+}
+
+func (r *Replicated) Get(ctx context.Context, filename string) ([]byte, error) {
+	return r.Primary.Get(ctx, filename)
+}
+
+func (r *Replicated) GetReader(ctx context.Context, filename string) (io.ReadCloser, error) {
+	return GetReader(ctx, r.Primary, filename)
+}
+
+func (r *Replicated) Stat(ctx context.Context, filename string) (Info, error) {
+	return r.Primary.Stat(ctx, filename)
+}
+
+func (r *Replicated) List(ctx context.Context, prefix string) ([]string, error) {
+	return r.Primary.List(ctx, prefix)
+}
+
+func (r *Replicated) Put(ctx context.Context, filename string, buf []byte) error {
+	if err := r.Primary.Put(ctx, filename, buf); err != nil {
+		return err
+	}
+	r.unbury(ctx, filename)
+	if r.Async {
+		r.enqueue(replicaOp{filename: filename})
+		return nil
+	}
+	return r.Replica.Put(ctx, filename, buf)
+}
+
+// PutReader streams r to the primary store, the replica is then written
+// from the primary copy.
+func (r *Replicated) PutReader(ctx context.Context, filename string, rd io.Reader) error {
+	if err := PutReader(ctx, r.Primary, filename, rd); err != nil {
+		return err
+	}
+	r.unbury(ctx, filename)
+	op := replicaOp{filename: filename}
+	if r.Async {
+		r.enqueue(op)
+		return nil
+	}
+	return r.apply(ctx, op)
+}
+
+func (r *Replicated) Remove(ctx context.Context, filename string) error {
+	if err := r.Primary.Remove(ctx, filename); err != nil {
+		return err
+	}
+	r.bury(ctx, filename)
+	op := replicaOp{filename: filename, remove: true}
+	if r.Async {
+		r.enqueue(op)
+		return nil
+	}
+	return r.apply(ctx, op)
+}
+
+func (r *Replicated) enqueue(op replicaOp) {
+	select {
+	case r.queue <- op:
+	default:
+		r.dropped.Mark(1)
+		log.Println("Replication queue full, dropping", op.filename)
+	}
+}
+
+func (r *Replicated) worker() {
+	for op := range r.queue {
+		var err error
+		for attempt := 0; attempt <= r.Retries; attempt++ {
+			if attempt > 0 {
+				time.Sleep(time.Duration(100<<uint(attempt-1)) * time.Millisecond)
+			}
+			if err = r.apply(context.Background(), op); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			r.failures.Mark(1)
+			log.Println("Replication of", op.filename, "failed:", err)
+		}
+	}
+}
+
+// apply replays op on the replica, files are copied from the primary
+func (r *Replicated) apply(ctx context.Context, op replicaOp) error {
+	if op.remove {
+		err := r.Replica.Remove(ctx, op.filename)
+		if os.IsNotExist(err) {
+			// not copied over yet
+			err = nil
+		}
+		if err == nil {
+			r.unbury(ctx, op.filename)
+		}
+		return err
+	}
+	rd, err := GetReader(ctx, r.Primary, op.filename)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+	return PutReader(ctx, r.Replica, op.filename, rd)
+}
+
+// bury records the deletion of filename
+func (r *Replicated) bury(ctx context.Context, filename string) {
+	if r.Tombstones == nil {
+		return
+	}
+	stamp := time.Now().UTC().Format(time.RFC3339Nano)
+	if err := r.Tombstones.Put(ctx, filename, []byte(stamp)); err != nil {
+		log.Println("Could not record tombstone of", filename, err)
+	}
+}
+
+// unbury forgets the deletion of filename, if any
+func (r *Replicated) unbury(ctx context.Context, filename string) {
+	if r.Tombstones == nil {
+		return
+	}
+	if err := r.Tombstones.Remove(ctx, filename); err != nil && !os.IsNotExist(err) {
+		log.Println("Could not remove tombstone of", filename, err)
+	}
+}
+
+// SweepTombstones replays the deletions the replica hasn't applied yet and
+// returns how many are still pending. Tombstones older than the primary
+// copy of their file are dropped, the file was written again since.
+func (r *Replicated) SweepTombstones(ctx context.Context) (int, error) {
+	if r.Tombstones == nil {
+		return 0, nil
+	}
+	names, err := r.Tombstones.List(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return pending, err
+		}
+		buf, err := r.Tombstones.Get(ctx, name)
+		if err != nil {
+			continue
+		}
+		buried, _ := time.Parse(time.RFC3339Nano, string(buf))
+		if info, err := r.Primary.Stat(ctx, name); err == nil && info.ModTime.After(buried) {
+			r.unbury(ctx, name)
+			continue
+		}
+		if err := r.apply(ctx, replicaOp{filename: name, remove: true}); err != nil {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+func (r *Replicated) Healthy(ctx context.Context) error {
+	if err := Healthy(ctx, r.Primary); err != nil {
+		return err
+	}
+	return Healthy(ctx, r.Replica)
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReplicated_Put(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestReplicated_Put")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	primary := NewFileStore(tmpdir + "/primary")
+	replica := NewFileStore(tmpdir + "/replica")
+
+	r := NewReplicated(primary, replica, false, 0, 0)
+	if err := r.Put(ctx, "a.jpg", []byte("image")); err != nil {
+		t.Errorf("Put failed: %v", err)
+	}
+	if buf, _ := replica.Get(ctx, "a.jpg"); string(buf) != "image" {
+		t.Errorf("Put was not replicated")
+	}
+	if err := r.Remove(ctx, "a.jpg"); err != nil {
+		t.Errorf("Remove failed: %v", err)
+	}
+	if _, err := replica.Stat(ctx, "a.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Remove was not replicated")
+	}
+
+	r = NewReplicated(primary, replica, true, 1, 10)
+	if err := r.Put(ctx, "b.jpg", []byte("image")); err != nil {
+		t.Errorf("Put failed: %v", err)
+	}
+	var buf []byte
+	for i := 0; i < 100 && buf == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		buf, _ = replica.Get(ctx, "b.jpg")
+	}
+	if string(buf) != "image" {
+		t.Errorf("Async put was not replicated")
+	}
+}
+
+func TestReplicated_SweepTombstones(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestReplicated_SweepTombstones")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	primary := NewFileStore(tmpdir + "/primary")
+	replica := &failingStore{}
+	tombstones := NewFileStore(tmpdir + "/tombstones")
+
+	r := NewReplicated(primary, replica, false, 0, 0)
+	r.Tombstones = tombstones
+	primary.Put(ctx, "a.jpg", []byte("image"))
+	primary.Put(ctx, "b.jpg", []byte("image"))
+	if err := r.Remove(ctx, "a.jpg"); err == nil {
+		t.Errorf("Remove should report the replica failure")
+	}
+	r.Remove(ctx, "b.jpg")
+	if names, _ := tombstones.List(ctx, ""); len(names) != 2 {
+		t.Errorf("Failed deletions should leave a tombstone, got %v", names)
+	}
+
+	// b.jpg is written again after its deletion
+	time.Sleep(10 * time.Millisecond)
+	primary.Put(ctx, "b.jpg", []byte("image"))
+	if n, err := r.SweepTombstones(ctx); err != nil || n != 1 {
+		t.Errorf("One deletion should still be pending, got %d, %v", n, err)
+	}
+	if _, err := tombstones.Stat(ctx, "b.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Tombstones older than the file should be dropped, got %v", err)
+	}
+
+	r.Replica = NewFileStore(tmpdir + "/replica")
+	if n, err := r.SweepTombstones(ctx); err != nil || n != 0 {
+		t.Errorf("No deletion should be pending, got %d, %v", n, err)
+	}
+	if names, _ := tombstones.List(ctx, ""); len(names) != 0 {
+		t.Errorf("Applied deletions should drop their tombstone, got %v", names)
+	}
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"github.com/rcrowley/go-metrics"
+	"io"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// Retry retries failed operations of a store with exponential backoff and
+// jitter. Missing files, size and read-only errors are not retried.
+// Retries and final failures are reported per operation under the
+// store.retry metrics.
+type Retry struct {
+	Store    Store
+	Attempts int
+	Base     time.Duration
+	Max      time.Duration
+}
+
+func NewRetry(s Store, attempts int, base, max time.Duration) *Retry {
+	return &Retry{Store: s, Attempts: attempts, Base: base, Max: max}
+}
+
+// retryable tells whether err may be transient
+func retryable(err error) bool {
+	switch {
+	case err == nil, os.IsNotExist(err),
+		err == ErrReadOnly, err == ErrTooLarge, err == ErrNotSupported,
+		err == context.Canceled, err == context.DeadlineExceeded:
+		return false
+	}
+	return true
+}
+
+// delay returns the wait before the given retry, a random duration in the
+// upper half of the exponential backoff
+func (r *Retry) delay(retry int) time.Duration {
+	d := r.Base << uint(retry)
+	if d > r.Max || d <= 0 {
+		d = r.Max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (r *Retry) do(ctx context.Context, op string, fn func() error) error {
+	err := fn()
+	for i := 0; i < r.Attempts && retryable(err); i++ {
+		metrics.GetOrRegisterMeter("store.retry."+op+".retries", nil).Mark(1)
+		select {
+		case <-time.After(r.delay(i)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		err = fn()
+	}
+	if retryable(err) {
+		metrics.GetOrRegisterMeter("store.retry."+op+".failures", nil).Mark(1)
+	}
+	return err
+}
+
+func (r *Retry) Get(ctx context.Context, filename string) ([]byte, error) {
+	var buf []byte
+	err := r.do(ctx, "get", func() (err error) {
+		buf, err = r.Store.Get(ctx, filename)
+		return err
+	})
+	return buf, err
+}
+
+func (r *Retry) GetReader(ctx context.Context, filename string) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	err := r.do(ctx, "get", func() (err error) {
+		rc, err = GetReader(ctx, r.Store, filename)
+		return err
+	})
+	return rc, err
+}
+
+func (r *Retry) Put(ctx context.Context, filename string, buf []byte) error {
+	return r.do(ctx, "put", func() error {
+		return r.Store.Put(ctx, filename, buf)
+	})
+}
+
+// PutReader only retries when rd can be rewound
+func (r *Retry) PutReader(ctx context.Context, filename string, rd io.Reader) error {
+	seeker, ok := rd.(io.Seeker)
+	if !ok {
+		return PutReader(ctx, r.Store, filename, rd)
+	}
+	return r.do(ctx, "put", func() error {
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return PutReader(ctx, r.Store, filename, rd)
+	})
+}
+
+func (r *Retry) Remove(ctx context.Context, filename string) error {
+	return r.do(ctx, "remove", func() error {
+		return r.Store.Remove(ctx, filename)
+	})
+}
+
+func (r *Retry) Stat(ctx context.Context, filename string) (Info, error) {
+	var info Info
+	err := r.do(ctx, "stat", func() (err error) {
+		info, err = r.Store.Stat(ctx, filename)
+		return err
+	})
+	return info, err
+}
+
+func (r *Retry) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	err := r.do(ctx, "list", func() (err error) {
+		names, err = r.Store.List(ctx, prefix)
+		return err
+	})
+	return names, err
+}
+
+func (r *Retry) Healthy(ctx context.Context) error {
+	return Healthy(ctx, r.Store)
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+type flakyStore struct {
+	NoopCache
+	failures int
+	calls    int
+}
+
+func (s *flakyStore) Get(ctx context.Context, filename string) ([]byte, error) {
+	s.calls++
+	if filename == "missing.jpg" {
+		return nil, os.ErrNotExist
+	}
+	if s.calls <= s.failures {
+		return nil, errors.New("connection reset")
+	}
+	return []byte("image"), nil
+}
+
+func TestRetry_Get(t *testing.T) {
+	ctx := context.Background()
+	s := &flakyStore{failures: 2}
+	r := NewRetry(s, 2, time.Millisecond, 10*time.Millisecond)
+	if buf, err := r.Get(ctx, "a.jpg"); err != nil || string(buf) != "image" {
+		t.Errorf("Get should be retried, got %q, %v", buf, err)
+	}
+
+	s = &flakyStore{failures: 3}
+	r = NewRetry(s, 2, time.Millisecond, 10*time.Millisecond)
+	if _, err := r.Get(ctx, "a.jpg"); err == nil || s.calls != 3 {
+		t.Errorf("Get should give up after 2 retries, got %v after %d calls", err, s.calls)
+	}
+
+	s = &flakyStore{}
+	r = NewRetry(s, 2, time.Millisecond, 10*time.Millisecond)
+	if _, err := r.Get(ctx, "missing.jpg"); !os.IsNotExist(err) || s.calls != 1 {
+		t.Errorf("Missing files should not be retried, got %v after %d calls", err, s.calls)
+	}
+}
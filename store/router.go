@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Router dispatches files to a store by the first segment of their path,
+// e.g. products/... and avatars/... may live on different backends. Paths
+// are passed on unchanged and files without a matching route go to Default.
+type Router struct {
+	Routes  map[string]Store
+	Default Store
+}
+
+func NewRouter(def Store, routes map[string]Store) *Router {
+	return &Router{Routes: routes, Default: def}
+}
+
+// route returns the store holding filename
+func (r *Router) route(filename string) Store {
+	key := cleanKey(filename)
+	if i := strings.Index(key, "/"); i > 0 {
+		if s, ok := r.Routes[key[:i]]; ok {
+			return s
+		}
+	}
+	return r.Default
+}
+
+func (r *Router) Get(ctx context.Context, filename string) ([]byte, error) {
+	return r.route(filename).Get(ctx, filename)
+}
+
+func (r *Router) GetReader(ctx context.Context, filename string) (io.ReadCloser, error) {
+	return GetReader(ctx, r.route(filename), filename)
+}
+
+func (r *Router) Put(ctx context.Context, filename string, buf []byte) error {
+	return r.route(filename).Put(ctx, filename, buf)
+}
+
+func (r *Router) PutReader(ctx context.Context, filename string, rd io.Reader) error {
+	return PutReader(ctx, r.route(filename), filename, rd)
+}
+
+func (r *Router) PutReaderIf(ctx context.Context, filename string, rd io.Reader, cond Precondition) error {
+	return PutReaderIf(ctx, r.route(filename), filename, rd, cond)
+}
+
+func (r *Router) Remove(ctx context.Context, filename string) error {
+	return r.route(filename).Remove(ctx, filename)
+}
+
+func (r *Router) Stat(ctx context.Context, filename string) (Info, error) {
+	return r.route(filename).Stat(ctx, filename)
+}
+
+// List lists the route prefix falls into, or every store when prefix
+// doesn't cover a whole first segment. Files of the default store which
+// belong to a route are skipped.
+func (r *Router) List(ctx context.Context, prefix string) ([]string, error) {
+	prefix = strings.TrimPrefix(prefix, "/")
+	if i := strings.Index(prefix, "/"); i > 0 {
+		if s, ok := r.Routes[prefix[:i]]; ok {
+			return s.List(ctx, prefix)
+		}
+	}
+	var names []string
+	for ns, s := range r.Routes {
+		if !strings.HasPrefix(ns+"/", prefix) && !strings.HasPrefix(prefix, ns+"/") {
+			continue
+		}
+		routed, err := s.List(ctx, ns+"/")
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range routed {
+			if strings.HasPrefix(name, prefix) {
+				names = append(names, name)
+			}
+		}
+	}
+	unrouted, err := r.Default.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range unrouted {
+		if r.route(name) == r.Default {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Healthy reports the first unhealthy store
+func (r *Router) Healthy(ctx context.Context) error {
+	if err := Healthy(ctx, r.Default); err != nil {
+		return err
+	}
+	for _, s := range r.Routes {
+		if err := Healthy(ctx, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	ctx := context.Background()
+	def := NewMemStore(0)
+	products := NewMemStore(0)
+	r := NewRouter(def, map[string]Store{"products": products})
+
+	r.Put(ctx, "/products/shoe.jpg", []byte("shoe"))
+	r.Put(ctx, "avatars/alice.jpg", []byte("alice"))
+	r.Put(ctx, "logo.jpg", []byte("logo"))
+	if _, err := products.Stat(ctx, "products/shoe.jpg"); err != nil {
+		t.Errorf("Routed files should be written to their store, got %v", err)
+	}
+	if _, err := def.Stat(ctx, "products/shoe.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Routed files should not be written to the default store, got %v", err)
+	}
+	if buf, err := r.Get(ctx, "products/shoe.jpg"); err != nil || string(buf) != "shoe" {
+		t.Errorf("Get returned %q, %v", buf, err)
+	}
+	if buf, err := r.Get(ctx, "avatars/alice.jpg"); err != nil || string(buf) != "alice" {
+		t.Errorf("Get returned %q, %v", buf, err)
+	}
+
+	// a stale copy in the default store is hidden by the route
+	def.Put(ctx, "products/old.jpg", []byte("old"))
+	names, err := r.List(ctx, "")
+	expected := []string{"avatars/alice.jpg", "logo.jpg", "products/shoe.jpg"}
+	if err != nil || !reflect.DeepEqual(names, expected) {
+		t.Errorf("List returned %v, %v", names, err)
+	}
+	if names, _ := r.List(ctx, "prod"); len(names) != 1 || names[0] != "products/shoe.jpg" {
+		t.Errorf("List of a partial segment returned %v", names)
+	}
+	if names, _ := r.List(ctx, "products/s"); len(names) != 1 {
+		t.Errorf("List of a route returned %v", names)
+	}
+
+	if err := r.Remove(ctx, "products/shoe.jpg"); err != nil {
+		t.Errorf("Remove returned %v", err)
+	}
+	if _, err := products.Stat(ctx, "products/shoe.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Remove should remove from the routed store, got %v", err)
+	}
+}
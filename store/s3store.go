@@ -2,12 +2,18 @@ package store
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"io"
+	"net/http"
 	"os"
+	"strings"
 )
 
 type S3Store struct {
@@ -18,16 +24,46 @@ type S3Store struct {
 	S3         *s3.S3
 }
 
+// S3Config holds the settings of an S3Store. AccessKey and SecretKey are
+// optional, when empty the default AWS credential chain is used (environment,
+// shared credentials file, instance role). Endpoint, PathStyle and
+// InsecureSkipVerify allow S3-compatible servers such as MinIO or Ceph RGW.
 type S3Config struct {
-	Region string
-	Bucket string
-	Prefix string
+	Region             string
+	Bucket             string
+	Prefix             string
+	AccessKey          string
+	SecretKey          string
+	Endpoint           string
+	PathStyle          bool
+	InsecureSkipVerify bool
 }
 
 func NewS3Store(config *S3Config) (*S3Store, error) {
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(config.Region)},
-	)
+	awsConfig := &aws.Config{
+		Region: aws.String(config.Region),
+	}
+	if config.AccessKey != "" {
+		awsConfig.Credentials = credentials.NewStaticCredentials(
+			config.AccessKey, config.SecretKey, "")
+	}
+	if config.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(config.Endpoint)
+		if config.Region == "" {
+			// requests still need a region to be signed
+			awsConfig.Region = aws.String("us-east-1")
+		}
+	}
+	if config.PathStyle {
+		awsConfig.S3ForcePathStyle = aws.Bool(true)
+	}
+	if config.InsecureSkipVerify {
+		awsConfig.HTTPClient = &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}}
+	}
+	sess, err := session.NewSession(awsConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -41,44 +77,108 @@ func NewS3Store(config *S3Config) (*S3Store, error) {
 	}, nil
 }
 
-func (s *S3Store) Get(filename string) ([]byte, error) {
+func (s *S3Store) Get(ctx context.Context, filename string) ([]byte, error) {
 	writeAtBuf := aws.NewWriteAtBuffer([]byte{})
-	_, err := s.downloader.Download(writeAtBuf,
+	_, err := s.downloader.DownloadWithContext(ctx, writeAtBuf,
 		&s3.GetObjectInput{
 			Bucket: s.bucket,
-			Key:    aws.String(s.prefix + "/" + filename),
+			Key:    s.key(filename),
 		})
 	if err != nil {
-		s3err, ok := err.(awserr.RequestFailure)
-		if ok && s3err.StatusCode() == 404 {
-			return nil, os.ErrNotExist
-		}
-		return nil, err
+		return nil, s3Error(err)
 	}
 	return writeAtBuf.Bytes(), nil
 }
 
-func (s *S3Store) Put(filename string, buf []byte) error {
-	_, err := s.uploader.Upload(&s3manager.UploadInput{
+func (s *S3Store) GetReader(ctx context.Context, filename string) (io.ReadCloser, error) {
+	out, err := s.S3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: s.bucket,
+		Key:    s.key(filename),
+	})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	return out.Body, nil
+}
+
+func (s *S3Store) PutReader(ctx context.Context, filename string, r io.Reader) error {
+	_, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: s.bucket,
+		Key:    s.key(filename),
+		Body:   r,
+	})
+	return err
+}
+
+func (s *S3Store) Put(ctx context.Context, filename string, buf []byte) error {
+	_, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
 		Bucket: s.bucket,
-		Key:    aws.String(s.prefix + "/" + filename),
+		Key:    s.key(filename),
 		Body:   bytes.NewReader(buf),
 	})
 	return err
 }
 
-func (s *S3Store) Remove(filename string) error {
-	key := aws.String(s.prefix + "/" + filename)
-	_, err := s.S3.DeleteObject(&s3.DeleteObjectInput{
+func (s *S3Store) Remove(ctx context.Context, filename string) error {
+	key := s.key(filename)
+	_, err := s.S3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 		Bucket: s.bucket,
 		Key:    key,
 	})
 	if err != nil {
 		return err
 	}
-	err = s.S3.WaitUntilObjectNotExists(&s3.HeadObjectInput{
+	err = s.S3.WaitUntilObjectNotExistsWithContext(ctx, &s3.HeadObjectInput{
 		Bucket: s.bucket,
 		Key:    key,
 	})
 	return err
 }
+
+func (s *S3Store) Stat(ctx context.Context, filename string) (Info, error) {
+	out, err := s.S3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: s.bucket,
+		Key:    s.key(filename),
+	})
+	if err != nil {
+		return Info{}, s3Error(err)
+	}
+	return Info{
+		Size:        aws.Int64Value(out.ContentLength),
+		ModTime:     aws.TimeValue(out.LastModified),
+		ContentType: aws.StringValue(out.ContentType),
+	}, nil
+}
+
+func (s *S3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	root := s.prefix + "/"
+	err := s.S3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: s.bucket,
+		Prefix: s.key(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			names = append(names, strings.TrimPrefix(aws.StringValue(obj.Key), root))
+		}
+		return true
+	})
+	return names, err
+}
+
+func (s *S3Store) key(filename string) *string {
+	return aws.String(s.prefix + "/" + filename)
+}
+
+// s3Error maps missing objects to os.ErrNotExist
+func s3Error(err error) error {
+	s3err, ok := err.(awserr.RequestFailure)
+	if ok && s3err.StatusCode() == 404 {
+		return os.ErrNotExist
+	}
+	return err
+}
+
+func (s *S3Store) Healthy(ctx context.Context) error {
+	_, err := s.S3.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: s.bucket})
+	return err
+}
@@ -0,0 +1,267 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SFTPStore keeps files on a server reachable over SSH. The connection is
+// opened lazily and reopened after failures.
+type SFTPStore struct {
+	addr   string
+	root   string
+	config *ssh.ClientConfig
+
+	mu     sync.Mutex
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+// SFTPConfig holds the settings of an SFTPStore. Either KeyFile or Password
+// must be set. Host keys are checked against KnownHosts unless Insecure is
+// set.
+type SFTPConfig struct {
+	Addr       string
+	User       string
+	KeyFile    string
+	Password   string
+	KnownHosts string
+	Insecure   bool
+	Root       string
+	Timeout    time.Duration
+}
+
+func NewSFTPStore(config *SFTPConfig) (*SFTPStore, error) {
+	var auth []ssh.AuthMethod
+	if config.KeyFile != "" {
+		pem, err := ioutil.ReadFile(config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if config.Password != "" {
+		auth = append(auth, ssh.Password(config.Password))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("sftp store requires a key file or a password")
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+	switch {
+	case config.Insecure:
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	case config.KnownHosts != "":
+		var err error
+		hostKeyCallback, err = knownhosts.New(config.KnownHosts)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("sftp store requires a known_hosts file")
+	}
+
+	addr := config.Addr
+	if !strings.Contains(addr, ":") {
+		addr += ":22"
+	}
+	return &SFTPStore{
+		addr: addr,
+		root: config.Root,
+		config: &ssh.ClientConfig{
+			User:            config.User,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         config.Timeout,
+		},
+	}, nil
+}
+
+// sftp returns the current client, connecting if needed
+func (s *SFTPStore) sftp() (*sftp.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+	conn, err := ssh.Dial("tcp", s.addr, s.config)
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s.conn, s.client = conn, client
+	return client, nil
+}
+
+// check drops the connection after errors other than missing files, the
+// next operation reconnects
+func (s *SFTPStore) check(client *sftp.Client, err error) error {
+	if err == nil || os.IsNotExist(err) {
+		return err
+	}
+	s.mu.Lock()
+	if s.client == client {
+		s.client.Close()
+		s.conn.Close()
+		s.client, s.conn = nil, nil
+	}
+	s.mu.Unlock()
+	return err
+}
+
+func (s *SFTPStore) path(filename string) string {
+	return safeJoin(s.root, filename)
+}
+
+func (s *SFTPStore) GetReader(ctx context.Context, filename string) (io.ReadCloser, error) {
+	client, err := s.sftp()
+	if err != nil {
+		return nil, err
+	}
+	f, err := client.Open(s.path(filename))
+	if err != nil {
+		return nil, s.check(client, err)
+	}
+	return f, nil
+}
+
+func (s *SFTPStore) Get(ctx context.Context, filename string) ([]byte, error) {
+	r, err := s.GetReader(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (s *SFTPStore) Put(ctx context.Context, filename string, buf []byte) error {
+	return s.PutReader(ctx, filename, bytes.NewReader(buf))
+}
+
+// PutReader uploads to a temporary file renamed in place once complete, like
+// the filesystem store
+func (s *SFTPStore) PutReader(ctx context.Context, filename string, r io.Reader) error {
+	client, err := s.sftp()
+	if err != nil {
+		return err
+	}
+	fullpath := s.path(filename)
+	if err := client.MkdirAll(path.Dir(fullpath)); err != nil {
+		return s.check(client, err)
+	}
+	tmp := path.Join(path.Dir(fullpath),
+		fmt.Sprintf(".%s%s%d", path.Base(fullpath), tempSuffix, rand.Int63()))
+	f, err := client.Create(tmp)
+	if err != nil {
+		return s.check(client, err)
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = client.PosixRename(tmp, fullpath)
+	}
+	if err != nil {
+		client.Remove(tmp)
+		return s.check(client, err)
+	}
+	return nil
+}
+
+func (s *SFTPStore) Remove(ctx context.Context, filename string) error {
+	client, err := s.sftp()
+	if err != nil {
+		return err
+	}
+	return s.check(client, client.Remove(s.path(filename)))
+}
+
+func (s *SFTPStore) Stat(ctx context.Context, filename string) (Info, error) {
+	client, err := s.sftp()
+	if err != nil {
+		return Info{}, err
+	}
+	fi, err := client.Stat(s.path(filename))
+	if err != nil {
+		return Info{}, s.check(client, err)
+	}
+	if fi.IsDir() {
+		return Info{}, os.ErrNotExist
+	}
+	return Info{
+		Size:        fi.Size(),
+		ModTime:     fi.ModTime(),
+		ContentType: contentType(filename),
+	}, nil
+}
+
+// List walks the deepest directory of prefix, skipping temporary files
+func (s *SFTPStore) List(ctx context.Context, prefix string) ([]string, error) {
+	client, err := s.sftp()
+	if err != nil {
+		return nil, err
+	}
+	prefix = strings.TrimPrefix(prefix, "/")
+	dir := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = prefix[:i]
+	}
+	var names []string
+	queue := []string{dir}
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		dir, queue = queue[0], queue[1:]
+		entries, err := client.ReadDir(s.path(dir))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, s.check(client, err)
+		}
+		for _, fi := range entries {
+			name := path.Join(dir, fi.Name())
+			switch {
+			case fi.IsDir():
+				if strings.HasPrefix(name+"/", prefix) || strings.HasPrefix(prefix, name+"/") {
+					queue = append(queue, name)
+				}
+			case !isTempFile(name) && strings.HasPrefix(name, prefix):
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+}
+
+func (s *SFTPStore) Healthy(ctx context.Context) error {
+	client, err := s.sftp()
+	if err != nil {
+		return err
+	}
+	_, err = client.Stat(s.path(""))
+	return s.check(client, err)
+}
@@ -1,7 +1,146 @@
 package store
 
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrReadOnly is returned by stores that can't be written to
+var ErrReadOnly = errors.New("store is read-only")
+
+// ErrTooLarge is returned when an object exceeds the configured size
+var ErrTooLarge = errors.New("object exceeds maximum size")
+
+// ErrNotSupported is returned by stores unable to perform an operation
+var ErrNotSupported = errors.New("operation not supported by store")
+
 type Store interface {
-	Get(filename string) ([]byte, error)
-	Put(filename string, buf []byte) error
-	Remove(filename string) error
+	Get(ctx context.Context, filename string) ([]byte, error)
+	Put(ctx context.Context, filename string, buf []byte) error
+	Remove(ctx context.Context, filename string) error
+	// List returns the names of the files starting with prefix, relative to
+	// the root of the store
+	List(ctx context.Context, prefix string) ([]string, error)
+	Stat(ctx context.Context, filename string) (Info, error)
+}
+
+// Checker is implemented by stores able to tell whether their backend is
+// usable, e.g. the disk is writable or the bucket reachable
+type Checker interface {
+	Healthy(ctx context.Context) error
+}
+
+// Healthy checks s if it implements Checker, other stores are assumed healthy
+func Healthy(ctx context.Context, s Store) error {
+	if c, ok := s.(Checker); ok {
+		return c.Healthy(ctx)
+	}
+	return nil
+}
+
+// Info describes a stored file
+type Info struct {
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+	// Hash is the hex sha256 of the content, for stores knowing it
+	Hash string
+}
+
+// contentType guesses the content type of filename from its extension, for
+// stores not keeping track of it
+func contentType(filename string) string {
+	return mime.TypeByExtension(path.Ext(filename))
+}
+
+// cleanKey normalizes filename the way safeJoin does for files, for stores
+// using it as a key
+func cleanKey(filename string) string {
+	return strings.TrimPrefix(path.Clean("/"+filename), "/")
+}
+
+// statFile returns the Info of a file on the local filesystem
+func statFile(fullpath string) (Info, error) {
+	fi, err := os.Stat(fullpath)
+	if err != nil {
+		return Info{}, err
+	}
+	if fi.IsDir() {
+		return Info{}, os.ErrNotExist
+	}
+	return Info{
+		Size:        fi.Size(),
+		ModTime:     fi.ModTime(),
+		ContentType: contentType(fullpath),
+	}, nil
+}
+
+// StreamStore is implemented by stores able to read and write files without
+// buffering them fully in memory
+type StreamStore interface {
+	GetReader(ctx context.Context, filename string) (io.ReadCloser, error)
+	PutReader(ctx context.Context, filename string, r io.Reader) error
+}
+
+// GetReader returns a reader over filename, streaming from stores that
+// support it and falling back to Get otherwise.
+func GetReader(ctx context.Context, s Store, filename string) (io.ReadCloser, error) {
+	if ss, ok := s.(StreamStore); ok {
+		return ss.GetReader(ctx, filename)
+	}
+	buf, err := s.Get(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	if buf == nil {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(buf)), nil
+}
+
+// PutReader writes the content of r to filename, streaming to stores that
+// support it and buffering for the others.
+func PutReader(ctx context.Context, s Store, filename string, r io.Reader) error {
+	if ss, ok := s.(StreamStore); ok {
+		return ss.PutReader(ctx, filename, r)
+	}
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, filename, buf)
+}
+
+// LimitReader returns a reader failing with ErrTooLarge once more than n
+// bytes have been read from r.
+func LimitReader(r io.Reader, n int64) io.Reader {
+	return &limitedReader{r: r, n: n}
+}
+
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, ErrTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
 }
@@ -0,0 +1,18 @@
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestLimitReader(t *testing.T) {
+	buf, err := ioutil.ReadAll(LimitReader(bytes.NewReader([]byte("12345")), 5))
+	if err != nil || string(buf) != "12345" {
+		t.Errorf("LimitReader should accept content of exactly n bytes, got %q, %v", buf, err)
+	}
+	_, err = ioutil.ReadAll(LimitReader(bytes.NewReader([]byte("123456")), 5))
+	if err != ErrTooLarge {
+		t.Errorf("LimitReader should fail with ErrTooLarge, got %v", err)
+	}
+}
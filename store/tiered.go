@@ -0,0 +1,176 @@
+package store
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// Tiered reads through a chain of stores ordered from fastest to slowest
+// (e.g. memory, local disk, S3). The last tier is the authoritative one, a
+// miss in the upper tiers is populated from the first tier holding the file.
+type Tiered struct {
+	Tiers []Store
+}
+
+// NewTiered returns a Tiered store, nil tiers are skipped so optional caches
+// can be passed as is.
+func NewTiered(tiers ...Store) *Tiered {
+	t := &Tiered{}
+	for _, s := range tiers {
+		if s != nil {
+			t.Tiers = append(t.Tiers, s)
+		}
+	}
+	return t
+}
+
+func (t *Tiered) Get(ctx context.Context, filename string) ([]byte, error) {
+	err := os.ErrNotExist
+	for i, s := range t.Tiers {
+		var buf []byte
+		buf, err = s.Get(ctx, filename)
+		if err != nil || buf == nil {
+			continue
+		}
+		for j := 0; j < i; j++ {
+			go t.Tiers[j].Put(context.Background(), filename, buf)
+		}
+		return buf, nil
+	}
+	if err == nil {
+		err = os.ErrNotExist
+	}
+	return nil, err
+}
+
+// GetReader streams filename from the first tier holding it. On a miss in
+// the upper tiers the file is first copied up tier by tier, so the reader
+// returned always comes from the fastest tier.
+func (t *Tiered) GetReader(ctx context.Context, filename string) (io.ReadCloser, error) {
+	err := os.ErrNotExist
+	for i, s := range t.Tiers {
+		var r io.ReadCloser
+		r, err = GetReader(ctx, s, filename)
+		if err != nil {
+			continue
+		}
+		if i == 0 {
+			return r, nil
+		}
+		err = PutReader(ctx, t.Tiers[i-1], filename, r)
+		r.Close()
+		for j := i - 1; err == nil && j > 0; j-- {
+			if r, err = GetReader(ctx, t.Tiers[j], filename); err == nil {
+				err = PutReader(ctx, t.Tiers[j-1], filename, r)
+				r.Close()
+			}
+		}
+		if err == nil {
+			if r, err = GetReader(ctx, t.Tiers[0], filename); err == nil {
+				return r, nil
+			}
+		}
+		// upper tiers could not be populated, serve from the tier holding
+		// the file
+		return GetReader(ctx, s, filename)
+	}
+	return nil, err
+}
+
+// PutReader streams r to the authoritative tier, the upper tiers are
+// invalidated rather than filled since r can only be read once.
+func (t *Tiered) PutReader(ctx context.Context, filename string, r io.Reader) error {
+	last := len(t.Tiers) - 1
+	if last < 0 {
+		return nil
+	}
+	err := PutReader(ctx, t.Tiers[last], filename, r)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < last; i++ {
+		t.Tiers[i].Remove(ctx, filename)
+	}
+	return nil
+}
+
+// Put writes to the authoritative tier first, upper tiers are filled
+// asynchronously once it succeeds.
+func (t *Tiered) Put(ctx context.Context, filename string, buf []byte) error {
+	last := len(t.Tiers) - 1
+	if last < 0 {
+		return nil
+	}
+	err := t.Tiers[last].Put(ctx, filename, buf)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < last; i++ {
+		go t.Tiers[i].Put(context.Background(), filename, buf)
+	}
+	return nil
+}
+
+// Remove removes the file from every tier, only errors from the
+// authoritative tier are reported.
+func (t *Tiered) Remove(ctx context.Context, filename string) error {
+	last := len(t.Tiers) - 1
+	if last < 0 {
+		return nil
+	}
+	for i := 0; i < last; i++ {
+		t.Tiers[i].Remove(ctx, filename)
+	}
+	return t.Tiers[last].Remove(ctx, filename)
+}
+
+// Stat returns the Info of the authoritative tier, copies in the upper
+// tiers have their own modification times which would change the ETag and
+// Last-Modified of the file with the tier serving it
+func (t *Tiered) Stat(ctx context.Context, filename string) (Info, error) {
+	if len(t.Tiers) == 0 {
+		return Info{}, os.ErrNotExist
+	}
+	return t.Tiers[len(t.Tiers)-1].Stat(ctx, filename)
+}
+
+// List lists the authoritative tier
+func (t *Tiered) List(ctx context.Context, prefix string) ([]string, error) {
+	if len(t.Tiers) == 0 {
+		return nil, nil
+	}
+	return t.Tiers[len(t.Tiers)-1].List(ctx, prefix)
+}
+
+func (t *Tiered) PruneCache() error {
+	for _, s := range t.Tiers {
+		if c, ok := s.(Cache); ok {
+			if err := c.PruneCache(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Tiered) LoadCache(walkFn func(item interface{}) error) error {
+	for _, s := range t.Tiers {
+		if c, ok := s.(Cache); ok {
+			if err := c.LoadCache(walkFn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Healthy reports the first unhealthy tier
+func (t *Tiered) Healthy(ctx context.Context) error {
+	for _, s := range t.Tiers {
+		if err := Healthy(ctx, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
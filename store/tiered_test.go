@@ -0,0 +1,111 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTiered_Get(t *testing.T) {
+	ctx := context.Background()
+	testFilename := "/300x300/crop/s/natasha-kasim-708827-unsplash.jpg"
+	tmpdir, err := ioutil.TempDir("../testdata", "TestTiered_Get")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	inbuf, err := ioutil.ReadFile("../testdata" + testFilename)
+	if inbuf == nil || err != nil {
+		t.Errorf("Could not read test file")
+	}
+	upper := NewFileStore(tmpdir + "/upper")
+	lower := NewFileStore(tmpdir + "/lower")
+	lower.Put(ctx, testFilename, inbuf)
+	tiered := NewTiered(upper, nil, lower)
+	if len(tiered.Tiers) != 2 {
+		t.Errorf("nil tiers should be skipped")
+	}
+
+	outbuf, err := tiered.Get(ctx, testFilename)
+	if err != nil || bytes.Compare(inbuf, outbuf) != 0 {
+		t.Errorf("Input and output buffers differ")
+	}
+
+	var cached []byte
+	for i := 0; i < 100 && cached == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		cached, _ = upper.Get(ctx, testFilename)
+	}
+	if bytes.Compare(inbuf, cached) != 0 {
+		t.Errorf("Upper tier was not populated on miss")
+	}
+
+	if _, err := tiered.Get(ctx, "/missing.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Get should return a not exist error for missing files, got %v", err)
+	}
+}
+
+func TestTiered_GetReader(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestTiered_GetReader")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	upper := NewFileStore(tmpdir + "/upper")
+	lower := NewFileStore(tmpdir + "/lower")
+	lower.Put(ctx, "a/b.jpg", []byte("image"))
+
+	for _, tiered := range []*Tiered{
+		NewTiered(upper, lower),
+		NewTiered(&NoopCache{}, lower),
+	} {
+		r, err := tiered.GetReader(ctx, "a/b.jpg")
+		if err != nil {
+			t.Errorf("GetReader failed: %v", err)
+			continue
+		}
+		buf, _ := ioutil.ReadAll(r)
+		r.Close()
+		if string(buf) != "image" {
+			t.Errorf("GetReader returned the wrong content: %q", buf)
+		}
+	}
+	if buf, _ := upper.Get(ctx, "a/b.jpg"); string(buf) != "image" {
+		t.Errorf("Upper tier was not populated on miss")
+	}
+}
+
+func TestTiered_Stat(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir("../testdata", "TestTiered_Stat")
+	if err != nil {
+		t.Errorf("Error creating temp dir")
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	upper := NewFileStore(tmpdir + "/upper")
+	lower := NewFileStore(tmpdir + "/lower")
+	lower.Put(ctx, "a.jpg", []byte("image"))
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(tmpdir+"/lower/a.jpg", past, past)
+	want, err := lower.Stat(ctx, "a.jpg")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	upper.Put(ctx, "a.jpg", []byte("image"))
+	tiered := NewTiered(upper, lower)
+
+	info, err := tiered.Stat(ctx, "a.jpg")
+	if err != nil || info != want {
+		t.Errorf("Stat returned %+v, %v, want the authoritative %+v", info, err, want)
+	}
+	if _, err := tiered.Stat(ctx, "missing.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Stat should return a not exist error for missing files, got %v", err)
+	}
+}
@@ -1,44 +1,54 @@
 package store
 
+import "context"
+
 type TwoTier struct {
 	Store Store
 	Cache Cache
 }
 
-func (s *TwoTier) Get(filename string) ([]byte, error) {
+func (s *TwoTier) Get(ctx context.Context, filename string) ([]byte, error) {
 	var buf []byte
 	var err error
 	if s.Cache != nil {
-		buf, _ = s.Cache.Get(filename)
+		buf, _ = s.Cache.Get(ctx, filename)
 	}
 	if buf == nil {
-		buf, err = s.Store.Get(filename)
+		buf, err = s.Store.Get(ctx, filename)
 		if err != nil {
 			return nil, err
 		}
 		if s.Cache != nil {
-			go s.Cache.Put(filename, buf)
+			go s.Cache.Put(context.Background(), filename, buf)
 		}
 	}
 	return buf, nil
 }
 
-func (s *TwoTier) Put(filename string, data []byte) error {
-	err := s.Store.Put(filename, data)
+func (s *TwoTier) Put(ctx context.Context, filename string, data []byte) error {
+	err := s.Store.Put(ctx, filename, data)
 	if err != nil {
 		return err
 	}
 	if s.Cache != nil {
-		go s.Cache.Put(filename, data)
+		go s.Cache.Put(context.Background(), filename, data)
 	}
 	return nil
 }
 
-func (s *TwoTier) Remove(filename string) error {
+func (s *TwoTier) Remove(ctx context.Context, filename string) error {
 	if s.Cache != nil {
-		go s.Cache.Remove(filename)
+		go s.Cache.Remove(context.Background(), filename)
 	}
-	return s.Store.Remove(filename)
+	return s.Store.Remove(ctx, filename)
+}
+
+func (s *TwoTier) List(ctx context.Context, prefix string) ([]string, error) {
+	return s.Store.List(ctx, prefix)
+}
+
+func (s *TwoTier) Stat(ctx context.Context, filename string) (Info, error) {
+	return s.Store.Stat(ctx, filename)
 }
 
 func (s *TwoTier) PruneCache() error {
@@ -54,3 +64,10 @@ func (s *TwoTier) LoadCache(walkFn func(item interface{}) error) error {
 	}
 	return s.Cache.LoadCache(walkFn)
 }
+
+func (s *TwoTier) Healthy(ctx context.Context) error {
+	if err := Healthy(ctx, s.Cache); err != nil {
+		return err
+	}
+	return Healthy(ctx, s.Store)
+}
@@ -2,12 +2,14 @@ package store
 
 import (
 	"bytes"
+	"context"
 	"io/ioutil"
 	"os"
 	"testing"
 )
 
 func TestTwoTier_Get(t *testing.T) {
+	ctx := context.Background()
 	testFilename := "/300x300/crop/s/natasha-kasim-708827-unsplash.jpg"
 	tmpdir, err := ioutil.TempDir("../testdata", "TestTwoTier_Get")
 	if err != nil {
@@ -20,13 +22,13 @@ func TestTwoTier_Get(t *testing.T) {
 		t.Errorf("Could not read test file")
 	}
 	fs := NewFileStore(tmpdir)
-	fs.Put(testFilename, inbuf)
+	fs.Put(ctx, testFilename, inbuf)
 	twotier := &TwoTier{
 		Store: fs,
 		Cache: nil,
 	}
 
-	outbuf, err := twotier.Get(testFilename)
+	outbuf, err := twotier.Get(ctx, testFilename)
 	if bytes.Compare(inbuf, outbuf) != 0 {
 		t.Errorf("Input and output buffers differ")
 	}
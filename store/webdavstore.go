@@ -0,0 +1,290 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// WebDAVStore keeps files on a WebDAV server such as Nextcloud or ownCloud
+type WebDAVStore struct {
+	base     *url.URL
+	username string
+	password string
+	client   *http.Client
+}
+
+type WebDAVConfig struct {
+	URL      string
+	Username string
+	Password string
+	Timeout  time.Duration
+}
+
+func NewWebDAVStore(config *WebDAVConfig) (*WebDAVStore, error) {
+	u, err := url.Parse(strings.TrimSuffix(config.URL, "/") + "/")
+	if err != nil {
+		return nil, err
+	}
+	return &WebDAVStore{
+		base:     u,
+		username: config.Username,
+		password: config.Password,
+		client:   &http.Client{Timeout: config.Timeout},
+	}, nil
+}
+
+func (s *WebDAVStore) url(filename string) string {
+	u := *s.base
+	u.Path = path.Join(u.Path, cleanKey(filename))
+	if strings.HasSuffix(filename, "/") {
+		u.Path += "/"
+	}
+	return u.String()
+}
+
+func (s *WebDAVStore) do(ctx context.Context, method string, filename string,
+	body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.url(filename), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	return s.client.Do(req.WithContext(ctx))
+}
+
+// webdavError maps the status code of res to an error, closing its body
+// unless the request succeeded
+func webdavError(res *http.Response, method string) error {
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return nil
+	case res.StatusCode == http.StatusNotFound:
+		res.Body.Close()
+		return os.ErrNotExist
+	default:
+		res.Body.Close()
+		return fmt.Errorf("webdav %s: %s", method, res.Status)
+	}
+}
+
+func (s *WebDAVStore) GetReader(ctx context.Context, filename string) (io.ReadCloser, error) {
+	res, err := s.do(ctx, http.MethodGet, filename, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := webdavError(res, http.MethodGet); err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (s *WebDAVStore) Get(ctx context.Context, filename string) ([]byte, error) {
+	r, err := s.GetReader(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (s *WebDAVStore) Put(ctx context.Context, filename string, buf []byte) error {
+	return s.PutReader(ctx, filename, bytes.NewReader(buf))
+}
+
+// PutReader creates the missing parent collections first, WebDAV servers
+// don't create them implicitly
+func (s *WebDAVStore) PutReader(ctx context.Context, filename string, r io.Reader) error {
+	if err := s.mkdirAll(ctx, path.Dir(cleanKey(filename))); err != nil {
+		return err
+	}
+	res, err := s.do(ctx, http.MethodPut, filename, r, nil)
+	if err != nil {
+		return err
+	}
+	if err := webdavError(res, http.MethodPut); err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+func (s *WebDAVStore) mkdirAll(ctx context.Context, dir string) error {
+	if dir == "." || dir == "/" || dir == "" {
+		return nil
+	}
+	if _, err := s.propfind(ctx, dir+"/", "0"); err == nil {
+		return nil
+	}
+	if err := s.mkdirAll(ctx, path.Dir(dir)); err != nil {
+		return err
+	}
+	res, err := s.do(ctx, "MKCOL", dir+"/", nil, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	// 405 means the collection was created meanwhile
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusMethodNotAllowed {
+		return fmt.Errorf("webdav MKCOL: %s", res.Status)
+	}
+	return nil
+}
+
+func (s *WebDAVStore) Remove(ctx context.Context, filename string) error {
+	res, err := s.do(ctx, http.MethodDelete, filename, nil, nil)
+	if err != nil {
+		return err
+	}
+	if err := webdavError(res, http.MethodDelete); err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+type davMultistatus struct {
+	Responses []davResponse `xml:"response"`
+}
+
+type davResponse struct {
+	Href     string `xml:"href"`
+	Propstat []struct {
+		Prop struct {
+			ContentLength int64  `xml:"getcontentlength"`
+			LastModified  string `xml:"getlastmodified"`
+			ContentType   string `xml:"getcontenttype"`
+			ResourceType  struct {
+				Collection *struct{} `xml:"collection"`
+			} `xml:"resourcetype"`
+		} `xml:"prop"`
+		Status string `xml:"status"`
+	} `xml:"propstat"`
+}
+
+const davPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop>
+<getcontentlength/><getlastmodified/><getcontenttype/><resourcetype/>
+</prop></propfind>`
+
+// davEntry is a file or collection listed by PROPFIND
+type davEntry struct {
+	name  string
+	dir   bool
+	info  Info
+	found bool
+}
+
+// propfind returns the entries of filename, with its children when depth
+// is "1". Names are relative to the root of the store.
+func (s *WebDAVStore) propfind(ctx context.Context, filename string, depth string) ([]davEntry, error) {
+	res, err := s.do(ctx, "PROPFIND", filename, strings.NewReader(davPropfind), http.Header{
+		"Depth":        {depth},
+		"Content-Type": {"application/xml; charset=utf-8"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := webdavError(res, "PROPFIND"); err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var ms davMultistatus
+	if err := xml.NewDecoder(res.Body).Decode(&ms); err != nil {
+		return nil, err
+	}
+	var entries []davEntry
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			continue
+		}
+		name := strings.TrimPrefix(href.Path, s.base.Path)
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			e := davEntry{
+				name: strings.Trim(name, "/"),
+				dir:  ps.Prop.ResourceType.Collection != nil,
+				info: Info{
+					Size:        ps.Prop.ContentLength,
+					ContentType: ps.Prop.ContentType,
+				},
+				found: true,
+			}
+			e.info.ModTime, _ = http.ParseTime(ps.Prop.LastModified)
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func (s *WebDAVStore) Stat(ctx context.Context, filename string) (Info, error) {
+	entries, err := s.propfind(ctx, filename, "0")
+	if err != nil {
+		return Info{}, err
+	}
+	if len(entries) == 0 || entries[0].dir {
+		return Info{}, os.ErrNotExist
+	}
+	info := entries[0].info
+	if info.ContentType == "" {
+		info.ContentType = contentType(filename)
+	}
+	return info, nil
+}
+
+// List walks the collections below the deepest directory of prefix, one
+// level at a time since servers often refuse infinite depth
+func (s *WebDAVStore) List(ctx context.Context, prefix string) ([]string, error) {
+	prefix = strings.TrimPrefix(prefix, "/")
+	dir := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = prefix[:i]
+	}
+	var names []string
+	queue := []string{dir}
+	for len(queue) > 0 {
+		dir, queue = queue[0], queue[1:]
+		entries, err := s.propfind(ctx, dir+"/", "1")
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.name == dir {
+				// the collection itself
+				continue
+			}
+			switch {
+			case e.dir && (strings.HasPrefix(e.name+"/", prefix) || strings.HasPrefix(prefix, e.name+"/")):
+				queue = append(queue, e.name)
+			case !e.dir && strings.HasPrefix(e.name, prefix):
+				names = append(names, e.name)
+			}
+		}
+	}
+	return names, nil
+}
+
+func (s *WebDAVStore) Healthy(ctx context.Context) error {
+	_, err := s.propfind(ctx, "/", "0")
+	return err
+}
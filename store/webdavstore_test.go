@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDAV is a minimal WebDAV server keeping files in memory under /dav/
+type fakeDAV struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	dirs  map[string]bool
+}
+
+func (d *fakeDAV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/dav/"), "/")
+	switch r.Method {
+	case http.MethodGet:
+		buf, ok := d.files[p]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(buf)
+	case http.MethodPut:
+		if parent := dirOf(p); parent != "" && !d.dirs[parent] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		d.files[p], _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	case "MKCOL":
+		d.dirs[p] = true
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		delete(d.files, p)
+		w.WriteHeader(http.StatusNoContent)
+	case "PROPFIND":
+		entry := func(name string, dir bool, size int) string {
+			rt := ""
+			if dir {
+				rt = "<d:collection/>"
+			}
+			return fmt.Sprintf(`<d:response><d:href>/dav/%s</d:href><d:propstat><d:prop>`+
+				`<d:getcontentlength>%d</d:getcontentlength><d:resourcetype>%s</d:resourcetype>`+
+				`</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`, name, size, rt)
+		}
+		var out []string
+		if buf, ok := d.files[p]; ok {
+			out = append(out, entry(p, false, len(buf)))
+		} else if p == "" || d.dirs[p] {
+			out = append(out, entry(p, true, 0))
+			if r.Header.Get("Depth") == "1" {
+				for name, buf := range d.files {
+					if dirOf(name) == p {
+						out = append(out, entry(name, false, len(buf)))
+					}
+				}
+				for name := range d.dirs {
+					if dirOf(name) == p {
+						out = append(out, entry(name, true, 0))
+					}
+				}
+			}
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(207)
+		fmt.Fprintf(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:">%s</d:multistatus>`,
+			strings.Join(out, ""))
+	}
+}
+
+func dirOf(name string) string {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+func TestWebDAVStore(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(&fakeDAV{files: map[string][]byte{}, dirs: map[string]bool{}})
+	defer srv.Close()
+	s, err := NewWebDAVStore(&WebDAVConfig{URL: srv.URL + "/dav"})
+	if err != nil {
+		t.Fatalf("NewWebDAVStore failed: %v", err)
+	}
+
+	if err := s.Put(ctx, "a/b/c.jpg", []byte("image")); err != nil {
+		t.Errorf("Put failed: %v", err)
+	}
+	s.Put(ctx, "a/d.jpg", []byte("image"))
+	if buf, err := s.Get(ctx, "/a/b/c.jpg"); err != nil || string(buf) != "image" {
+		t.Errorf("Get returned %q, %v", buf, err)
+	}
+	if info, err := s.Stat(ctx, "a/b/c.jpg"); err != nil || info.Size != 5 {
+		t.Errorf("Stat returned %v, %v", info, err)
+	}
+	if _, err := s.Stat(ctx, "missing.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Stat should return os.ErrNotExist for missing files, got %v", err)
+	}
+	names, err := s.List(ctx, "a/")
+	if err != nil || len(names) != 2 {
+		t.Errorf("List returned %v, %v", names, err)
+	}
+	if names, _ := s.List(ctx, "a/b"); len(names) != 1 || names[0] != "a/b/c.jpg" {
+		t.Errorf("List returned %v", names)
+	}
+}
@@ -0,0 +1,67 @@
+package upscale
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Upscaler enlarges images by an integer factor, e.g. with a
+// super-resolution model such as ESRGAN. Results are expected to be
+// auto-oriented.
+type Upscaler interface {
+	Upscale(ctx context.Context, buf []byte, factor int) ([]byte, error)
+}
+
+// HTTP posts images to a model server at URL, which responds with them
+// upscaled by the factor query parameter
+type HTTP struct {
+	URL     string
+	MaxSize int64
+	client  *http.Client
+}
+
+func NewHTTP(url string, timeout time.Duration, maxSize int64) *HTTP {
+	return &HTTP{URL: url, MaxSize: maxSize, client: &http.Client{Timeout: timeout}}
+}
+
+func (h *HTTP) Upscale(ctx context.Context, buf []byte, factor int) ([]byte, error) {
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	query.Set("factor", strconv.Itoa(factor))
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", http.DetectContentType(buf))
+	resp, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("upscaler responded " + resp.Status)
+	}
+	var body io.Reader = resp.Body
+	if h.MaxSize > 0 {
+		body = io.LimitReader(resp.Body, h.MaxSize+1)
+	}
+	upscaled, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if h.MaxSize > 0 && int64(len(upscaled)) > h.MaxSize {
+		return nil, errors.New("upscaled image too large")
+	}
+	return upscaled, nil
+}
@@ -0,0 +1,96 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FFmpeg runs the ffmpeg binary at Path on temporary files
+type FFmpeg struct {
+	Path string
+}
+
+func NewFFmpeg(path string) *FFmpeg {
+	return &FFmpeg{Path: path}
+}
+
+// encoders holds the output arguments of the video formats, dimensions are
+// rounded down to even numbers as yuv420p requires
+var encoders = map[string][]string{
+	"mp4": {
+		"-c:v", "libx264", "-pix_fmt", "yuv420p", "-movflags", "+faststart",
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2",
+	},
+	"webm": {
+		"-c:v", "libvpx-vp9", "-pix_fmt", "yuv420p", "-b:v", "0", "-crf", "40",
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2",
+	},
+}
+
+// Transcode converts an animated GIF to format, mp4 or webm
+func (f *FFmpeg) Transcode(ctx context.Context, gif []byte, format string) ([]byte, error) {
+	encoder, ok := encoders[format]
+	if !ok {
+		return nil, errors.New("unsupported video format " + format)
+	}
+	dir, err := ioutil.TempDir("", "imageresizer-video")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in.gif")
+	if err := ioutil.WriteFile(in, gif, 0600); err != nil {
+		return nil, err
+	}
+	out := filepath.Join(dir, "out."+format)
+	args := append([]string{"-f", "gif", "-i", in}, encoder...)
+	if err := f.run(ctx, append(args, out)...); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(out)
+}
+
+// Frame extracts the frame of video at the timestamp at, as a JPEG
+func (f *FFmpeg) Frame(ctx context.Context, video []byte, at time.Duration) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "imageresizer-video")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in")
+	if err := ioutil.WriteFile(in, video, 0600); err != nil {
+		return nil, err
+	}
+	out := filepath.Join(dir, "frame.jpg")
+	// seeking before the input is fast, it starts from the closest keyframe
+	if err := f.run(ctx, "-ss", strconv.FormatFloat(at.Seconds(), 'f', 3, 64), "-i", in,
+		"-frames:v", "1", "-q:v", "2", out); err != nil {
+		return nil, err
+	}
+	buf, err := ioutil.ReadFile(out)
+	if os.IsNotExist(err) {
+		return nil, errors.New("no frame at " + at.String())
+	}
+	return buf, err
+}
+
+func (f *FFmpeg) run(ctx context.Context, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, f.Path, append([]string{"-hide_banner", "-loglevel", "error", "-y"}, args...)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return errors.New("ffmpeg: " + msg)
+		}
+		return err
+	}
+	return nil
+}